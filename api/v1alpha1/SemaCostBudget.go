@@ -0,0 +1,86 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SemaCostBudgetSpec defines a spending budget for the LLM traffic of the
+// budget's namespace
+type SemaCostBudgetSpec struct {
+	// Limit is the spend allowed per period, as a decimal (e.g. "500", "1250.50")
+	// +kubebuilder:validation:Pattern=`^[0-9]+(\.[0-9]+)?$`
+	Limit string `json:"limit"`
+
+	// Currency of Limit. Costs are estimated from provider list prices in USD.
+	// +kubebuilder:validation:Enum=USD
+	// +kubebuilder:default="USD"
+	Currency string `json:"currency,omitempty"`
+
+	// Period the spend is accounted over. Periods start at 00:00 UTC: every
+	// day, on Mondays, or on the 1st of the month.
+	// +kubebuilder:validation:Enum=Daily;Weekly;Monthly
+	// +kubebuilder:default="Monthly"
+	Period string `json:"period,omitempty"`
+
+	// Team narrows the budget to pods whose promoted "team" label has this
+	// value (see --promote-labels). Empty counts the whole namespace.
+	// +optional
+	Team string `json:"team,omitempty"`
+
+	// Thresholds, in percent of Limit, that send a notification when crossed
+	// +kubebuilder:default={50,80,100}
+	Thresholds []int `json:"thresholds,omitempty"`
+
+	// HardStop rejects requests once Limit is reached, until the next period
+	// +optional
+	HardStop bool `json:"hardStop,omitempty"`
+}
+
+// SemaCostBudgetStatus defines the observed state
+type SemaCostBudgetStatus struct {
+	// PeriodStart is when the current period began
+	PeriodStart metav1.Time `json:"periodStart,omitempty"`
+
+	// Spent is the spend so far in the current period, over all nodes
+	Spent string `json:"spent,omitempty"`
+
+	// Forecast is the spend expected at the end of the period at the
+	// current burn rate
+	Forecast string `json:"forecast,omitempty"`
+
+	// Phase indicates if the budget is "Active", "Warning" (a threshold was
+	// crossed or an overspend is forecast), or "Exhausted"
+	Phase string `json:"phase,omitempty"`
+
+	// Nodes holds each node agent's share of Spent: an agent only sees the
+	// traffic of its own node
+	Nodes map[string]NodeSpend `json:"nodes,omitempty"`
+
+	// NotifiedThresholds were already notified in the current period
+	NotifiedThresholds []int `json:"notifiedThresholds,omitempty"`
+
+	// ForecastNotified is set once an overspend forecast was notified in the
+	// current period
+	ForecastNotified bool `json:"forecastNotified,omitempty"`
+}
+
+// NodeSpend is the spend a node agent accounted to a budget
+type NodeSpend struct {
+	PeriodStart metav1.Time `json:"periodStart"`
+	Spent       string      `json:"spent"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Limit",type=string,JSONPath=`.spec.limit`
+// +kubebuilder:printcolumn:name="Spent",type=string,JSONPath=`.status.spent`
+// +kubebuilder:printcolumn:name="Forecast",type=string,JSONPath=`.status.forecast`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// SemaCostBudget is the Schema for the semacostbudgets API
+type SemaCostBudget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SemaCostBudgetSpec   `json:"spec,omitempty"`
+	Status SemaCostBudgetStatus `json:"status,omitempty"`
+}
@@ -41,6 +41,11 @@ type PolicyRule struct {
     // PauseSettings: Only used if Action is PAUSE
     // +optional
     PauseSettings *PauseSettings `json:"pauseSettings,omitempty"`
+
+    // SemanticCache: Prompts matching this rule may be answered with the
+    // cached response to a similar prompt (the response cache's semantic tier)
+    // +optional
+    SemanticCache bool `json:"semanticCache,omitempty"`
 }
 
 type PauseSettings struct {
@@ -4,61 +4,287 @@
 #include <sys/socket.h>
 #include <linux/in.h>
 
+// SemaMesh transparent redirection.
+//
+// 1. cgroup/connect4 + connect6: when an opted-in pod (its container cgroup
+//    in sema_intercept_cgroups) connects to an LLM provider (destination in
+//    sema_targets4/6, port in sema_ports), rewrite the destination to the
+//    local waypoint and remember the original one, keyed by socket cookie.
+// 2. sockops: once the connection is established we know its source
+//    address/port, which is what the waypoint sees as RemoteAddr. Move the
+//    original destination to sema_orig_dst under that key so the waypoint
+//    can look it up (our SO_ORIGINAL_DST).
+//
+// 3. Fail-open: the agent bumps sema_heartbeat while the waypoint is healthy.
+//    When it goes stale, connections from fail-open cgroups go straight to the
+//    provider (counted in sema_bypass_count); fail-closed cgroups keep being
+//    redirected, so they get "connection refused" instead of uninspected access.
+//
+// Keep the struct layouts in sync with internal/agent/maps.go.
+
 // --- STRUCT DEFINITIONS (MUST BE AT THE TOP) ---
 
-// Key to identify a specific connection
-struct sock_key {
-    __u32 sip4;   // Source IP
-    __u32 dip4;   // Destination IP
-    __u32 sport;  // Source Port
-    __u32 dport;  // Destination Port
-    __u32 family; // Protocol Family (TCP/UDP)
+// sema_intercept_cgroups values
+#define SEMA_INTERCEPT   1
+#define SEMA_FAIL_CLOSED 2
+
+// Where to redirect to, written by the node agent (single entry)
+struct sema_config {
+    __u32 redirect_ip4;     // Waypoint IPv4 (network order)
+    __u32 redirect_ip6[4];  // Waypoint IPv6 (network order), all zero = no IPv6
+    __u32 redirect_port;    // Waypoint port (host order)
+    __u64 stale_ns;         // Heartbeat age after which fail-open kicks in (0 = never)
+};
+
+// LPM trie keys for destination CIDRs
+struct lpm_key4 {
+    __u32 prefixlen;
+    __u32 addr;
+};
+
+struct lpm_key6 {
+    __u32 prefixlen;
+    __u32 addr[4];
 };
 
-// Value to store the original destination
+// The destination the client originally asked for
 struct origin_info {
-    __u32 ip;     // Original IP
-    __u32 port;   // Original Port
+    __u32 family;   // AF_INET or AF_INET6
+    __u32 ip4;      // Network order
+    __u32 ip6[4];   // Network order
+    __u32 port;     // Host order
+};
+
+// Key to identify a redirected connection, as seen by the waypoint
+struct sock_key {
+    __u32 family;
+    __u32 sip4;     // Source IP (network order)
+    __u32 sip6[4];
+    __u32 sport;    // Source port (host order)
 };
 
 // --- MAP DEFINITIONS ---
 
-// Helper macro to define maps (Standard libbpf syntax)
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct sema_config);
+} sema_config_map SEC(".maps");
+
+// Destinations to capture (provider IPs / CIDRs)
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 4096);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct lpm_key4);
+    __type(value, __u32);
+} sema_targets4 SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 4096);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct lpm_key6);
+    __type(value, __u32);
+} sema_targets6 SEC(".maps");
+
+// Destination ports to capture (host order), e.g. 443 and 80
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 64);
+    __type(key, __u32);
+    __type(value, __u32);
+} sema_ports SEC(".maps");
+
+// cgroup IDs (pod and container cgroups) whose connections are intercepted,
+// with SEMA_* flags. Anything else, including the node's own processes, is
+// left alone.
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 16384);
+    __type(key, __u64);
+    __type(value, __u32);
+} sema_intercept_cgroups SEC(".maps");
+
+// Last time (bpf_ktime_get_ns) the agent saw the waypoint healthy
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} sema_heartbeat SEC(".maps");
+
+// Connections that bypassed the waypoint (fail-open), by cgroup ID
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 16384);
+    __type(key, __u64);
+    __type(value, __u64);
+} sema_bypass_count SEC(".maps");
+
+// Sockets that must never be redirected (the waypoint's own upstream calls).
+// The agent inserts the cookie right before connect().
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65535);
+    __type(key, __u64);
+    __type(value, __u32);
+} sema_skip_cookies SEC(".maps");
+
+// Original destination by socket cookie, until the connection is established
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65535);
+    __type(key, __u64);
+    __type(value, struct origin_info);
+} sema_cookie_orig SEC(".maps");
+
+// Original destination by source address, queried by the waypoint
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
     __uint(max_entries, 65535);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
     __type(key, struct sock_key);
     __type(value, struct origin_info);
-} proxy_map SEC(".maps");
+} sema_orig_dst SEC(".maps");
 
-// --- MAIN PROGRAM ---
+// --- HELPERS ---
 
-SEC("sk_msg")
-int sema_redirect(struct sk_msg_md *msg)
+static __always_inline int should_skip(void *ctx, struct sema_config *cfg, __u32 protocol, __u32 dport)
 {
-    // Now the compiler knows what 'struct sock_key' looks like!
-    struct sock_key key = {0};
+    if (protocol != IPPROTO_TCP)
+        return 1;
+
+    __u32 port = dport;
+    if (!bpf_map_lookup_elem(&sema_ports, &port))
+        return 1;
+
+    __u64 cgroup_id = bpf_get_current_cgroup_id();
+    __u32 *flags = bpf_map_lookup_elem(&sema_intercept_cgroups, &cgroup_id);
+    if (!flags || !(*flags & SEMA_INTERCEPT))
+        return 1;
+
+    __u64 cookie = bpf_get_socket_cookie(ctx);
+    if (bpf_map_lookup_elem(&sema_skip_cookies, &cookie)) {
+        bpf_map_delete_elem(&sema_skip_cookies, &cookie);
+        return 1;
+    }
 
-    // Extract key details from the message metadata
-    key.sip4 = msg->remote_ip4;
-    key.dip4 = msg->local_ip4;
-    key.sport = msg->remote_port;
-    key.dport = msg->local_port;
-    key.family = msg->family;
-
-    // Look up if this connection should be redirected
-    struct origin_info *val = bpf_map_lookup_elem(&proxy_map, &key);
-
-    if (val) {
-        // Redirect the traffic to the proxy port
-        long ret = bpf_msg_redirect_hash(msg, &proxy_map, &key, BPF_F_INGRESS);
-        if (ret != 0) {
-            bpf_printk("SemaMesh: Redirect failed: %ld\n", ret);
+    // Waypoint unhealthy: fail-open cgroups go direct
+    if (cfg->stale_ns && !(*flags & SEMA_FAIL_CLOSED)) {
+        __u32 zero = 0;
+        __u64 *beat = bpf_map_lookup_elem(&sema_heartbeat, &zero);
+        if (!beat || bpf_ktime_get_ns() - *beat > cfg->stale_ns) {
+            __u64 *count = bpf_map_lookup_elem(&sema_bypass_count, &cgroup_id);
+            if (count) {
+                __sync_fetch_and_add(count, 1);
+            } else {
+                __u64 one = 1;
+                bpf_map_update_elem(&sema_bypass_count, &cgroup_id, &one, BPF_NOEXIST);
+            }
+            return 1;
         }
-        return (int)ret;
+    }
+    return 0;
+}
+
+// --- PROGRAMS ---
+
+SEC("cgroup/connect4")
+int sema_connect4(struct bpf_sock_addr *ctx)
+{
+    __u32 zero = 0;
+    struct sema_config *cfg = bpf_map_lookup_elem(&sema_config_map, &zero);
+    if (!cfg || !cfg->redirect_port)
+        return 1;
+
+    __u32 dport = bpf_ntohs((__u16)ctx->user_port);
+    struct lpm_key4 key = {.prefixlen = 32, .addr = ctx->user_ip4};
+    if (!bpf_map_lookup_elem(&sema_targets4, &key))
+        return 1;
+    if (should_skip(ctx, cfg, ctx->protocol, dport))
+        return 1;
+
+    struct origin_info orig = {0};
+    orig.family = AF_INET;
+    orig.ip4 = ctx->user_ip4;
+    orig.port = dport;
+
+    __u64 cookie = bpf_get_socket_cookie(ctx);
+    bpf_map_update_elem(&sema_cookie_orig, &cookie, &orig, BPF_ANY);
+
+    ctx->user_ip4 = cfg->redirect_ip4;
+    ctx->user_port = bpf_htons((__u16)cfg->redirect_port);
+    return 1;
+}
+
+SEC("cgroup/connect6")
+int sema_connect6(struct bpf_sock_addr *ctx)
+{
+    __u32 zero = 0;
+    struct sema_config *cfg = bpf_map_lookup_elem(&sema_config_map, &zero);
+    if (!cfg || !cfg->redirect_port)
+        return 1;
+    if (!(cfg->redirect_ip6[0] | cfg->redirect_ip6[1] | cfg->redirect_ip6[2] | cfg->redirect_ip6[3]))
+        return 1;
+
+    __u32 dport = bpf_ntohs((__u16)ctx->user_port);
+    struct lpm_key6 key = {.prefixlen = 128};
+    key.addr[0] = ctx->user_ip6[0];
+    key.addr[1] = ctx->user_ip6[1];
+    key.addr[2] = ctx->user_ip6[2];
+    key.addr[3] = ctx->user_ip6[3];
+    if (!bpf_map_lookup_elem(&sema_targets6, &key))
+        return 1;
+    if (should_skip(ctx, cfg, ctx->protocol, dport))
+        return 1;
+
+    struct origin_info orig = {0};
+    orig.family = AF_INET6;
+    orig.ip6[0] = key.addr[0];
+    orig.ip6[1] = key.addr[1];
+    orig.ip6[2] = key.addr[2];
+    orig.ip6[3] = key.addr[3];
+    orig.port = dport;
+
+    __u64 cookie = bpf_get_socket_cookie(ctx);
+    bpf_map_update_elem(&sema_cookie_orig, &cookie, &orig, BPF_ANY);
+
+    ctx->user_ip6[0] = cfg->redirect_ip6[0];
+    ctx->user_ip6[1] = cfg->redirect_ip6[1];
+    ctx->user_ip6[2] = cfg->redirect_ip6[2];
+    ctx->user_ip6[3] = cfg->redirect_ip6[3];
+    ctx->user_port = bpf_htons((__u16)cfg->redirect_port);
+    return 1;
+}
+
+SEC("sockops")
+int sema_sockops(struct bpf_sock_ops *skops)
+{
+    if (skops->op != BPF_SOCK_OPS_ACTIVE_ESTABLISHED_CB)
+        return 1;
+
+    __u64 cookie = bpf_get_socket_cookie(skops);
+    struct origin_info *orig = bpf_map_lookup_elem(&sema_cookie_orig, &cookie);
+    if (!orig)
+        return 1;
+
+    struct sock_key key = {0};
+    key.family = skops->family;
+    key.sport = skops->local_port; // Already host order for local_port
+    if (skops->family == AF_INET) {
+        key.sip4 = skops->local_ip4;
+    } else {
+        key.sip6[0] = skops->local_ip6[0];
+        key.sip6[1] = skops->local_ip6[1];
+        key.sip6[2] = skops->local_ip6[2];
+        key.sip6[3] = skops->local_ip6[3];
     }
 
-    return SK_PASS;
+    bpf_map_update_elem(&sema_orig_dst, &key, orig, BPF_ANY);
+    bpf_map_delete_elem(&sema_cookie_orig, &cookie);
+    return 1;
 }
 
-char _license[] SEC("license") = "GPL";
\ No newline at end of file
+char _license[] SEC("license") = "GPL";
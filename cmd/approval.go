@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/semamesh/semamesh/internal/controller"
+)
+
+// approvalFlags configure the approve/reject endpoint of pause notifications
+type approvalFlags struct {
+	addr           string
+	baseURL        string
+	secretFile     string
+	approvers      string
+	identityHeader string
+}
+
+// setup builds the approval server, or returns nil when it is off
+func (f *approvalFlags) setup(c client.Client) (*controller.ApprovalServer, error) {
+	if f.addr == "" {
+		return nil, nil
+	}
+	if f.baseURL == "" {
+		return nil, fmt.Errorf("--approval-addr requires --approval-url")
+	}
+
+	secret := []byte(os.Getenv("SEMAMESH_APPROVAL_SECRET"))
+	if f.secretFile != "" {
+		raw, err := os.ReadFile(f.secretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read approval secret: %v", err)
+		}
+		secret = []byte(strings.TrimSpace(string(raw)))
+	}
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("the approval secret must be at least 32 bytes (--approval-secret-file or $SEMAMESH_APPROVAL_SECRET)")
+	}
+
+	s := &controller.ApprovalServer{
+		Client:             c,
+		BaseURL:            f.baseURL,
+		Secret:             secret,
+		Approvers:          make(map[string]bool),
+		IdentityHeader:     f.identityHeader,
+		SlackSigningSecret: []byte(os.Getenv("SLACK_SIGNING_SECRET")),
+	}
+	for _, a := range strings.Split(f.approvers, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			s.Approvers[a] = true
+		}
+	}
+	if len(s.Approvers) > 0 && s.IdentityHeader == "" && len(s.SlackSigningSecret) == 0 {
+		return nil, fmt.Errorf("--approvers requires --approval-identity-header or $SLACK_SIGNING_SECRET to tell who approves")
+	}
+	return s, nil
+}
+
+// serve runs the approval endpoint. Every replica serves it: decisions are
+// written to the pod and acted on by the leader.
+func (f *approvalFlags) serve(s *controller.ApprovalServer) {
+	go func() {
+		setupLog.Info("starting approval endpoint", "addr", f.addr, "url", f.baseURL)
+		if err := http.ListenAndServe(f.addr, s.Handler()); err != nil {
+			setupLog.Error(err, "approval endpoint failed")
+			os.Exit(1)
+		}
+	}()
+}
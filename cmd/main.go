@@ -12,10 +12,12 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	// Import YOUR local packages
 	semav1alpha1 "github.com/semamesh/semamesh/api/v1alpha1"
 	"github.com/semamesh/semamesh/internal/controller"
+	"github.com/semamesh/semamesh/pkg/notify"
 )
 
 var (
@@ -35,11 +37,20 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var notifyConfig string
+	var approval approvalFlags
 
 	// Standard CLI flags for a production operator
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false, "Enable leader election for high availability.")
+	flag.StringVar(&notifyConfig, "notify-config", os.Getenv("NOTIFY_CONFIG"), "Notification channels and routes for pause events (YAML, see pkg/notify).")
+
+	flag.StringVar(&approval.addr, "approval-addr", "", "Serve approve/reject actions of pause notifications on this address (e.g. :8090). Empty disables them.")
+	flag.StringVar(&approval.baseURL, "approval-url", "", "Public base URL of the approval endpoint, used in notification links.")
+	flag.StringVar(&approval.secretFile, "approval-secret-file", "", "File holding the key that signs approval links (default: $SEMAMESH_APPROVAL_SECRET).")
+	flag.StringVar(&approval.approvers, "approvers", "", "Comma-separated approvers (e-mails from --approval-identity-header, Slack user names or IDs). Empty allows anyone with a valid link.")
+	flag.StringVar(&approval.identityHeader, "approval-identity-header", "", "Header naming the approver, set by an authenticating proxy (e.g. X-Forwarded-Email).")
 
 	opts := zap.Options{Development: true}
 	opts.BindFlags(flag.CommandLine)
@@ -47,6 +58,11 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if err := notify.Setup(notifyConfig); err != nil {
+		setupLog.Error(err, "unable to set up notifications")
+		os.Exit(1)
+	}
+
 	// 3. Create the Manager
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
@@ -62,15 +78,29 @@ func main() {
 	}
 
 	// 4. Initialize the Controller (The "Officer")
+	approvals, err := approval.setup(mgr.GetClient())
+	if err != nil {
+		setupLog.Error(err, "unable to set up approvals")
+		os.Exit(1)
+	}
 	if err = (&controller.SemaReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		Recorder: mgr.GetEventRecorderFor("sema-controller"), // NEW: The Event Recorder
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("sema-controller"), // NEW: The Event Recorder
+		Approvals: approvals,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Sema")
 		os.Exit(1)
 	}
 
+	// 4a. Approve/reject from chat, pager or mail
+	if approvals != nil {
+		approval.serve(approvals)
+	}
+
+	// 4b. Export SemaTokenQuota gauges next to the controller metrics
+	metrics.Registry.MustRegister(controller.NewQuotaCollector(mgr.GetAPIReader()))
+
 	// 5. Add Health and Liveness probes
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
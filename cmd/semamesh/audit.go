@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/semamesh/SemaMesh/pkg/audit"
+)
+
+// runAudit implements the "semamesh audit <command>" tooling
+func runAudit(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: semamesh audit <query|decrypt> [flags]")
+		return 2
+	}
+
+	switch args[0] {
+	case "query":
+		return runAuditQuery(args[1:])
+	case "decrypt":
+		return runAuditDecrypt(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown audit command %q\n", args[0])
+		return 2
+	}
+}
+
+// runAuditQuery pages through the audit search API of a running proxy and
+// prints the matching entries as NDJSON, newest first.
+func runAuditQuery(args []string) int {
+	fs := flag.NewFlagSet("audit query", flag.ExitOnError)
+	server := fs.String("server", "http://127.0.0.1:9091", "address of the SemaMesh audit query API")
+	tokenFile := fs.String("token-file", "", "file holding the audit query API token (default: $"+audit.QueryTokenEnv+")")
+	namespace := fs.String("namespace", "", "only entries from this namespace")
+	pod := fs.String("pod", "", "only entries from this pod")
+	model := fs.String("model", "", "only entries for models matching this glob (e.g. 'gpt-4*')")
+	decision := fs.String("decision", "", "only entries with this decision (ALLOW, DENY, PAUSE...)")
+	since := fs.String("since", "", "only entries newer than this (e.g. 24h, or RFC3339)")
+	until := fs.String("until", "", "only entries older than this (e.g. 1h, or RFC3339)")
+	limit := fs.Int("limit", 100, "entries per page")
+	cursor := fs.Int("cursor", 0, "continue from a previous page")
+	all := fs.Bool("all", false, "follow cursors and print every page")
+	fs.Parse(args)
+
+	params := url.Values{}
+	for k, v := range map[string]string{
+		"namespace": *namespace,
+		"pod":       *pod,
+		"model":     *model,
+		"decision":  *decision,
+		"since":     *since,
+		"until":     *until,
+	} {
+		if v != "" {
+			params.Set(k, v)
+		}
+	}
+	params.Set("limit", strconv.Itoa(*limit))
+
+	token, err := audit.LoadQueryToken(*tokenFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	encoder := json.NewEncoder(os.Stdout)
+	next := *cursor
+
+	for {
+		if next > 0 {
+			params.Set("cursor", strconv.Itoa(next))
+		}
+
+		req, err := http.NewRequest(http.MethodGet, strings.TrimRight(*server, "/")+"/audit/query?"+params.Encode(), nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Invalid --server: %v\n", err)
+			return 1
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Audit query failed: %v\n", err)
+			return 1
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			fmt.Fprintf(os.Stderr, "❌ Audit query failed: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+			return 1
+		}
+
+		var page audit.QueryResult
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Invalid audit query response: %v\n", err)
+			return 1
+		}
+
+		for _, entry := range page.Entries {
+			encoder.Encode(entry)
+		}
+
+		next = page.NextCursor
+		if next == 0 {
+			return 0
+		}
+		if !*all {
+			fmt.Fprintf(os.Stderr, "… more results available: --cursor %d\n", next)
+			return 0
+		}
+	}
+}
+
+// runAuditDecrypt reads sealed NDJSON audit entries and prints them with the
+// prompt/completion text restored. It only works for holders of the audit keys.
+func runAuditDecrypt(args []string) int {
+	fs := flag.NewFlagSet("audit decrypt", flag.ExitOnError)
+	keyDir := fs.String("key-dir", "/etc/semamesh/audit-keys", "directory holding the audit key encryption keys")
+	in := fs.String("in", "-", "audit log to decrypt ('-' for stdin)")
+	fs.Parse(args)
+
+	kr, err := audit.LoadKeyring(*keyDir, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	var src io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to open %s: %v\n", *in, err)
+			return 1
+		}
+		defer f.Close()
+		src = f
+	}
+
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	encoder := json.NewEncoder(os.Stdout)
+	failures := 0
+
+	for scanner.Scan() {
+		var entry audit.LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ Skipping malformed line: %v\n", err)
+			failures++
+			continue
+		}
+
+		opened, err := kr.OpenEntry(entry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ Could not decrypt entry from %s: %v\n", entry.Timestamp, err)
+			failures++
+			continue
+		}
+		encoder.Encode(opened)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to read audit log: %v\n", err)
+		return 1
+	}
+
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
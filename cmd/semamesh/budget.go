@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/semamesh/SemaMesh/pkg/budget"
+	"github.com/semamesh/SemaMesh/pkg/notify"
+	"github.com/semamesh/SemaMesh/pkg/proxy"
+)
+
+// budgetFlags configure SemaCostBudget accounting
+type budgetFlags struct {
+	enabled  *bool
+	interval *time.Duration
+}
+
+func registerBudgetFlags() *budgetFlags {
+	return &budgetFlags{
+		enabled:  flag.Bool("budgets", true, "charge estimated costs to SemaCostBudgets and enforce their hard stops (ignored in dev mode)"),
+		interval: flag.Duration("budget-sync-interval", budget.DefaultSyncInterval, "how often this agent publishes its spend and refreshes budget totals"),
+	}
+}
+
+// setup starts the budget tracker, until stop closes, and hands it to the handler
+func (f *budgetFlags) setup(handler *proxy.SemaHandler, kube *kubeClients, stop <-chan struct{}) (*budget.Tracker, error) {
+	if !*f.enabled {
+		return nil, nil
+	}
+
+	// Spend is published per agent: the node in DaemonSet mode, the pod otherwise
+	agentName := os.Getenv("NODE_NAME")
+	if agentName == "" {
+		agentName, _ = os.Hostname()
+	}
+	client, err := kube.dynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	tracker, err := budget.NewTracker(client, agentName)
+	if err != nil {
+		return nil, err
+	}
+	tracker.Interval = *f.interval
+	tracker.Notify = notifyBudget
+
+	go func() {
+		if err := tracker.Run(stop); err != nil {
+			log.Printf("Budget tracking stopped: %v", err)
+		}
+	}()
+	handler.UseBudgets(tracker)
+	log.Printf("💰 Cost budgets enabled (agent %s)", agentName)
+	return tracker, nil
+}
+
+// notifyBudget turns budget alerts into notifications
+func notifyBudget(a budget.Alert) {
+	n := notify.Notification{
+		Severity:  notify.SeverityWarning,
+		Source:    "budget",
+		Rule:      a.Budget,
+		Namespace: a.Namespace,
+		Fields: map[string]string{
+			"Budget":   a.Namespace + "/" + a.Budget,
+			"Spent":    fmt.Sprintf("%.2f %s", a.Spent, a.Currency),
+			"Limit":    fmt.Sprintf("%.2f %s", a.Limit, a.Currency),
+			"Forecast": fmt.Sprintf("%.2f %s", a.Forecast, a.Currency),
+			"Period":   "until " + a.PeriodEnd.Format(time.RFC822),
+		},
+		// One alert per threshold and period
+		DedupKey: fmt.Sprintf("budget/%s/%s/%d/%s", a.Namespace, a.Budget, a.Threshold, a.PeriodEnd.Format(time.RFC3339)),
+	}
+	if a.Team != "" {
+		n.Fields["Team"] = a.Team
+	}
+
+	switch {
+	case a.Threshold == 0:
+		n.Title = fmt.Sprintf("Budget %s/%s forecast to overspend", a.Namespace, a.Budget)
+		n.Text = fmt.Sprintf("At the current burn rate, spend reaches %.2f of %.2f %s by the end of the period.", a.Forecast, a.Limit, a.Currency)
+	case a.Threshold >= 100:
+		n.Severity = notify.SeverityCritical
+		n.Title = fmt.Sprintf("Budget %s/%s exhausted", a.Namespace, a.Budget)
+		n.Text = fmt.Sprintf("Spend reached %d%% of the budget.", a.Threshold)
+		if a.HardStop {
+			n.Text += " Requests are rejected until the next period."
+		}
+	default:
+		if a.Threshold < 80 {
+			n.Severity = notify.SeverityInfo
+		}
+		n.Title = fmt.Sprintf("Budget %s/%s at %d%%", a.Namespace, a.Budget, a.Threshold)
+		n.Text = fmt.Sprintf("Spend reached %d%% of the budget.", a.Threshold)
+	}
+	notify.Send(n)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/semamesh/SemaMesh/pkg/cache"
+	"github.com/semamesh/SemaMesh/pkg/policy"
+	"github.com/semamesh/SemaMesh/pkg/proxy"
+)
+
+// cacheFlags configure the response cache
+type cacheFlags struct {
+	config     *string
+	policyFile *string
+}
+
+func registerCacheFlags() *cacheFlags {
+	return &cacheFlags{
+		config:     flag.String("cache-config", "", "response cache settings (YAML, see pkg/cache); empty disables the cache"),
+		policyFile: flag.String("policy-file", "", "SemaPolicy manifests deciding semantic cache reuse in dev mode (the cluster's SemaPolicies are watched otherwise)"),
+	}
+}
+
+// setup builds the cache and hands it to the handler. The semantic tier
+// follows the SemaPolicies: watched, or loaded from --policy-file in dev mode.
+func (f *cacheFlags) setup(handler *proxy.SemaHandler, kubeconfig string, devMode bool) error {
+	if *f.config == "" {
+		return nil
+	}
+	c, err := cache.Setup(*f.config)
+	if err != nil {
+		return err
+	}
+
+	if c.Semantic() {
+		var rules *policy.Store
+		switch {
+		case !devMode:
+			if rules, err = policy.NewWatcher(kubeconfig); err != nil {
+				return err
+			}
+			if err := rules.Run(make(chan struct{})); err != nil {
+				return err
+			}
+		case *f.policyFile != "":
+			if rules, err = policy.Load(*f.policyFile); err != nil {
+				return err
+			}
+		default:
+			log.Println("⚠️ Semantic cache configured without --policy-file: no prompt will reuse a similar one")
+		}
+		if rules != nil {
+			c.Rules = rules
+		}
+	}
+
+	go c.Run(make(chan struct{}))
+	handler.UseCache(c)
+	log.Printf("🗃️ Response cache enabled (semantic tier: %v)", c.Semantic())
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/semamesh/SemaMesh/pkg/identity"
+)
+
+// strongIdentityFlags configure mTLS and token based workload identity
+type strongIdentityFlags struct {
+	tlsCert  *string
+	tlsKey   *string
+	clientCA *string
+
+	spiffeTrustDomain *string
+
+	tokenReview   *bool
+	tokenAudience *string
+
+	jwksURL       *string
+	jwksIssuer    *string
+	jwksCA        *string
+	jwksTokenFile *string
+
+	requireVerified *bool
+}
+
+func registerStrongIdentityFlags() *strongIdentityFlags {
+	return &strongIdentityFlags{
+		tlsCert:  flag.String("tls-cert", "", "serve the proxy over TLS with this certificate"),
+		tlsKey:   flag.String("tls-key", "", "private key for --tls-cert"),
+		clientCA: flag.String("client-ca", "", "CA bundle (e.g. SPIFFE trust bundle) used to verify client certificates"),
+
+		spiffeTrustDomain: flag.String("spiffe-trust-domain", "", "accept X.509 SVIDs from this trust domain as workload identity"),
+
+		tokenReview:   flag.Bool("token-review", false, "validate "+identity.TokenHeader+" ServiceAccount tokens with the TokenReview API"),
+		tokenAudience: flag.String("token-audience", "semamesh", "audiences (comma-separated) accepted in ServiceAccount tokens"),
+
+		jwksURL:       flag.String("jwks-url", "", "validate "+identity.TokenHeader+" tokens offline against this JWKS"),
+		jwksIssuer:    flag.String("jwks-issuer", "", "issuer expected in JWKS-validated tokens"),
+		jwksCA:        flag.String("jwks-ca", "", "CA bundle for fetching the JWKS"),
+		jwksTokenFile: flag.String("jwks-token-file", "", "bearer token file used to fetch the JWKS"),
+
+		requireVerified: flag.Bool("require-verified-identity", false, "reject requests without a verified (mTLS or token) identity"),
+	}
+}
+
+// setup builds the verifiers and, if TLS is enabled, the server TLS config
+func (f *strongIdentityFlags) setup(kubeconfig string) ([]identity.Verifier, *tls.Config, error) {
+	var verifiers []identity.Verifier
+
+	// 1. mTLS: the server verifies the cert chain, the verifier maps the SVID
+	var tlsConfig *tls.Config
+	if *f.tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*f.tlsCert, *f.tlsKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+		if *f.clientCA != "" {
+			pem, err := os.ReadFile(*f.clientCA)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read client CA: %v", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, nil, fmt.Errorf("no certificates found in %s", *f.clientCA)
+			}
+			tlsConfig.ClientCAs = pool
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	if *f.spiffeTrustDomain != "" {
+		if tlsConfig == nil || tlsConfig.ClientCAs == nil {
+			return nil, nil, fmt.Errorf("--spiffe-trust-domain requires --tls-cert, --tls-key and --client-ca")
+		}
+		verifiers = append(verifiers, &identity.SPIFFEVerifier{TrustDomain: *f.spiffeTrustDomain})
+	}
+
+	// 2. Projected ServiceAccount tokens, for any of the audiences
+	var audiences []string
+	for _, a := range strings.Split(*f.tokenAudience, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			audiences = append(audiences, a)
+		}
+	}
+	if *f.jwksURL != "" {
+		v, err := identity.NewJWKSVerifier(*f.jwksURL, *f.jwksIssuer, audiences, *f.jwksCA, *f.jwksTokenFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		verifiers = append(verifiers, v)
+	} else if *f.tokenReview {
+		v, err := identity.NewTokenReviewVerifier(kubeconfig, audiences)
+		if err != nil {
+			return nil, nil, err
+		}
+		verifiers = append(verifiers, v)
+	}
+
+	if *f.requireVerified && len(verifiers) == 0 {
+		return nil, nil, fmt.Errorf("--require-verified-identity needs --spiffe-trust-domain, --token-review or --jwks-url")
+	}
+
+	return verifiers, tlsConfig, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubeClients are the API clients shared by the agent's watchers, built on
+// first use so that dev mode never needs a cluster
+type kubeClients struct {
+	kubeconfig string
+
+	once      sync.Once
+	err       error
+	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
+}
+
+func newKubeClients(kubeconfig string) *kubeClients {
+	return &kubeClients{kubeconfig: kubeconfig}
+}
+
+func (k *kubeClients) build() error {
+	k.once.Do(func() {
+		var config *rest.Config
+		if config, k.err = clientcmd.BuildConfigFromFlags("", k.kubeconfig); k.err != nil {
+			k.err = fmt.Errorf("failed to build kubeconfig: %v", k.err)
+			return
+		}
+		if k.clientset, k.err = kubernetes.NewForConfig(config); k.err != nil {
+			k.err = fmt.Errorf("failed to create k8s client: %v", k.err)
+			return
+		}
+		if k.dynamic, k.err = dynamic.NewForConfig(config); k.err != nil {
+			k.err = fmt.Errorf("failed to create k8s client: %v", k.err)
+		}
+	})
+	return k.err
+}
+
+// typed returns the clientset for core resources
+func (k *kubeClients) typed() (kubernetes.Interface, error) {
+	if err := k.build(); err != nil {
+		return nil, err
+	}
+	return k.clientset, nil
+}
+
+// dynamicClient returns the client for SemaMesh's custom resources
+func (k *kubeClients) dynamicClient() (dynamic.Interface, error) {
+	if err := k.build(); err != nil {
+		return nil, err
+	}
+	return k.dynamic, nil
+}
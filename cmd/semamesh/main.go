@@ -1,35 +1,159 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
+	"net"
 	"net/http"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
 	"github.com/semamesh/SemaMesh/pkg/audit"
+	"github.com/semamesh/SemaMesh/pkg/budget"
 	"github.com/semamesh/SemaMesh/pkg/identity"
+	"github.com/semamesh/SemaMesh/pkg/metrics"
+	"github.com/semamesh/SemaMesh/pkg/notify"
 	"github.com/semamesh/SemaMesh/pkg/proxy"
+	"github.com/semamesh/SemaMesh/pkg/tracing"
 )
 
 func main() {
+	// 0. Subcommands (e.g. "semamesh audit decrypt")
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAudit(os.Args[2:]))
+	}
+
 	// 1. Config Flags
 	// Default to empty string (""). This tells the K8s client to use "In-Cluster Config" (Service Account).
 	kubeconfig := flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+	listenAddr := flag.String("addr", ":8080", "listen address for the AI proxy")
 
 	devMode := flag.Bool("dev", true, "Run in local dev mode (mock identity)")
+	devIdentities := flag.String("dev-identities", "", "YAML file of IP/CIDR -> pod identity overrides (dev mode only)")
+	watchNamespaces := flag.String("watch-namespaces", "", "comma-separated namespaces to watch for identity (default: all)")
+	watchSelector := flag.String("watch-selector", "", "only watch pods matching this label selector")
+	nodeLocal := flag.Bool("node-local", false, "only watch pods on this node (uses NODE_NAME, for DaemonSet deployments)")
+	identityGrace := flag.Duration("identity-grace", identity.DefaultGracePeriod, "how long a deleted pod's IP keeps resolving to it (for in-flight requests)")
+	promoteLabels := flag.String("promote-labels", "team=semamesh.io/team,cost_center=semamesh.io/cost-center", "pod labels/annotations promoted to metrics and audit fields (name=key,...)")
+	strongIdentity := registerStrongIdentityFlags()
+	transparent := registerTransparentFlags()
+	budgets := registerBudgetFlags()
+	responseCache := registerCacheFlags()
+	routes := registerRoutingFlags()
+	translation := registerTranslateFlags()
+	trustedProxies := flag.String("trusted-proxies", "", "comma-separated CIDRs of proxies whose X-Forwarded-For/Forwarded/PROXY headers are trusted")
+	proxyProtocol := flag.Bool("proxy-protocol", false, "accept PROXY protocol v1/v2 headers from --trusted-proxies")
+	auditKeyDir := flag.String("audit-key-dir", "", "directory of audit key encryption keys (mounted Secret); enables prompt/completion encryption when it holds keys")
+	auditKeyID := flag.String("audit-key-id", "", "audit key ID used for new entries (default: highest key ID)")
+	auditQueryAddr := flag.String("audit-query-addr", "127.0.0.1:9091", "address for the audit query API (empty to disable)")
+	auditQueryToken := flag.String("audit-query-token-file", "", "file holding the bearer token required by the audit query API (default: $"+audit.QueryTokenEnv+"; the API is off without one)")
+	auditIndexEntries := flag.Int("audit-index-max-entries", audit.DefaultIndexEntries, "latest audit entries kept searchable (older ones stay in the log file)")
+	notifyConfig := flag.String("notify-config", os.Getenv("NOTIFY_CONFIG"), "notification channels and routes (YAML, see pkg/notify); default: post everything to $SLACK_WEBHOOK_URL if set")
+	metricsAddr := flag.String("metrics-addr", ":9090", "listen address for /metrics")
+	metricsDropLabels := flag.String("metrics-drop-labels", "", "comma-separated labels left out of metrics: \"label\" everywhere or \"metric:label\"")
+	metricsMaxValues := flag.Int("metrics-max-label-values", metrics.DefaultMaxLabelValues, "distinct values kept per metric label before reporting \""+metrics.OtherValue+"\" (0 = unlimited)")
+	metricsNormalize := flag.Bool("metrics-normalize-models", true, "fold dated model snapshots into their family (gpt-4o-2024-08-06 -> gpt-4o)")
+	metricsAliases := flag.String("metrics-model-aliases", "", "comma-separated model renames applied after normalization (from=to,...)")
+	otelEndpoint := flag.String("otel-endpoint", "", "OTLP collector host:port for traces (default: $OTEL_EXPORTER_OTLP_ENDPOINT, tracing off if unset)")
+	otelProtocol := flag.String("otel-protocol", "grpc", "OTLP protocol (grpc|http)")
+	otelInsecure := flag.Bool("otel-insecure", false, "connect to the OTLP collector without TLS")
+	otelSampleRatio := flag.Float64("otel-sample-ratio", 1.0, "fraction of new traces sampled (callers' sampling decisions are always honored)")
 	flag.Parse()
 
+	// 1b. Initialize Tracing (before anything starts serving)
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Endpoint:    *otelEndpoint,
+		Protocol:    *otelProtocol,
+		Insecure:    *otelInsecure,
+		SampleRatio: *otelSampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// 1c. Metric labels (before anything records)
+	metricsCfg := metrics.Config{MaxLabelValues: *metricsMaxValues, NormalizeModels: *metricsNormalize}
+	for _, l := range strings.Split(*metricsDropLabels, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			metricsCfg.DropLabels = append(metricsCfg.DropLabels, l)
+		}
+	}
+	aliases, ok := metrics.ParseModelAliases(*metricsAliases)
+	if !ok {
+		log.Fatalf("Invalid --metrics-model-aliases %q (from=to,...)", *metricsAliases)
+	}
+	metricsCfg.ModelAliases = aliases
+	metrics.Configure(metricsCfg)
+
+	// 1d. Notifications (budget alerts, waypoint health)
+	if err := notify.Setup(*notifyConfig); err != nil {
+		log.Fatalf("Failed to set up notifications: %v", err)
+	}
+
 	// 2. Initialize Audit Logging
+	if *auditKeyDir != "" {
+		// The manifests mount an optional Secret: no keys, no encryption
+		switch kr, err := audit.LoadKeyring(*auditKeyDir, *auditKeyID); {
+		case errors.Is(err, audit.ErrNoKeys) && *auditKeyID == "":
+			log.Printf("🔓 Audit encryption disabled: %v", err)
+		case err != nil:
+			log.Fatalf("Failed to load audit keys: %v", err)
+		default:
+			audit.EnableEncryption(kr)
+		}
+	}
+	audit.LimitIndex(*auditIndexEntries)
 	audit.Init()
 
+	// One set of API clients for every watcher. stop closes on shutdown,
+	// which ends the watchers and detaches the interceptor.
+	kube := newKubeClients(*kubeconfig)
+	stop := make(chan struct{})
+
 	// 3. Initialize Identity System
 	idManager := identity.NewManager(*devMode)
+	idManager.SetGracePeriod(*identityGrace)
+
+	promotions, err := identity.ParsePromotedLabels(*promoteLabels)
+	if err != nil {
+		log.Fatalf("Invalid --promote-labels: %v", err)
+	}
+	idManager.SetPromotedLabels(promotions)
+	metrics.InitWorkloadMetrics(identity.PromotedNames(promotions))
+
 	if !*devMode {
 		log.Println("🔌 Attempting to connect to Kubernetes Cluster...")
-		if err := idManager.StartWatcher(*kubeconfig); err != nil {
+		watchOpts := identity.WatchOptions{LabelSelector: *watchSelector}
+		for _, ns := range strings.Split(*watchNamespaces, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				watchOpts.Namespaces = append(watchOpts.Namespaces, ns)
+			}
+		}
+		if *nodeLocal {
+			watchOpts.NodeName = os.Getenv("NODE_NAME")
+			if watchOpts.NodeName == "" {
+				log.Fatalf("--node-local requires the NODE_NAME environment variable")
+			}
+		}
+		if err := watchOpts.Validate(); err != nil {
+			log.Fatalf("Invalid watch options: %v", err)
+		}
+
+		if err := idManager.StartWatcher(*kubeconfig, watchOpts, stop); err != nil {
 			log.Fatalf("Failed to start K8s Watcher: %v", err)
 		}
 	} else {
 		log.Println("⚠️ Running in DEV MODE. Kubernetes connection skipped.")
+		if *devIdentities != "" {
+			if err := idManager.LoadDevIdentities(*devIdentities); err != nil {
+				log.Fatalf("Failed to load dev identities: %v", err)
+			}
+		}
 	}
 
 	// 4. Initialize Handler
@@ -38,32 +162,119 @@ func main() {
 		log.Fatalf("Failed to initialize SemaHandler: %v", err)
 	}
 
-    // 5. Start Metrics
-    go func() {
-       log.Println("📊 Starting Metrics Server on :9090/metrics")
-       http.Handle("/metrics", promhttp.Handler())
-       // REMOVE THE DUPLICATE LINE HERE
-       if err := http.ListenAndServe(":9090", nil); err != nil {
-          log.Fatalf("Metrics Server failed: %v", err)
-       }
-    }()
+	verifiers, tlsConfig, err := strongIdentity.setup(*kubeconfig)
+	if err != nil {
+		log.Fatalf("Failed to set up workload identity: %v", err)
+	}
+	semaHandler.UseVerifiers(*strongIdentity.requireVerified, verifiers...)
+
+	var clientIPs *identity.ClientIPResolver
+	if *trustedProxies != "" {
+		if clientIPs, err = identity.ParseTrustedProxies(*trustedProxies); err != nil {
+			log.Fatalf("Invalid --trusted-proxies: %v", err)
+		}
+		semaHandler.UseTrustedProxies(clientIPs)
+	} else if *proxyProtocol {
+		log.Fatalf("--proxy-protocol requires --trusted-proxies")
+	}
+
+	interceptor, err := transparent.setup(semaHandler, idManager, kube, stop)
+	if err != nil {
+		log.Fatalf("Failed to start transparent redirection: %v", err)
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		close(stop)
+		// Detach cleanly, or every pod keeps being redirected to a dead port
+		if interceptor != nil {
+			if err := interceptor.Close(); err != nil {
+				log.Printf("Failed to detach interceptor: %v", err)
+			}
+		}
+		os.Exit(0)
+	}()
+
+	var tracker *budget.Tracker
+	if !*devMode {
+		if tracker, err = budgets.setup(semaHandler, kube, stop); err != nil {
+			log.Fatalf("Failed to start cost budgets: %v", err)
+		}
+	}
+
+	if err := routes.setup(semaHandler, tracker); err != nil {
+		log.Fatalf("Failed to set up model routing: %v", err)
+	}
+
+	if err := translation.setup(semaHandler); err != nil {
+		log.Fatalf("Failed to set up provider translation: %v", err)
+	}
+
+	if err := responseCache.setup(semaHandler, *kubeconfig, *devMode); err != nil {
+		log.Fatalf("Failed to set up the response cache: %v", err)
+	}
+
+	// 5. Start Metrics
+	go func() {
+		log.Printf("📊 Starting Metrics Server on %s/metrics", *metricsAddr)
+		http.Handle("/metrics", metrics.Handler())
+		if err := http.ListenAndServe(*metricsAddr, nil); err != nil {
+			log.Fatalf("Metrics Server failed: %v", err)
+		}
+	}()
+
+	// 5b. Start Audit Query API (kept off the metrics port: it serves prompts)
+	queryToken, err := audit.LoadQueryToken(*auditQueryToken)
+	if err != nil {
+		log.Fatalf("Failed to set up the audit query API: %v", err)
+	}
+	if *auditQueryAddr != "" && queryToken == "" {
+		log.Printf("⚠️ Audit Query API disabled: no token (--audit-query-token-file or $%s)", audit.QueryTokenEnv)
+	} else if *auditQueryAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/audit/query", audit.QueryHandler(queryToken))
+			log.Printf("🔎 Starting Audit Query API on %s/audit/query", *auditQueryAddr)
+			if err := http.ListenAndServe(*auditQueryAddr, mux); err != nil {
+				log.Fatalf("Audit Query API failed: %v", err)
+			}
+		}()
+	}
 
 	// 6. Start Proxy
-	log.Println("☁️ SemaMesh AI Proxy active on :8080")
+	log.Printf("☁️ SemaMesh AI Proxy active on %s", *listenAddr)
 	if *devMode {
 		log.Println("🛡️ Identity Awareness: LOCAL (Mock)")
 	} else {
 		log.Println("🛡️ Identity Awareness: KUBERNETES (Real)")
 	}
 
+	if len(verifiers) > 0 {
+		log.Printf("🔏 Verified workload identity enabled (%d verifiers, required: %v)", len(verifiers), *strongIdentity.requireVerified)
+	}
+
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: semaHandler,
+		Addr:      *listenAddr,
+		Handler:   tracing.Middleware(semaHandler),
+		TLSConfig: tlsConfig,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
 		log.Fatalf("Proxy Server failed: %v", err)
 	}
-}
+	if *proxyProtocol {
+		listener = &proxy.ProxyProtoListener{Listener: listener, Trusted: clientIPs}
+	}
 
-# End of file
\ No newline at end of file
+	if tlsConfig != nil {
+		// Certificates are already loaded into TLSConfig
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil {
+		log.Fatalf("Proxy Server failed: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/semamesh/SemaMesh/pkg/budget"
+	"github.com/semamesh/SemaMesh/pkg/proxy"
+	"github.com/semamesh/SemaMesh/pkg/routing"
+)
+
+// routingFlags configure model routing
+type routingFlags struct {
+	config *string
+}
+
+func registerRoutingFlags() *routingFlags {
+	return &routingFlags{
+		config: flag.String("routing-config", "", "model routing rules (YAML, see pkg/routing); empty forwards the requested model unchanged"),
+	}
+}
+
+// setup builds the router and hands it to the handler. Budget rules need
+// the tracker (not in dev mode).
+func (f *routingFlags) setup(handler *proxy.SemaHandler, tracker *budget.Tracker) error {
+	if *f.config == "" {
+		return nil
+	}
+	router, err := routing.Setup(*f.config)
+	if err != nil {
+		return err
+	}
+	if tracker != nil {
+		router.Budgets = tracker
+	}
+	handler.UseRouter(router)
+	log.Printf("🔀 Model routing enabled")
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/semamesh/SemaMesh/pkg/proxy"
+	"github.com/semamesh/SemaMesh/pkg/translate"
+)
+
+// translateFlags configure provider translation
+type translateFlags struct {
+	config *string
+}
+
+func registerTranslateFlags() *translateFlags {
+	return &translateFlags{
+		config: flag.String("translation-config", "", "model aliases served by the Anthropic or Gemini API (YAML, see pkg/translate); empty forwards every request as is"),
+	}
+}
+
+// setup builds the translator and hands it to the handler
+func (f *translateFlags) setup(handler *proxy.SemaHandler) error {
+	if *f.config == "" {
+		return nil
+	}
+	t, err := translate.Setup(*f.config)
+	if err != nil {
+		return err
+	}
+	handler.UseTranslator(t)
+	log.Printf("🌐 Provider translation enabled")
+	return nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/semamesh/SemaMesh/internal/agent"
+	"github.com/semamesh/SemaMesh/pkg/identity"
+	"github.com/semamesh/SemaMesh/pkg/mitm"
+	"github.com/semamesh/SemaMesh/pkg/proxy"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// transparentFlags configure eBPF transparent redirection (node agent mode)
+type transparentFlags struct {
+	enabled    *bool
+	addr       *string
+	redirectIP *string
+	hosts      *string
+	cidrs      *string
+	dnsServer  *string
+	ports      *string
+	objectPath *string
+	cgroupPath *string
+
+	interceptDefault *string
+	interceptExempt  *string
+
+	failureMode   *string
+	failOpenAfter *time.Duration
+	maxConns      *int
+
+	mitm             *bool
+	mitmCASecret     *string
+	mitmLeafLifetime *time.Duration
+	mitmSyncBundle   *bool
+}
+
+func registerTransparentFlags() *transparentFlags {
+	return &transparentFlags{
+		enabled:    flag.Bool("transparent", false, "load the eBPF interceptor and transparently redirect pod connections to LLM providers"),
+		addr:       flag.String("transparent-addr", ":15001", "listen address for redirected connections"),
+		redirectIP: flag.String("redirect-ip", "", "IP redirected connections are sent to (default: $POD_IP)"),
+		hosts:      flag.String("redirect-hosts", "api.openai.com,api.anthropic.com", "comma-separated provider hostnames whose IPs are redirected (re-resolved per DNS TTL)"),
+		cidrs:      flag.String("redirect-cidrs", "", "comma-separated static provider IPs/CIDRs to redirect"),
+		dnsServer:  flag.String("redirect-dns-server", "", "DNS server used to resolve --redirect-hosts (default: /etc/resolv.conf)"),
+		ports:      flag.String("redirect-ports", "443", "comma-separated destination ports to redirect"),
+		objectPath: flag.String("bpf-object", agent.DefaultObjectPath, "compiled eBPF object"),
+		cgroupPath: flag.String("cgroup-path", agent.DefaultCgroupPath, "cgroup v2 root the programs are attached to"),
+
+		interceptDefault: flag.String("intercept-default", agent.InterceptEnabled, "policy for pods and namespaces without the "+agent.InterceptLabel+" label (enabled|disabled)"),
+		interceptExempt:  flag.String("intercept-exempt-namespaces", strings.Join(agent.DefaultExemptNamespaces, ","), "namespaces only intercepted when they or their pods opt in explicitly"),
+
+		failureMode:   flag.String("failure-mode", agent.FailOpen, "failure mode for pods and namespaces without the "+agent.FailureModeLabel+" label (open|closed)"),
+		failOpenAfter: flag.Duration("fail-open-after", 5*time.Second, "how long the transparent listener may fail health checks before fail-open pods bypass it (0 disables fail-open)"),
+		maxConns:      flag.Int("transparent-max-conns", 0, "maximum concurrent redirected connections; beyond it fail-open pods bypass inspection and fail-closed pods are refused (0 = unlimited)"),
+
+		mitm:             flag.Bool("mitm", false, "terminate redirected TLS to --redirect-hosts for namespaces labelled "+mitm.InterceptLabel+"="+mitm.InterceptEnabled),
+		mitmCASecret:     flag.String("mitm-ca-secret", "semamesh-system/semamesh-ca", "namespace/name of the kubernetes.io/tls Secret holding the interception CA"),
+		mitmLeafLifetime: flag.Duration("mitm-leaf-lifetime", mitm.DefaultLeafLifetime, "lifetime of minted leaf certificates"),
+		mitmSyncBundle:   flag.Bool("mitm-sync-bundle", false, "write the CA bundle ConfigMap ("+mitm.BundleConfigMap+") to opted-in namespaces (enable on one replica)"),
+	}
+}
+
+// setup loads the interceptor, starts the transparent listener and makes the
+// handler's own upstream calls bypass redirection. Its watchers run until stop
+// closes; the caller closes the returned agent to detach. It returns nil if
+// transparent mode is off.
+func (f *transparentFlags) setup(handler *proxy.SemaHandler, idManager *identity.Manager, kube *kubeClients, stop <-chan struct{}) (*agent.Agent, error) {
+	if *f.mitmSyncBundle {
+		if err := f.syncBundle(kube, stop); err != nil {
+			return nil, err
+		}
+	}
+	if !*f.enabled {
+		if *f.mitm {
+			return nil, fmt.Errorf("--mitm requires --transparent")
+		}
+		return nil, nil
+	}
+
+	cfg := agent.Config{
+		ObjectPath: *f.objectPath,
+		CgroupPath: *f.cgroupPath,
+		StaleAfter: *f.failOpenAfter,
+	}
+
+	ipSpec := *f.redirectIP
+	if ipSpec == "" {
+		ipSpec = os.Getenv("POD_IP")
+	}
+	if cfg.RedirectIP = net.ParseIP(ipSpec); cfg.RedirectIP == nil {
+		return nil, fmt.Errorf("--transparent requires --redirect-ip or the POD_IP environment variable")
+	}
+
+	listenHost, portSpec, err := net.SplitHostPort(*f.addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --transparent-addr: %v", err)
+	}
+	if cfg.RedirectPort, err = strconv.Atoi(portSpec); err != nil {
+		return nil, fmt.Errorf("invalid --transparent-addr port %q", portSpec)
+	}
+
+	for _, item := range strings.Split(*f.ports, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		port, err := strconv.Atoi(item)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid --redirect-ports entry %q", item)
+		}
+		cfg.Ports = append(cfg.Ports, port)
+	}
+
+	policy := agent.PolicyConfig{
+		NodeName:   os.Getenv("NODE_NAME"),
+		CgroupRoot: *f.cgroupPath,
+	}
+	switch *f.interceptDefault {
+	case agent.InterceptEnabled:
+		policy.DefaultEnabled = true
+	case agent.InterceptDisabled:
+	default:
+		return nil, fmt.Errorf("invalid --intercept-default %q (enabled|disabled)", *f.interceptDefault)
+	}
+	switch *f.failureMode {
+	case agent.FailOpen:
+	case agent.FailClosed:
+		policy.DefaultFailClosed = true
+	default:
+		return nil, fmt.Errorf("invalid --failure-mode %q (open|closed)", *f.failureMode)
+	}
+	for _, ns := range strings.Split(*f.interceptExempt, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			policy.ExemptNamespaces = append(policy.ExemptNamespaces, ns)
+		}
+	}
+	if policy.NodeName == "" {
+		return nil, fmt.Errorf("--transparent requires the NODE_NAME environment variable")
+	}
+
+	static, err := parseCIDRs(*f.cidrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --redirect-cidrs: %v", err)
+	}
+	var hosts []string
+	for _, host := range strings.Split(*f.hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	// Listen first: once attached, redirected connections need somewhere to go
+	listener, err := net.Listen("tcp", *f.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := agent.Load(cfg)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	targets, err := agent.NewTargetSet(a, hosts, static, *f.dnsServer)
+	if err != nil {
+		a.Close()
+		listener.Close()
+		return nil, fmt.Errorf("failed to set up redirect targets: %v", err)
+	}
+	go targets.Run(context.Background())
+	// Served next to /metrics
+	http.Handle("/debug/targets", targets.DebugHandler())
+
+	// Only pods that opted in (or didn't opt out) are intercepted
+	clientset, err := kube.typed()
+	if err != nil {
+		a.Close()
+		listener.Close()
+		return nil, err
+	}
+	reconciler, err := agent.NewPolicyReconciler(a, clientset, policy)
+	if err != nil {
+		a.Close()
+		listener.Close()
+		return nil, fmt.Errorf("failed to set up interception policy: %v", err)
+	}
+	go func() {
+		if err := reconciler.Run(stop); err != nil {
+			log.Printf("Interception policy stopped: %v", err)
+		}
+	}()
+
+	// Our own upstream connections must never be redirected back to us
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: a.DialControl}
+	handler.UseDialer(dialer)
+
+	tp := &proxy.TransparentProxy{
+		OriginalDst: a.OriginalDst,
+		Dialer:      dialer,
+		Handler:     handler,
+		MaxConns:    *f.maxConns,
+		Bypass:      reconciler.Bypass,
+	}
+	if *f.mitm {
+		if err := f.setupInterception(tp, idManager, clientset, stop, hosts); err != nil {
+			a.Close()
+			listener.Close()
+			return nil, err
+		}
+	}
+	go func() {
+		log.Printf("🪝 Transparent listener active on %s (%d hosts, %d static CIDRs)", *f.addr, len(hosts), len(static))
+		if err := tp.Serve(listener); err != nil {
+			log.Printf("Transparent listener stopped: %v", err)
+		}
+	}()
+
+	// Keep the kernel heartbeat fresh while the listener answers
+	probeHost := listenHost
+	if ip := net.ParseIP(probeHost); probeHost == "" || (ip != nil && ip.IsUnspecified()) {
+		probeHost = "127.0.0.1"
+	}
+	go agent.NewHealthChecker(a, net.JoinHostPort(probeHost, portSpec), proxy.TransparentHealthPath).Run(stop)
+
+	return a, nil
+}
+
+// setupInterception terminates TLS to the provider hosts for pods in opted-in
+// namespaces; everything else stays passthrough
+func (f *transparentFlags) setupInterception(tp *proxy.TransparentProxy, idManager *identity.Manager, clientset kubernetes.Interface, stop <-chan struct{}, hosts []string) error {
+	ca, err := f.loadCA(clientset)
+	if err != nil {
+		return err
+	}
+	optIn, err := mitm.WatchNamespaces(clientset, stop)
+	if err != nil {
+		return err
+	}
+
+	intercepted := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		intercepted[strings.ToLower(host)] = true
+	}
+
+	tp.TLSConfig = ca.TLSConfig()
+	tp.Intercept = func(remote net.Addr, sni string) bool {
+		if !intercepted[strings.ToLower(sni)] {
+			return false
+		}
+		hostIP, _, err := net.SplitHostPort(remote.String())
+		if err != nil {
+			return false
+		}
+		meta, ok := idManager.GetIdentity(hostIP)
+		return ok && optIn.Enabled(meta.Namespace)
+	}
+	log.Printf("🔐 TLS interception enabled for %v (namespaces labelled %s=%s)", hosts, mitm.InterceptLabel, mitm.InterceptEnabled)
+	return nil
+}
+
+// syncBundle distributes the CA bundle to opted-in namespaces
+func (f *transparentFlags) syncBundle(kube *kubeClients, stop <-chan struct{}) error {
+	clientset, err := kube.typed()
+	if err != nil {
+		return err
+	}
+	ca, err := f.loadCA(clientset)
+	if err != nil {
+		return err
+	}
+	optIn, err := mitm.WatchNamespaces(clientset, stop)
+	if err != nil {
+		return err
+	}
+	optIn.SyncBundle(ca.BundlePEM(), stop)
+	return nil
+}
+
+func (f *transparentFlags) loadCA(clientset kubernetes.Interface) (*mitm.CA, error) {
+	namespace, name, ok := strings.Cut(*f.mitmCASecret, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("--mitm-ca-secret must be namespace/name")
+	}
+	ca, err := mitm.LoadCAFromSecret(clientset, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	ca.LeafLifetime = *f.mitmLeafLifetime
+	return ca, nil
+}
+
+// parseCIDRs parses a comma-separated list of CIDRs or single IPs
+func parseCIDRs(spec string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, cidr)
+	}
+	return out, nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net"
 	"net/http"
@@ -10,6 +12,10 @@ import (
 	"time"
 
 	"github.com/semamesh/semamesh/internal/proxy"
+	"github.com/semamesh/semamesh/pkg/audit"
+	"github.com/semamesh/semamesh/pkg/metrics"
+	"github.com/semamesh/semamesh/pkg/notify"
+	"github.com/semamesh/semamesh/pkg/tracing"
 )
 
 func main() {
@@ -25,6 +31,36 @@ func main() {
 		port = "8080"
 	}
 
+	// Exported to $OTEL_EXPORTER_OTLP_ENDPOINT when set
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Protocol:    os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"),
+		SampleRatio: 1.0,
+		ServiceName: "semamesh-waypoint",
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Violation alerts: $NOTIFY_CONFIG, or $SLACK_WEBHOOK_URL
+	if err := notify.Setup(os.Getenv("NOTIFY_CONFIG")); err != nil {
+		log.Fatalf("Failed to set up notifications: %v", err)
+	}
+
+	// Blocked requests are audited like the proxy's, sealed with the keys
+	// in $AUDIT_KEY_DIR when set
+	if dir := os.Getenv("AUDIT_KEY_DIR"); dir != "" {
+		switch kr, err := audit.LoadKeyring(dir, os.Getenv("AUDIT_KEY_ID")); {
+		case errors.Is(err, audit.ErrNoKeys) && os.Getenv("AUDIT_KEY_ID") == "":
+			log.Printf("🔓 Audit encryption disabled: %v", err)
+		case err != nil:
+			log.Fatalf("Failed to load audit keys: %v", err)
+		default:
+			audit.EnableEncryption(kr)
+		}
+	}
+	audit.Init()
+
 	targetURL, err := url.Parse(target)
 	if err != nil {
 		log.Fatalf("Invalid target URL: %v", err)
@@ -51,14 +87,52 @@ func main() {
 	reverseProxy.Director = func(req *http.Request) {
 		originalDirector(req)
 		req.Host = targetURL.Host
+		// Continue the caller's trace through us
+		tracing.Inject(req.Context(), req.Header)
 		// Debug Log: Prove we are sending the request
 		log.Printf("PROXY_DEBUG: Forwarding to %s%s", targetURL.Host, req.URL.Path)
 	}
 
 	// 3. Apply Middleware (Intent Analysis)
-	finalHandler := proxy.IntentMiddleware(reverseProxy)
+	finalHandler := tracing.Middleware(proxy.IntentMiddleware(reverseProxy))
+
+	// 4. Expose policy/quota block counters
+	metricsPort := os.Getenv("METRICS_PORT")
+	if metricsPort == "" {
+		metricsPort = "9090"
+	}
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		if err := http.ListenAndServe(":"+metricsPort, mux); err != nil {
+			log.Printf("Metrics server failed: %v", err)
+		}
+	}()
+
+	// 4b. Audit Query API, on $AUDIT_QUERY_ADDR with the token in
+	// $AUDIT_QUERY_TOKEN_FILE or $SEMAMESH_AUDIT_TOKEN
+	queryAddr := os.Getenv("AUDIT_QUERY_ADDR")
+	if queryAddr == "" {
+		queryAddr = "127.0.0.1:9092"
+	}
+	token, err := audit.LoadQueryToken(os.Getenv("AUDIT_QUERY_TOKEN_FILE"))
+	if err != nil {
+		log.Fatalf("Failed to set up the audit query API: %v", err)
+	}
+	if token == "" {
+		log.Printf("⚠️ Audit Query API disabled: no token ($AUDIT_QUERY_TOKEN_FILE or $%s)", audit.QueryTokenEnv)
+	} else {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/audit/query", audit.QueryHandler(token))
+			log.Printf("🔎 Starting Audit Query API on %s/audit/query", queryAddr)
+			if err := http.ListenAndServe(queryAddr, mux); err != nil {
+				log.Printf("Audit Query API failed: %v", err)
+			}
+		}()
+	}
 
-	// 4. Start Server
+	// 5. Start Server
 	log.Printf("🚀 Waypoint Proxy starting on :%s forwarding to %s", port, target)
 	if err := http.ListenAndServe(":"+port, finalHandler); err != nil {
 		log.Fatalf("Server failed: %v", err)
@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// cgroupIndex finds the cgroups of the pods on this node. It understands
+// both kubelet cgroup drivers, wherever the kubelet put its root (e.g.
+// kubelet.slice/kubelet-kubepods.slice on kind):
+//
+//	systemd:  kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid_with_underscores>.slice/...
+//	cgroupfs: kubepods/burstable/pod<uid>/...
+//
+// The whole tree is walked once, to find the directories holding pod
+// cgroups (one per QoS class). New pods are then looked up there only.
+// It is used by the reconcile loop alone and isn't safe for concurrent use.
+type cgroupIndex struct {
+	root string
+	// directories holding pod cgroups
+	parents map[string]bool
+	// pod UID -> pod cgroup directory
+	pods map[types.UID]string
+}
+
+func newCgroupIndex(root string) *cgroupIndex {
+	return &cgroupIndex{
+		root:    root,
+		parents: make(map[string]bool),
+		pods:    make(map[types.UID]string),
+	}
+}
+
+// ids returns the IDs of the cgroup of pod uid and its container cgroups.
+// Containers are listed on every call: a restarted one gets a new cgroup.
+func (ix *cgroupIndex) ids(uid types.UID) []uint64 {
+	path, ok := ix.pods[uid]
+	if !ok {
+		return nil
+	}
+
+	var out []uint64
+	err := filepath.WalkDir(path, func(sub string, d fs.DirEntry, err error) error {
+		if err != nil && sub == path {
+			return err
+		}
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if id, ok := cgroupID(sub); ok {
+			out = append(out, id)
+		}
+		return nil
+	})
+	if err != nil {
+		// The pod cgroup is gone: look it up again next time
+		delete(ix.pods, uid)
+	}
+	return out
+}
+
+// lookup indexes the cgroups of pods that aren't indexed yet, e.g. new pods.
+// It reads the pod cgroup parents, or walks the whole tree until they are
+// known.
+func (ix *cgroupIndex) lookup(uids []types.UID) {
+	missing := false
+	for _, uid := range uids {
+		if _, ok := ix.pods[uid]; !ok {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return
+	}
+
+	if len(ix.parents) == 0 {
+		ix.walk()
+		return
+	}
+	for parent := range ix.parents {
+		ix.scan(parent)
+	}
+}
+
+// walk indexes every pod cgroup below the root, and their parents
+func (ix *cgroupIndex) walk() {
+	filepath.WalkDir(ix.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		switch d.Name() {
+		case "system.slice", "user.slice", "init.scope":
+			// Node services, never pods
+			return filepath.SkipDir
+		}
+		if uid, ok := podUIDFromCgroup(d.Name()); ok {
+			ix.add(uid, path)
+			// Containers are below, never other pods
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// scan indexes the pod cgroups in parent. A missing parent is forgotten, so
+// the next lookup walks the tree again (e.g. the kubelet moved its root).
+func (ix *cgroupIndex) scan(parent string) {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		delete(ix.parents, parent)
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if uid, ok := podUIDFromCgroup(e.Name()); ok {
+			if _, known := ix.pods[uid]; !known {
+				ix.add(uid, filepath.Join(parent, e.Name()))
+			}
+		}
+	}
+}
+
+// add indexes a pod cgroup. Its parent holds the pods of one QoS class: the
+// other classes are its siblings (systemd) or its parent's children
+// (cgroupfs), so they are remembered too, even while they hold no pod.
+func (ix *cgroupIndex) add(uid types.UID, path string) {
+	ix.pods[uid] = path
+
+	parent := filepath.Dir(path)
+	if ix.parents[parent] {
+		return
+	}
+	ix.parents[parent] = true
+
+	// Guaranteed pods live in the kubepods directory itself, the others in
+	// its burstable and besteffort children
+	kubepods := parent
+	if qosClassDir(filepath.Base(parent)) {
+		kubepods = filepath.Dir(parent)
+	}
+	ix.parents[kubepods] = true
+	entries, err := os.ReadDir(kubepods)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() && qosClassDir(e.Name()) {
+			ix.parents[filepath.Join(kubepods, e.Name())] = true
+		}
+	}
+}
+
+// forget drops the pods that aren't in keep
+func (ix *cgroupIndex) forget(keep map[types.UID]bool) {
+	for uid := range ix.pods {
+		if !keep[uid] {
+			delete(ix.pods, uid)
+		}
+	}
+}
+
+// qosClassDir reports whether name is the cgroup of a QoS class
+// ("burstable", "kubepods-besteffort.slice"...)
+func qosClassDir(name string) bool {
+	name = strings.TrimSuffix(name, ".slice")
+	return strings.HasSuffix(name, "burstable") || strings.HasSuffix(name, "besteffort")
+}
+
+// podUIDFromCgroup extracts the pod UID from a pod cgroup directory name
+func podUIDFromCgroup(name string) (types.UID, bool) {
+	name = strings.TrimSuffix(name, ".slice")
+	i := strings.LastIndex(name, "pod")
+	if i < 0 {
+		return "", false
+	}
+	uid := strings.ReplaceAll(name[i+len("pod"):], "_", "-")
+	// A UID is 36 characters: 8-4-4-4-12
+	if len(uid) != 36 || strings.Count(uid, "-") != 4 {
+		return "", false
+	}
+	return types.UID(uid), true
+}
+
+// cgroupID returns the cgroup v2 ID of a directory, which is its inode number
+func cgroupID(path string) (uint64, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return st.Ino, true
+}
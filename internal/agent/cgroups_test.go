@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	uidA = "0e5a0c1d-6b0e-4c38-9d8e-0a1b2c3d4e5f"
+	uidB = "1f6b1d2e-7c1f-4d49-8e9f-1b2c3d4e5f60"
+	uidC = "2a7c2e3f-8d2a-4e5a-9fa0-2c3d4e5f6071"
+	uidD = "3b8d3f4a-9e3b-4f6b-8ab1-3d4e5f607182"
+)
+
+func mkdirs(t *testing.T, root string, dirs ...string) {
+	t.Helper()
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCgroupIndex(t *testing.T) {
+	systemdPod := func(qos, uid string) string {
+		name := "kubepods-" + qos + "-pod" + strings.ReplaceAll(uid, "-", "_") + ".slice"
+		if qos == "" {
+			name = "kubepods-pod" + strings.ReplaceAll(uid, "-", "_") + ".slice"
+			return "kubelet.slice/kubelet-kubepods.slice/" + name
+		}
+		return "kubelet.slice/kubelet-kubepods.slice/kubelet-kubepods-" + qos + ".slice/" + name
+	}
+	cgroupfsPod := func(qos, uid string) string {
+		if qos == "" {
+			return "kubepods/pod" + uid
+		}
+		return "kubepods/" + qos + "/pod" + uid
+	}
+
+	for name, pod := range map[string]func(qos, uid string) string{"systemd": systemdPod, "cgroupfs": cgroupfsPod} {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			// Only a burstable pod at first: the other classes hold none
+			mkdirs(t, root,
+				"system.slice/containerd.service",
+				pod("burstable", uidA)+"/cri-containerd-1.scope",
+				pod("burstable", uidA)+"/cri-containerd-2.scope",
+				filepath.Dir(pod("besteffort", uidB)),
+			)
+
+			ix := newCgroupIndex(root)
+			ix.lookup([]types.UID{uidA})
+			if got := len(ix.ids(uidA)); got != 3 {
+				t.Errorf("pod A: %d cgroups, want 3 (pod and two containers)", got)
+			}
+
+			// New pods are found in the known QoS directories, guaranteed ones
+			// included, without walking the tree
+			mkdirs(t, root,
+				pod("besteffort", uidB)+"/cri-containerd-3.scope",
+				pod("", uidC)+"/cri-containerd-4.scope",
+				"elsewhere/pod"+uidD,
+			)
+			ix.lookup([]types.UID{uidA, uidB, uidC, uidD})
+			for _, uid := range []types.UID{uidB, uidC} {
+				if got := len(ix.ids(uid)); got != 2 {
+					t.Errorf("pod %s: %d cgroups, want 2", uid, got)
+				}
+			}
+			if _, ok := ix.pods[uidD]; ok {
+				t.Error("the tree was walked again")
+			}
+
+			// A restarted container gets a new cgroup
+			if err := os.Remove(filepath.Join(root, pod("besteffort", uidB), "cri-containerd-3.scope")); err != nil {
+				t.Fatal(err)
+			}
+			mkdirs(t, root, pod("besteffort", uidB)+"/cri-containerd-5.scope")
+			if got := len(ix.ids(uidB)); got != 2 {
+				t.Errorf("pod B after a restart: %d cgroups, want 2", got)
+			}
+
+			// Deleted pods are forgotten
+			if err := os.RemoveAll(filepath.Join(root, pod("burstable", uidA))); err != nil {
+				t.Fatal(err)
+			}
+			if ids := ix.ids(uidA); len(ids) != 0 {
+				t.Errorf("removed pod A: %d cgroups", len(ids))
+			}
+			if _, ok := ix.pods[uidA]; ok {
+				t.Error("removed pod A still indexed")
+			}
+			ix.forget(map[types.UID]bool{uidB: true})
+			if _, ok := ix.pods[uidC]; ok {
+				t.Error("pod C indexed after it left the node")
+			}
+		})
+	}
+}
+
+func TestPodUIDFromCgroup(t *testing.T) {
+	tests := []struct {
+		name string
+		uid  types.UID
+		ok   bool
+	}{
+		{"kubepods-burstable-pod" + strings.ReplaceAll(uidA, "-", "_") + ".slice", uidA, true},
+		{"kubepods-pod" + strings.ReplaceAll(uidA, "-", "_") + ".slice", uidA, true},
+		{"pod" + uidA, uidA, true},
+		{"kubepods-burstable.slice", "", false},
+		{"cri-containerd-" + strings.ReplaceAll(uidA, "-", "") + ".scope", "", false},
+		{"pod1234", "", false},
+	}
+	for _, tt := range tests {
+		if uid, ok := podUIDFromCgroup(tt.name); uid != tt.uid || ok != tt.ok {
+			t.Errorf("podUIDFromCgroup(%q) = %q, %v, want %q, %v", tt.name, uid, ok, tt.uid, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsResolver is a minimal stub resolver. net.Resolver hides record TTLs,
+// and we need them to know how long a provider IP may still be used.
+type dnsResolver struct {
+	servers []string
+	timeout time.Duration
+}
+
+// newDNSResolver uses server ("host[:port]") or, if empty, the nameservers
+// of /etc/resolv.conf
+func newDNSResolver(server string) (*dnsResolver, error) {
+	r := &dnsResolver{timeout: 5 * time.Second}
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.servers = []string{server}
+		return r, nil
+	}
+
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil, fmt.Errorf("no DNS server configured: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			r.servers = append(r.servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	if len(r.servers) == 0 {
+		return nil, fmt.Errorf("no nameserver in /etc/resolv.conf")
+	}
+	return r, nil
+}
+
+// lookup returns the A and AAAA records of host and the smallest TTL seen
+// (CNAMEs included). host is treated as fully qualified: no search domains.
+func (r *dnsResolver) lookup(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ips []net.IP
+	var minTTL uint32
+	var lastErr error
+	answered, haveTTL := false, false
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		msg, err := r.query(ctx, name, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		answered = true
+
+		for _, ans := range msg.Answers {
+			if !haveTTL || ans.Header.TTL < minTTL {
+				minTTL, haveTTL = ans.Header.TTL, true
+			}
+			switch body := ans.Body.(type) {
+			case *dnsmessage.AResource:
+				ips = append(ips, net.IP(body.A[:]).To4())
+			case *dnsmessage.AAAAResource:
+				ips = append(ips, net.IP(body.AAAA[:]))
+			}
+		}
+	}
+
+	if !answered {
+		return nil, 0, lastErr
+	}
+	if len(ips) == 0 {
+		return nil, 0, fmt.Errorf("no A/AAAA records for %s", host)
+	}
+	return ips, time.Duration(minTTL) * time.Second, nil
+}
+
+// query tries each server in turn, falling back to TCP for truncated answers
+func (r *dnsResolver) query(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	id := uint16(rand.Intn(1 << 16))
+	req := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := req.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, server := range r.servers {
+		msg, err := r.exchange(ctx, "udp", server, packed, id)
+		if err == nil && msg.Header.Truncated {
+			msg, err = r.exchange(ctx, "tcp", server, packed, id)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		switch msg.Header.RCode {
+		case dnsmessage.RCodeSuccess:
+			return msg, nil
+		case dnsmessage.RCodeNameError:
+			// Authoritative "no such name": asking another server won't help
+			return nil, fmt.Errorf("%s: no such host", name)
+		default:
+			lastErr = fmt.Errorf("%s: %s from %s", name, msg.Header.RCode, server)
+		}
+	}
+	return nil, lastErr
+}
+
+func (r *dnsResolver) exchange(ctx context.Context, network, server string, packed []byte, id uint16) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var resp []byte
+	if network == "tcp" {
+		// DNS over TCP prefixes every message with its length
+		framed := make([]byte, 2+len(packed))
+		binary.BigEndian.PutUint16(framed, uint16(len(packed)))
+		copy(framed[2:], packed)
+		if _, err := conn.Write(framed); err != nil {
+			return nil, err
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return nil, err
+		}
+		resp = make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(packed); err != nil {
+			return nil, err
+		}
+		resp = make([]byte, 4096)
+		n, err := conn.Read(resp)
+		if err != nil {
+			return nil, err
+		}
+		resp = resp[:n]
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return nil, err
+	}
+	if msg.Header.ID != id {
+		return nil, fmt.Errorf("mismatched DNS response ID from %s", server)
+	}
+	return &msg, nil
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/semamesh/SemaMesh/pkg/metrics"
+	"github.com/semamesh/SemaMesh/pkg/notify"
+)
+
+const (
+	// DefaultHealthInterval between probes of the waypoint
+	DefaultHealthInterval = 2 * time.Second
+	// DefaultHealthThreshold consecutive failed probes mark it unhealthy
+	DefaultHealthThreshold = 3
+)
+
+// HealthChecker probes the waypoint and keeps the kernel heartbeat fresh while
+// it answers. Once it stops answering, fail-open pods bypass it after
+// Config.StaleAfter; fail-closed pods keep being redirected.
+type HealthChecker struct {
+	agent  *Agent
+	url    string
+	client *http.Client
+
+	Interval  time.Duration
+	Threshold int
+}
+
+// NewHealthChecker probes the transparent listener at addr (host:port) with
+// an HTTP request to path, so a listener that accepts connections but no
+// longer serves them counts as down
+func NewHealthChecker(a *Agent, addr, path string) *HealthChecker {
+	return &HealthChecker{
+		agent: a,
+		url:   "http://" + addr + path,
+		// No keep-alive: every probe goes through accept
+		client:    &http.Client{Transport: &http.Transport{DisableKeepAlives: true, Proxy: nil}},
+		Interval:  DefaultHealthInterval,
+		Threshold: DefaultHealthThreshold,
+	}
+}
+
+// Run probes until stopCh closes
+func (h *HealthChecker) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(h.Interval)
+	defer ticker.Stop()
+
+	healthy, failures := true, 0
+	metrics.WaypointHealthy.Set(1)
+	for {
+		if err := h.probe(); err != nil {
+			failures++
+			if healthy && failures >= h.Threshold {
+				healthy = false
+				metrics.WaypointHealthy.Set(0)
+				log.Printf("WAYPOINT: Unhealthy after %d failed probes (%v): fail-open pods will bypass inspection", failures, err)
+				notify.Send(notify.Notification{
+					Severity: notify.SeverityCritical,
+					Source:   "agent",
+					Rule:     "waypoint-unhealthy",
+					Title:    "SemaMesh waypoint unhealthy",
+					Text:     "The node's transparent proxy stopped answering health checks: fail-open pods bypass inspection, fail-closed pods can't reach their providers.",
+					Fields:   map[string]string{"Node": os.Getenv("NODE_NAME"), "Error": err.Error()},
+				})
+			}
+		} else {
+			if !healthy {
+				log.Printf("WAYPOINT: Healthy again, resuming inspection")
+				metrics.WaypointHealthy.Set(1)
+			}
+			healthy, failures = true, 0
+			if err := h.agent.Heartbeat(); err != nil {
+				log.Printf("WAYPOINT: Failed to update heartbeat: %v", err)
+			}
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *HealthChecker) probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.Interval/2)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
@@ -1,19 +1,315 @@
-// Simplified Spoon-feed version using cilium/ebpf library
-func LoadBPF() {
-    // 1. Load the compiled object file
-    spec, err := ebpf.LoadCollectionSpec("bpf/sema_redirect.o")
-
-    // 2. Load it into the Kernel
-    coll, err := ebpf.NewCollection(spec)
-
-    // 3. Attach it to the "Root Cgroup"
-    // This ensures EVERY pod on the node is subject to this rule
-    cgroupPath := "/sys/fs/cgroup" // Standard K8s cgroup root
-    link, err := link.AttachCgroup(link.CgroupOptions{
-        Path:    cgroupPath,
-        Attach:  ebpf.AttachCGroupInet4Connect,
-        Program: coll.Programs["sema_connect4"],
-    })
-
-    fmt.Println("SemaMesh Interceptor is ACTIVE.")
-}
\ No newline at end of file
+// Package agent loads the SemaMesh eBPF programs on a node and transparently
+// redirects pod connections to LLM providers to the local waypoint.
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// DefaultObjectPath is where the Docker image ships the compiled program
+	DefaultObjectPath = "/usr/lib/bpf/sema_redirect.o"
+	// DefaultCgroupPath is the cgroup v2 root: every pod on the node lives below it
+	DefaultCgroupPath = "/sys/fs/cgroup"
+	// DefaultPinPath is where maps shared with other processes are pinned
+	DefaultPinPath = "/sys/fs/bpf/semamesh"
+)
+
+// Config describes where to load the programs and where to redirect to
+type Config struct {
+	ObjectPath string
+	CgroupPath string
+	PinPath    string
+
+	// The waypoint address redirected connections are sent to. RedirectIP6 is
+	// optional; without it IPv6 connections are left alone.
+	RedirectIP   net.IP
+	RedirectIP6  net.IP
+	RedirectPort int
+
+	// Destination ports to capture (e.g. 443)
+	Ports []int
+
+	// StaleAfter is how long the waypoint may miss heartbeats (see Heartbeat)
+	// before fail-open pods bypass it. Zero disables fail-open.
+	StaleAfter time.Duration
+}
+
+// Agent owns the loaded eBPF collection and its cgroup attachments
+type Agent struct {
+	coll  *ebpf.Collection
+	links []link.Link
+}
+
+// Load loads bpf/sema_redirect.o, configures it and attaches the connect4,
+// connect6 and sockops programs to the cgroup root. No connection is
+// redirected until targets are added with AddTarget and pods opted in with
+// AddCgroup (see PolicyReconciler).
+func Load(cfg Config) (*Agent, error) {
+	if cfg.ObjectPath == "" {
+		cfg.ObjectPath = DefaultObjectPath
+	}
+	if cfg.CgroupPath == "" {
+		cfg.CgroupPath = DefaultCgroupPath
+	}
+	if cfg.PinPath == "" {
+		cfg.PinPath = DefaultPinPath
+	}
+
+	redirectIP := cfg.RedirectIP.To4()
+	if redirectIP == nil {
+		return nil, fmt.Errorf("redirect IP %q is not an IPv4 address", cfg.RedirectIP)
+	}
+	if cfg.RedirectPort <= 0 || cfg.RedirectPort > 65535 {
+		return nil, fmt.Errorf("invalid redirect port %d", cfg.RedirectPort)
+	}
+	if len(cfg.Ports) == 0 {
+		return nil, fmt.Errorf("no destination ports to capture")
+	}
+
+	// Kernels before 5.11 account BPF memory against RLIMIT_MEMLOCK
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return nil, fmt.Errorf("failed to remove memlock limit: %v", err)
+	}
+
+	spec, err := ebpf.LoadCollectionSpec(cfg.ObjectPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", cfg.ObjectPath, err)
+	}
+
+	if err := os.MkdirAll(cfg.PinPath, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create pin path: %v", err)
+	}
+	coll, err := ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{
+		Maps: ebpf.MapOptions{PinPath: cfg.PinPath},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load eBPF programs into the kernel: %v", err)
+	}
+
+	a := &Agent{coll: coll}
+
+	// 1. Configure before attaching, so the programs never see a half-set config
+	conf := bpfConfig{RedirectPort: uint32(cfg.RedirectPort), StaleNs: uint64(cfg.StaleAfter)}
+	copy(conf.RedirectIP4[:], redirectIP)
+	if cfg.RedirectIP6 != nil && cfg.RedirectIP6.To4() == nil {
+		copy(conf.RedirectIP6[:], cfg.RedirectIP6.To16())
+	}
+	if err := a.mapFor("sema_config_map").Put(uint32(0), conf); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("failed to write redirect config: %v", err)
+	}
+	for _, port := range cfg.Ports {
+		if err := a.mapFor("sema_ports").Put(uint32(port), uint32(1)); err != nil {
+			a.Close()
+			return nil, fmt.Errorf("failed to add port %d: %v", port, err)
+		}
+	}
+
+	// Healthy until proven otherwise
+	if err := a.Heartbeat(); err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	// 2. Attach to the root cgroup: every pod on the node is subject to it.
+	// Links are pinned so fail-closed pods stay redirected if we crash; drop
+	// the ones a crashed predecessor left behind first.
+	linkDir := filepath.Join(cfg.PinPath, "links")
+	if err := os.MkdirAll(linkDir, 0o700); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("failed to create link pin path: %v", err)
+	}
+	attachments := []struct {
+		program string
+		attach  ebpf.AttachType
+	}{
+		{"sema_connect4", ebpf.AttachCGroupInet4Connect},
+		{"sema_connect6", ebpf.AttachCGroupInet6Connect},
+		{"sema_sockops", ebpf.AttachCGroupSockOps},
+	}
+	for _, at := range attachments {
+		pinPath := filepath.Join(linkDir, at.program)
+		if stale, err := link.LoadPinnedLink(pinPath, nil); err == nil {
+			stale.Unpin()
+			stale.Close()
+			log.Printf("🐝 Detached %s left behind by a previous agent", at.program)
+		}
+
+		prog := coll.Programs[at.program]
+		if prog == nil {
+			a.Close()
+			return nil, fmt.Errorf("program %s not found in %s", at.program, cfg.ObjectPath)
+		}
+		l, err := link.AttachCgroup(link.CgroupOptions{
+			Path:    cfg.CgroupPath,
+			Attach:  at.attach,
+			Program: prog,
+		})
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("failed to attach %s to %s: %v", at.program, cfg.CgroupPath, err)
+		}
+		a.links = append(a.links, l)
+		if err := l.Pin(pinPath); err != nil {
+			a.Close()
+			return nil, fmt.Errorf("failed to pin %s: %v", at.program, err)
+		}
+	}
+
+	log.Printf("🐝 SemaMesh Interceptor is ACTIVE (redirecting ports %v to %s:%d)", cfg.Ports, redirectIP, cfg.RedirectPort)
+	return a, nil
+}
+
+func (a *Agent) mapFor(name string) *ebpf.Map {
+	return a.coll.Maps[name]
+}
+
+// AddTarget starts redirecting connections to the given provider CIDR.
+// See TargetSet for keeping targets in sync with DNS.
+func (a *Agent) AddTarget(cidr *net.IPNet) error {
+	return a.updateTarget(cidr, true)
+}
+
+// RemoveTarget stops redirecting connections to the given CIDR
+func (a *Agent) RemoveTarget(cidr *net.IPNet) error {
+	if err := a.updateTarget(cidr, false); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (a *Agent) updateTarget(cidr *net.IPNet, add bool) error {
+	ones, _ := cidr.Mask.Size()
+
+	var m *ebpf.Map
+	var key interface{}
+	if ip4 := cidr.IP.To4(); ip4 != nil && len(cidr.Mask) == net.IPv4len {
+		k := lpmKey4{PrefixLen: uint32(ones)}
+		copy(k.Addr[:], ip4)
+		m, key = a.mapFor("sema_targets4"), k
+	} else {
+		k := lpmKey6{PrefixLen: uint32(ones)}
+		copy(k.Addr[:], cidr.IP.To16())
+		m, key = a.mapFor("sema_targets6"), k
+	}
+
+	if add {
+		return m.Put(key, uint32(1))
+	}
+	return m.Delete(key)
+}
+
+// AddCgroup starts intercepting connections made from the given cgroup.
+// failClosed keeps redirecting them while the waypoint is unhealthy.
+func (a *Agent) AddCgroup(id uint64, failClosed bool) error {
+	flags := cgroupIntercept
+	if failClosed {
+		flags |= cgroupFailClosed
+	}
+	return a.mapFor("sema_intercept_cgroups").Put(id, flags)
+}
+
+// RemoveCgroup stops intercepting connections made from the given cgroup
+func (a *Agent) RemoveCgroup(id uint64) error {
+	if err := a.mapFor("sema_intercept_cgroups").Delete(id); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return err
+	}
+	a.mapFor("sema_bypass_count").Delete(id)
+	return nil
+}
+
+// Heartbeat tells the programs the waypoint is healthy. Without one for
+// Config.StaleAfter, fail-open pods bypass it.
+func (a *Agent) Heartbeat() error {
+	var ts unix.Timespec
+	// bpf_ktime_get_ns uses CLOCK_MONOTONIC
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return err
+	}
+	return a.mapFor("sema_heartbeat").Put(uint32(0), uint64(ts.Nano()))
+}
+
+// BypassCounts returns, per cgroup ID, how many connections bypassed the
+// waypoint because it was unhealthy. Counters only go up.
+func (a *Agent) BypassCounts() (map[uint64]uint64, error) {
+	counts := make(map[uint64]uint64)
+	var id, count uint64
+	iter := a.mapFor("sema_bypass_count").Iterate()
+	for iter.Next(&id, &count) {
+		counts[id] = count
+	}
+	return counts, iter.Err()
+}
+
+// OriginalDst returns where a redirected connection was originally headed,
+// given its remote address as seen by the waypoint. This is our equivalent
+// of SO_ORIGINAL_DST. The entry is consumed: call it once per connection.
+func (a *Agent) OriginalDst(remote net.Addr) (*net.TCPAddr, bool) {
+	tcp, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return nil, false
+	}
+
+	var orig originInfo
+	if err := a.mapFor("sema_orig_dst").LookupAndDelete(newSockKey(tcp), &orig); err != nil {
+		return nil, false
+	}
+	return orig.addr(), true
+}
+
+// DialControl must be used as net.Dialer.Control for the waypoint's own
+// upstream connections, otherwise they'd be redirected back to itself.
+// It registers the socket cookie in sema_skip_cookies right before connect().
+func (a *Agent) DialControl(network, address string, c syscall.RawConn) error {
+	var cookie uint64
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		cookie, sockErr = unix.GetsockoptUint64(int(fd), unix.SOL_SOCKET, unix.SO_COOKIE)
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("failed to read socket cookie: %v", sockErr)
+	}
+	return a.mapFor("sema_skip_cookies").Put(cookie, uint32(1))
+}
+
+// Close detaches the programs and removes the pinned maps and links
+func (a *Agent) Close() error {
+	var errs []error
+	for _, l := range a.links {
+		if err := l.Unpin(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := l.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	a.links = nil
+
+	for name, m := range a.coll.Maps {
+		if m.IsPinned() {
+			if err := m.Unpin(); err != nil {
+				errs = append(errs, fmt.Errorf("unpin %s: %v", name, err))
+			}
+		}
+	}
+	a.coll.Close()
+
+	log.Println("🐝 SemaMesh Interceptor detached")
+	return errors.Join(errs...)
+}
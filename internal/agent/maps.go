@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Go mirrors of the structs in bpf/sema_redirect.c. Addresses are kept as
+// byte arrays so they stay in network order whatever the host endianness.
+
+// sema_intercept_cgroups flags
+const (
+	cgroupIntercept  uint32 = 1
+	cgroupFailClosed uint32 = 2
+)
+
+// bpfConfig is the single entry of sema_config_map
+type bpfConfig struct {
+	RedirectIP4  [4]byte
+	RedirectIP6  [16]byte
+	RedirectPort uint32
+	StaleNs      uint64
+}
+
+type lpmKey4 struct {
+	PrefixLen uint32
+	Addr      [4]byte
+}
+
+type lpmKey6 struct {
+	PrefixLen uint32
+	Addr      [16]byte
+}
+
+// originInfo is where a redirected connection was originally headed
+type originInfo struct {
+	Family uint32
+	IP4    [4]byte
+	IP6    [16]byte
+	Port   uint32
+}
+
+// sockKey identifies a redirected connection by its source address
+type sockKey struct {
+	Family uint32
+	SIP4   [4]byte
+	SIP6   [16]byte
+	SPort  uint32
+}
+
+func (o originInfo) addr() *net.TCPAddr {
+	if o.Family == unix.AF_INET6 {
+		return &net.TCPAddr{IP: net.IP(o.IP6[:]), Port: int(o.Port)}
+	}
+	return &net.TCPAddr{IP: net.IP(o.IP4[:]), Port: int(o.Port)}
+}
+
+func newSockKey(addr *net.TCPAddr) sockKey {
+	key := sockKey{SPort: uint32(addr.Port)}
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		key.Family = unix.AF_INET
+		copy(key.SIP4[:], ip4)
+	} else {
+		key.Family = unix.AF_INET6
+		copy(key.SIP6[:], addr.IP.To16())
+	}
+	return key
+}
@@ -0,0 +1,345 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/semamesh/SemaMesh/pkg/audit"
+	"github.com/semamesh/SemaMesh/pkg/metrics"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// InterceptLabel opts a pod or namespace in or out of interception.
+	// On pods it may also be set as an annotation; the pod wins over its
+	// namespace.
+	InterceptLabel = "semamesh.io/intercept"
+
+	InterceptEnabled  = "enabled"
+	InterceptDisabled = "disabled"
+
+	// FailureModeLabel picks what happens to a pod's traffic while the waypoint
+	// is down or overloaded. Resolved like InterceptLabel.
+	FailureModeLabel = "semamesh.io/failure-mode"
+
+	// FailOpen lets traffic bypass inspection, with an audit trail
+	FailOpen = "open"
+	// FailClosed keeps redirecting it: connections fail until we're back
+	FailClosed = "closed"
+
+	// Bypass reasons, as reported in metrics and audit entries
+	BypassUnhealthy  = "waypoint_unhealthy"
+	BypassOverloaded = "overloaded"
+
+	// policyResync also catches containers (re)started between pod events
+	policyResync = 15 * time.Second
+	// bypassReport is how often kernel bypass counters are turned into
+	// metrics and audit entries
+	bypassReport = 5 * time.Second
+)
+
+// DefaultExemptNamespaces are never intercepted unless a pod explicitly
+// opts in: breaking them breaks the cluster
+var DefaultExemptNamespaces = []string{"kube-system", "kube-public", "kube-node-lease", "semamesh-system"}
+
+// PolicyConfig configures which pods on this node are intercepted
+type PolicyConfig struct {
+	NodeName string
+	// DefaultEnabled applies to pods and namespaces without the label.
+	// Turn it off to roll out gradually, namespace by namespace.
+	DefaultEnabled   bool
+	ExemptNamespaces []string
+	// CgroupRoot is where pod cgroups are looked up (cgroup v2)
+	CgroupRoot string
+	// DefaultFailClosed applies to pods and namespaces without FailureModeLabel
+	DefaultFailClosed bool
+}
+
+// interceptedPod is what we know about a pod we intercept
+type interceptedPod struct {
+	namespace  string
+	name       string
+	failClosed bool
+}
+
+// PolicyReconciler keeps sema_intercept_cgroups in sync with the pods
+// running on this node and their semamesh.io/intercept labels
+type PolicyReconciler struct {
+	agent  *Agent
+	cfg    PolicyConfig
+	exempt map[string]bool
+
+	pods       corelisters.PodLister
+	namespaces corelisters.NamespaceLister
+	synced     []cache.InformerSynced
+	factories  []informers.SharedInformerFactory
+
+	trigger chan struct{}
+	cgroups *cgroupIndex
+	// cgroup ID -> pod, as written to the map
+	applied map[uint64]interceptedPod
+	// bypass counts already reported, per cgroup ID
+	bypassed map[uint64]uint64
+
+	mu sync.RWMutex
+	// pod IP -> pod, for decisions on connections the proxy accepted
+	byIP map[string]interceptedPod
+}
+
+// NewPolicyReconciler watches the pods on cfg.NodeName and all namespaces
+func NewPolicyReconciler(a *Agent, clientset kubernetes.Interface, cfg PolicyConfig) (*PolicyReconciler, error) {
+	if cfg.NodeName == "" {
+		return nil, fmt.Errorf("node name is required")
+	}
+	if cfg.CgroupRoot == "" {
+		cfg.CgroupRoot = DefaultCgroupPath
+	}
+
+	podFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 10*time.Minute,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", cfg.NodeName).String()
+		}),
+	)
+	nsFactory := informers.NewSharedInformerFactory(clientset, 10*time.Minute)
+
+	r := &PolicyReconciler{
+		agent:      a,
+		cfg:        cfg,
+		exempt:     make(map[string]bool),
+		pods:       podFactory.Core().V1().Pods().Lister(),
+		namespaces: nsFactory.Core().V1().Namespaces().Lister(),
+		factories:  []informers.SharedInformerFactory{podFactory, nsFactory},
+		trigger:    make(chan struct{}, 1),
+		cgroups:    newCgroupIndex(cfg.CgroupRoot),
+		applied:    make(map[uint64]interceptedPod),
+		bypassed:   make(map[uint64]uint64),
+		byIP:       make(map[string]interceptedPod),
+	}
+	for _, ns := range cfg.ExemptNamespaces {
+		r.exempt[ns] = true
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { r.Trigger() },
+		UpdateFunc: func(interface{}, interface{}) { r.Trigger() },
+		DeleteFunc: func(interface{}) { r.Trigger() },
+	}
+	for _, inf := range []cache.SharedIndexInformer{
+		podFactory.Core().V1().Pods().Informer(),
+		nsFactory.Core().V1().Namespaces().Informer(),
+	} {
+		inf.AddEventHandler(handler)
+		r.synced = append(r.synced, inf.HasSynced)
+	}
+	return r, nil
+}
+
+// Trigger schedules a reconcile
+func (r *PolicyReconciler) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run reconciles on pod/namespace changes and periodically, until stopCh closes
+func (r *PolicyReconciler) Run(stopCh <-chan struct{}) error {
+	for _, f := range r.factories {
+		f.Start(stopCh)
+	}
+	if !cache.WaitForCacheSync(stopCh, r.synced...) {
+		return fmt.Errorf("failed to sync pod/namespace caches")
+	}
+
+	ticker := time.NewTicker(policyResync)
+	defer ticker.Stop()
+	bypassTicker := time.NewTicker(bypassReport)
+	defer bypassTicker.Stop()
+	r.reconcile()
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case <-r.trigger:
+			r.reconcile()
+		case <-ticker.C:
+			r.reconcile()
+		case <-bypassTicker.C:
+			r.reportBypasses()
+		}
+	}
+}
+
+// Enabled resolves the effective policy of a pod: pod label/annotation, then
+// namespace label, then exempt namespaces, then the default
+func (r *PolicyReconciler) Enabled(pod *corev1.Pod) bool {
+	if v, ok := pod.Labels[InterceptLabel]; ok {
+		return v == InterceptEnabled
+	}
+	if v, ok := pod.Annotations[InterceptLabel]; ok {
+		return v == InterceptEnabled
+	}
+	if ns, err := r.namespaces.Get(pod.Namespace); err == nil {
+		if v, ok := ns.Labels[InterceptLabel]; ok {
+			return v == InterceptEnabled
+		}
+	}
+	if r.exempt[pod.Namespace] {
+		return false
+	}
+	return r.cfg.DefaultEnabled
+}
+
+// FailClosed resolves the failure mode of a pod, like Enabled
+func (r *PolicyReconciler) FailClosed(pod *corev1.Pod) bool {
+	if v, ok := pod.Labels[FailureModeLabel]; ok {
+		return v == FailClosed
+	}
+	if v, ok := pod.Annotations[FailureModeLabel]; ok {
+		return v == FailClosed
+	}
+	if ns, err := r.namespaces.Get(pod.Namespace); err == nil {
+		if v, ok := ns.Labels[FailureModeLabel]; ok {
+			return v == FailClosed
+		}
+	}
+	return r.cfg.DefaultFailClosed
+}
+
+// Bypass decides what to do with a connection the waypoint can't inspect
+// (e.g. overloaded). It returns true, and records the bypass, if the pod at
+// remote fails open; false means the connection should be refused.
+func (r *PolicyReconciler) Bypass(remote net.Addr, reason string) bool {
+	host, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return false
+	}
+	r.mu.RLock()
+	pod, ok := r.byIP[host]
+	r.mu.RUnlock()
+	if !ok || pod.failClosed {
+		return false
+	}
+	recordBypass(pod, reason, 1)
+	return true
+}
+
+// reportBypasses turns the kernel's fail-open counters into metrics and
+// audit entries
+func (r *PolicyReconciler) reportBypasses() {
+	counts, err := r.agent.BypassCounts()
+	if err != nil {
+		log.Printf("INTERCEPT_POLICY: Failed to read bypass counters: %v", err)
+		return
+	}
+	for id, count := range counts {
+		delta := count - r.bypassed[id]
+		if count < r.bypassed[id] {
+			// The cgroup was removed and re-added: the counter restarted
+			delta = count
+		}
+		r.bypassed[id] = count
+		if delta == 0 {
+			continue
+		}
+		if pod, ok := r.applied[id]; ok {
+			recordBypass(pod, BypassUnhealthy, delta)
+		}
+	}
+}
+
+func recordBypass(pod interceptedPod, reason string, n uint64) {
+	metrics.BypassedConnections.WithLabelValues(pod.namespace, reason).Add(float64(n))
+	audit.Submit(audit.LogEntry{
+		Timestamp: time.Now(),
+		Namespace: pod.namespace,
+		PodName:   pod.name,
+		Decision:  "BYPASS",
+		Reason:    fmt.Sprintf("%s: %d connection(s) not inspected (fail-open)", reason, n),
+	})
+}
+
+func (r *PolicyReconciler) reconcile() {
+	pods, err := r.pods.List(labels.Everything())
+	if err != nil {
+		log.Printf("INTERCEPT_POLICY: Failed to list pods: %v", err)
+		return
+	}
+
+	var enabled []*corev1.Pod
+	var uids []types.UID
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if r.Enabled(pod) {
+			enabled = append(enabled, pod)
+			uids = append(uids, pod.UID)
+		}
+	}
+
+	// Only pods we haven't located yet cost a lookup
+	r.cgroups.lookup(uids)
+	keep := make(map[types.UID]bool, len(uids))
+	desired := make(map[uint64]interceptedPod)
+	byIP := make(map[string]interceptedPod)
+	for _, pod := range enabled {
+		keep[pod.UID] = true
+		ip := interceptedPod{namespace: pod.Namespace, name: pod.Name, failClosed: r.FailClosed(pod)}
+		for _, id := range r.cgroups.ids(pod.UID) {
+			desired[id] = ip
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			byIP[podIP.IP] = ip
+		}
+	}
+
+	r.cgroups.forget(keep)
+
+	r.mu.Lock()
+	r.byIP = byIP
+	r.mu.Unlock()
+
+	added, removed := 0, 0
+	for id, pod := range desired {
+		if cur, ok := r.applied[id]; ok && cur.failClosed == pod.failClosed {
+			continue
+		}
+		if err := r.agent.AddCgroup(id, pod.failClosed); err != nil {
+			log.Printf("INTERCEPT_POLICY: Failed to enable %s/%s (cgroup %d): %v", pod.namespace, pod.name, id, err)
+			continue
+		}
+		if _, ok := r.applied[id]; !ok {
+			added++
+		}
+		r.applied[id] = pod
+	}
+	for id, pod := range r.applied {
+		if _, ok := desired[id]; ok {
+			continue
+		}
+		if err := r.agent.RemoveCgroup(id); err != nil {
+			log.Printf("INTERCEPT_POLICY: Failed to disable %s/%s (cgroup %d): %v", pod.namespace, pod.name, id, err)
+			continue
+		}
+		delete(r.applied, id)
+		delete(r.bypassed, id)
+		removed++
+	}
+
+	if added > 0 || removed > 0 {
+		log.Printf("INTERCEPT_POLICY: %d cgroups intercepted (+%d, -%d)", len(r.applied), added, removed)
+	}
+}
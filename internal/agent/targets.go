@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Re-resolve no faster than this, even for tiny TTLs (CDNs use 20-60s)
+	minRefresh = 15 * time.Second
+	// ...and no slower than this, so new IPs are picked up reasonably fast
+	maxRefresh = 5 * time.Minute
+	// retryRefresh is used after a failed lookup
+	retryRefresh = 30 * time.Second
+	// ipGrace keeps an IP captured after its TTL ran out: clients cache DNS
+	// answers a little longer than they should, and connection pools live on
+	ipGrace = 2 * time.Minute
+)
+
+// targetMap is the part of the Agent the target set drives
+type targetMap interface {
+	AddTarget(cidr *net.IPNet) error
+	RemoveTarget(cidr *net.IPNet) error
+}
+
+// TargetSet keeps the redirect maps in sync with a list of provider hostnames
+// (resolved periodically, honouring record TTLs) and static CIDRs
+type TargetSet struct {
+	maps     targetMap
+	resolver *dnsResolver
+	hosts    []string
+	static   []*net.IPNet
+
+	mu      sync.Mutex
+	entries map[string]*targetEntry
+	status  map[string]*hostStatus
+}
+
+type targetEntry struct {
+	cidr   *net.IPNet
+	static bool
+	// hostname -> when the IP stops being captured for it
+	hosts map[string]time.Time
+}
+
+type hostStatus struct {
+	nextRefresh time.Time
+	lastRefresh time.Time
+	lastErr     string
+	ttl         time.Duration
+}
+
+// NewTargetSet resolves hostnames with dnsServer ("" = /etc/resolv.conf)
+func NewTargetSet(a *Agent, hosts []string, static []*net.IPNet, dnsServer string) (*TargetSet, error) {
+	resolver, err := newDNSResolver(dnsServer)
+	if err != nil && len(hosts) > 0 {
+		return nil, err
+	}
+
+	t := &TargetSet{
+		maps:     a,
+		resolver: resolver,
+		hosts:    hosts,
+		static:   static,
+		entries:  make(map[string]*targetEntry),
+		status:   make(map[string]*hostStatus),
+	}
+	for _, host := range hosts {
+		t.status[host] = &hostStatus{}
+	}
+	for _, cidr := range static {
+		if err := a.AddTarget(cidr); err != nil {
+			return nil, err
+		}
+		t.entries[cidr.String()] = &targetEntry{cidr: cidr, static: true, hosts: map[string]time.Time{}}
+	}
+	return t, nil
+}
+
+// Run resolves hostnames when their TTL runs out and expires stale IPs,
+// until ctx is done
+func (t *TargetSet) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		t.refresh(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *TargetSet) refresh(ctx context.Context, now time.Time) {
+	for _, host := range t.hosts {
+		t.mu.Lock()
+		due := !now.Before(t.status[host].nextRefresh)
+		t.mu.Unlock()
+		if due {
+			t.resolve(ctx, host, now)
+		}
+	}
+	t.expire(now)
+}
+
+func (t *TargetSet) resolve(ctx context.Context, host string, now time.Time) {
+	ips, ttl, err := t.resolver.lookup(ctx, host)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st := t.status[host]
+	st.lastRefresh = now
+	if err != nil {
+		// Keep the IPs we have: they expire on their own schedule
+		log.Printf("TARGETS: Failed to resolve %s: %v", host, err)
+		st.lastErr = err.Error()
+		st.nextRefresh = now.Add(retryRefresh)
+		return
+	}
+	st.lastErr = ""
+	st.ttl = ttl
+
+	refresh := ttl
+	if refresh < minRefresh {
+		refresh = minRefresh
+	}
+	if refresh > maxRefresh {
+		refresh = maxRefresh
+	}
+	st.nextRefresh = now.Add(refresh)
+
+	expires := now.Add(ttl + ipGrace)
+	for _, ip := range ips {
+		bits := 128
+		if ip.To4() != nil {
+			bits = 32
+		}
+		cidr := &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		key := cidr.String()
+
+		entry, ok := t.entries[key]
+		if !ok {
+			if err := t.maps.AddTarget(cidr); err != nil {
+				log.Printf("TARGETS: Failed to add %s (%s): %v", key, host, err)
+				continue
+			}
+			entry = &targetEntry{cidr: cidr, hosts: map[string]time.Time{}}
+			t.entries[key] = entry
+			log.Printf("TARGETS: + %s (%s, ttl %s)", key, host, ttl)
+		}
+		if expires.After(entry.hosts[host]) {
+			entry.hosts[host] = expires
+		}
+	}
+}
+
+// expire drops IPs no hostname has resolved to for longer than TTL + grace
+func (t *TargetSet) expire(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, entry := range t.entries {
+		for host, expires := range entry.hosts {
+			if now.After(expires) {
+				delete(entry.hosts, host)
+			}
+		}
+		if entry.static || len(entry.hosts) > 0 {
+			continue
+		}
+		if err := t.maps.RemoveTarget(entry.cidr); err != nil {
+			log.Printf("TARGETS: Failed to remove %s: %v", key, err)
+			continue
+		}
+		delete(t.entries, key)
+		log.Printf("TARGETS: - %s (expired)", key)
+	}
+}
+
+// TargetInfo describes one captured destination
+type TargetInfo struct {
+	CIDR   string               `json:"cidr"`
+	Static bool                 `json:"static,omitempty"`
+	Hosts  map[string]time.Time `json:"hosts,omitempty"` // hostname -> expiry
+}
+
+// HostInfo describes the resolution state of one hostname
+type HostInfo struct {
+	Host        string    `json:"host"`
+	TTL         string    `json:"ttl,omitempty"`
+	LastRefresh time.Time `json:"lastRefresh,omitempty"`
+	NextRefresh time.Time `json:"nextRefresh,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// Snapshot returns the captured destinations and the hostname states
+func (t *TargetSet) Snapshot() ([]TargetInfo, []HostInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	targets := make([]TargetInfo, 0, len(t.entries))
+	for key, entry := range t.entries {
+		info := TargetInfo{CIDR: key, Static: entry.static}
+		if len(entry.hosts) > 0 {
+			info.Hosts = make(map[string]time.Time, len(entry.hosts))
+			for host, expires := range entry.hosts {
+				info.Hosts[host] = expires
+			}
+		}
+		targets = append(targets, info)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].CIDR < targets[j].CIDR })
+
+	hosts := make([]HostInfo, 0, len(t.hosts))
+	for _, host := range t.hosts {
+		st := t.status[host]
+		info := HostInfo{Host: host, LastRefresh: st.lastRefresh, NextRefresh: st.nextRefresh, LastError: st.lastErr}
+		if !st.lastRefresh.IsZero() {
+			info.TTL = st.ttl.String()
+		}
+		hosts = append(hosts, info)
+	}
+	return targets, hosts
+}
+
+// DebugHandler serves the current target set as JSON (GET /debug/targets)
+func (t *TargetSet) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets, hosts := t.Snapshot()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Targets []TargetInfo `json:"targets"`
+			Hosts   []HostInfo   `json:"hosts"`
+		}{targets, hosts})
+	})
+}
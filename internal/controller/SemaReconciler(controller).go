@@ -11,6 +11,8 @@ import (
 	"k8s.io/client-go/tools/record" // NEW: For Events
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/semamesh/semamesh/pkg/notify"
 )
 
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;update;patch;delete
@@ -21,6 +23,8 @@ type SemaReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder // NEW: For communicating with the user
+	// Approvals, if set, adds approve/reject actions to pause notifications
+	Approvals *ApprovalServer
 }
 
 func (r *SemaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -29,17 +33,46 @@ func (r *SemaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// 1. Detect Intent to Freeze
+	// 1. Detect Intent to Freeze (retried until the checkpoint succeeds,
+	// counted once)
 	if pod.Annotations["semamesh.io/action"] == "PAUSE" {
-		r.Recorder.Event(&pod, "Normal", "Freezing", "Agent reasoning gate triggered. Checkpointing state...")
+		if pod.Annotations[PauseReportedAnnotation] == "" {
+			if err := r.reportPause(ctx, &pod, PauseRequested); err != nil {
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Event(&pod, "Normal", "Freezing", "Agent reasoning gate triggered. Checkpointing state...")
+			pauseEvents.WithLabelValues(pod.Namespace, PauseRequested).Inc()
+		}
 		return r.handleFreeze(ctx, &pod)
 	}
+	// The pause was called off before the agent froze
+	if _, ok := pod.Annotations[PauseReportedAnnotation]; ok {
+		patch := client.MergeFrom(pod.DeepCopy())
+		delete(pod.Annotations, PauseReportedAnnotation)
+		if err := r.Patch(ctx, &pod, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
 
 	// 2. Handle Auto-Reject Timeout
 	if pod.Annotations["semamesh.io/status"] == "FROZEN" {
+		// A human decided (approval endpoint or kubectl annotate)
+		switch pod.Annotations[ApprovalAnnotation] {
+		case Approved:
+			return r.resume(ctx, &pod)
+		case Rejected:
+			by := pod.Annotations[ApprovedByAnnotation]
+			r.Recorder.Event(&pod, "Warning", "Rejected", "Rejected by "+by+". Terminating pod.")
+			pauseEvents.WithLabelValues(pod.Namespace, PauseRejected).Inc()
+			notifyPause(&pod, PauseRejected, notify.SeverityInfo, "Rejected by "+by+". The pod is terminated.")
+			return ctrl.Result{}, r.Delete(ctx, &pod)
+		}
+
 		freezeTime, err := time.Parse(time.RFC3339, pod.Annotations["semamesh.io/frozen-at"])
-		if err == nil && time.Since(freezeTime) > 30*time.Minute {
+		if err == nil && time.Since(freezeTime) > ApprovalTimeout {
 			r.Recorder.Event(&pod, "Warning", "AutoReject", "Human approval timeout reached. Terminating pod for safety.")
+			pauseEvents.WithLabelValues(pod.Namespace, PauseAutoRejected).Inc()
+			notifyPause(&pod, PauseAutoRejected, notify.SeverityCritical, "Human approval timeout reached. The pod is terminated for safety.")
 			return ctrl.Result{}, r.Delete(ctx, &pod)
 		}
 		// Re-check every minute
@@ -66,20 +99,63 @@ func (r *SemaReconciler) handleFreeze(ctx context.Context, pod *corev1.Pod) (ctr
 	resp, err := client.Do(req)
 
 	if err != nil || (resp != nil && resp.StatusCode != http.StatusOK) {
-		r.Recorder.Event(pod, "Warning", "FreezeFailed", "Kubelet checkpoint call failed.")
+		// Retried with backoff: count and notify the first failure only
+		if pod.Annotations[PauseReportedAnnotation] != PauseFreezeFailed {
+			if perr := r.reportPause(ctx, pod, PauseFreezeFailed); perr != nil {
+				return ctrl.Result{}, perr
+			}
+			r.Recorder.Event(pod, "Warning", "FreezeFailed", "Kubelet checkpoint call failed.")
+			pauseEvents.WithLabelValues(pod.Namespace, PauseFreezeFailed).Inc()
+			notifyPause(pod, PauseFreezeFailed, notify.SeverityCritical, "Kubelet checkpoint call failed. The agent is still running.")
+		}
 		return ctrl.Result{RequeueAfter: 10 * time.Second}, err
 	}
 
 	// Update metadata to show status is now FROZEN
+	frozenAt := time.Now()
 	pod.Annotations["semamesh.io/status"] = "FROZEN"
-	pod.Annotations["semamesh.io/frozen-at"] = time.Now().Format(time.RFC3339)
+	pod.Annotations["semamesh.io/frozen-at"] = frozenAt.Format(time.RFC3339)
 	delete(pod.Annotations, "semamesh.io/action")
+	delete(pod.Annotations, PauseReportedAnnotation)
+	// A decision on an earlier pause doesn't carry over
+	delete(pod.Annotations, ApprovalAnnotation)
+	delete(pod.Annotations, ApprovedByAnnotation)
+
+	if err := r.Update(ctx, pod); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	r.Recorder.Event(pod, "Normal", "Frozen", "State successfully preserved. Awaiting approval ("+ApprovalAnnotation+").")
+	pauseEvents.WithLabelValues(pod.Namespace, PauseFrozen).Inc()
+	var actions []notify.Action
+	if r.Approvals != nil {
+		actions = r.Approvals.Actions(pod, frozenAt)
+	}
+	notifyPause(pod, PauseFrozen, notify.SeverityWarning, "State successfully preserved. Awaiting approval.", actions...)
+	return ctrl.Result{}, nil
+}
+
+// reportPause records on the pod that event was counted for its pending pause
+func (r *SemaReconciler) reportPause(ctx context.Context, pod *corev1.Pod, event string) error {
+	patch := client.MergeFrom(pod.DeepCopy())
+	pod.Annotations[PauseReportedAnnotation] = event
+	return r.Patch(ctx, pod, patch)
+}
 
+// resume lets an approved agent carry on
+func (r *SemaReconciler) resume(ctx context.Context, pod *corev1.Pod) (ctrl.Result, error) {
+	by := pod.Annotations[ApprovedByAnnotation]
+	pod.Annotations["semamesh.io/status"] = "RESUMED"
+	delete(pod.Annotations, "semamesh.io/frozen-at")
+	delete(pod.Annotations, ApprovalAnnotation)
+	delete(pod.Annotations, ApprovedByAnnotation)
 	if err := r.Update(ctx, pod); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	r.Recorder.Event(pod, "Normal", "Frozen", "State successfully preserved. Awaiting 'kubectl sema approve'.")
+	r.Recorder.Event(pod, "Normal", "Approved", "Approved by "+by+". Agent resumed.")
+	pauseEvents.WithLabelValues(pod.Namespace, PauseApproved).Inc()
+	notifyPause(pod, PauseApproved, notify.SeverityInfo, "Approved by "+by+". The agent resumed.")
 	return ctrl.Result{}, nil
 }
 
@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/semamesh/semamesh/pkg/notify"
+)
+
+const (
+	// ApprovalAnnotation carries the decision on a frozen agent: "approved"
+	// resumes it, "rejected" terminates it. Set by the approval endpoint, or
+	// by hand: kubectl annotate pod <agent> semamesh.io/approval=approved
+	ApprovalAnnotation = "semamesh.io/approval"
+	// ApprovedByAnnotation records who decided
+	ApprovedByAnnotation = "semamesh.io/approved-by"
+
+	Approved = "approved"
+	Rejected = "rejected"
+
+	// ApprovalTimeout is how long a frozen agent waits before auto-reject
+	ApprovalTimeout = 30 * time.Minute
+
+	// slackMaxSkew rejects replayed Slack callbacks
+	slackMaxSkew = 5 * time.Minute
+	// slackDecideTimeout bounds recording a decision made in Slack, which
+	// happens after the callback was answered
+	slackDecideTimeout = 30 * time.Second
+)
+
+// ApprovalServer serves the approve/reject actions of pause notifications:
+// signed links (a confirmation page, then a POST) and Slack button callbacks.
+// It only records the decision on the pod; the reconciler acts on it.
+type ApprovalServer struct {
+	Client client.Client
+	// BaseURL is where this server is reachable from approvers' phones
+	BaseURL string
+	// Secret signs the links
+	Secret []byte
+
+	// Approvers allowed to decide (e-mails from IdentityHeader, Slack user
+	// names or IDs). Empty lets anyone holding a valid link decide.
+	Approvers map[string]bool
+	// IdentityHeader names the approver, set by an authenticating proxy in
+	// front of this server (e.g. X-Forwarded-Email from oauth2-proxy)
+	IdentityHeader string
+	// SlackSigningSecret verifies Slack interactivity callbacks
+	SlackSigningSecret []byte
+}
+
+// decision is what a signed link or button stands for
+type decision struct {
+	Namespace string    `json:"ns"`
+	Name      string    `json:"pod"`
+	UID       types.UID `json:"uid"`
+	Action    string    `json:"action"`
+	Expires   int64     `json:"exp"`
+}
+
+// Actions returns the approve/reject buttons for a frozen pod, valid until
+// it would be auto-rejected
+func (s *ApprovalServer) Actions(pod *corev1.Pod, frozenAt time.Time) []notify.Action {
+	expires := frozenAt.Add(ApprovalTimeout).Unix()
+	var actions []notify.Action
+	for _, action := range []string{Approved, Rejected} {
+		token := s.sign(decision{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID, Action: action, Expires: expires})
+		a := notify.Action{
+			ID:    "semamesh_" + action,
+			URL:   strings.TrimSuffix(s.BaseURL, "/") + "/approval?token=" + url.QueryEscape(token),
+			Value: token,
+		}
+		if action == Approved {
+			a.Label, a.Style = "Approve", "primary"
+		} else {
+			a.Label, a.Style = "Reject", "danger"
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
+
+// Handler serves /approval (signed links) and /approval/slack (Slack callbacks)
+func (s *ApprovalServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/approval", s.serveLink)
+	mux.HandleFunc("/approval/slack", s.serveSlack)
+	return mux
+}
+
+var confirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html><head><meta name="viewport" content="width=device-width, initial-scale=1"><title>SemaMesh</title></head>
+<body style="font-family: sans-serif; max-width: 32em; margin: 2em auto; padding: 0 1em">
+<h2>{{if eq .Action "approved"}}Approve{{else}}Reject{{end}} {{.Namespace}}/{{.Name}}?</h2>
+<p>{{if eq .Action "approved"}}The paused agent resumes.{{else}}The paused agent is terminated.{{end}}</p>
+<form method="POST"><input type="hidden" name="token" value="{{.Token}}">
+<button type="submit" style="font-size: 1.2em; padding: .5em 2em">Confirm</button></form>
+</body></html>
+`))
+
+// serveLink shows a confirmation page on GET (link previews must not
+// decide anything) and records the decision on POST
+func (s *ApprovalServer) serveLink(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	d, err := s.verify(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		confirmPage.Execute(w, struct {
+			decision
+			Token string
+		}{d, token})
+	case http.MethodPost:
+		approver := "signed-link"
+		if s.IdentityHeader != "" {
+			if approver = r.Header.Get(s.IdentityHeader); approver == "" {
+				http.Error(w, "approver identity missing", http.StatusForbidden)
+				return
+			}
+		}
+		if !s.allowed(approver) {
+			http.Error(w, approver+" may not approve agents", http.StatusForbidden)
+			return
+		}
+		msg, code := s.decide(r.Context(), d, approver)
+		http.Error(w, msg, code)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// slackCallback is the part of a Slack block_actions payload we use
+type slackCallback struct {
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	ResponseURL string `json:"response_url"`
+}
+
+// serveSlack handles button clicks sent to the Slack app's interactivity URL
+func (s *ApprovalServer) serveSlack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || len(s.SlackSigningSecret) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if err := s.verifySlack(r.Header, body); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	var cb slackCallback
+	if err := json.Unmarshal([]byte(form.Get("payload")), &cb); err != nil || len(cb.Actions) == 0 {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	approver := cb.User.Username
+	if approver == "" {
+		approver = cb.User.ID
+	}
+	reply := func(msg string) {
+		if cb.ResponseURL != "" {
+			replySlack(cb.ResponseURL, msg)
+		}
+	}
+	d, err := s.verify(cb.Actions[0].Value)
+	switch {
+	case err != nil:
+		go reply(err.Error())
+	case !s.allowed(cb.User.Username) && !s.allowed(cb.User.ID):
+		go reply(approver + " may not approve agents")
+	default:
+		// Slack wants an answer within 3 seconds: the decision takes API
+		// calls, so it is recorded after answering and its outcome goes to
+		// response_url
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), slackDecideTimeout)
+			defer cancel()
+			msg, _ := s.decide(ctx, d, "slack:"+approver)
+			reply(msg)
+		}()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *ApprovalServer) allowed(approver string) bool {
+	return len(s.Approvers) == 0 || s.Approvers[approver]
+}
+
+// decide records d on the pod, once, while it is still frozen
+func (s *ApprovalServer) decide(ctx context.Context, d decision, approver string) (string, int) {
+	var pod corev1.Pod
+	err := s.Client.Get(ctx, types.NamespacedName{Namespace: d.Namespace, Name: d.Name}, &pod)
+	switch {
+	case apierrors.IsNotFound(err) || (err == nil && pod.UID != d.UID):
+		return "The agent is gone.", http.StatusGone
+	case err != nil:
+		return "Failed to look up the agent.", http.StatusInternalServerError
+	case pod.Annotations["semamesh.io/status"] != "FROZEN":
+		return "The agent is not awaiting approval.", http.StatusConflict
+	case pod.Annotations[ApprovalAnnotation] != "":
+		return fmt.Sprintf("Already %s by %s.", pod.Annotations[ApprovalAnnotation], pod.Annotations[ApprovedByAnnotation]), http.StatusConflict
+	}
+
+	// The resourceVersion in the patch makes concurrent decisions (two
+	// approvers clicking at once) fail instead of overwriting each other
+	patch := client.MergeFromWithOptions(pod.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	pod.Annotations[ApprovalAnnotation] = d.Action
+	pod.Annotations[ApprovedByAnnotation] = approver
+	if err := s.Client.Patch(ctx, &pod, patch); apierrors.IsConflict(err) {
+		return "Already decided.", http.StatusConflict
+	} else if err != nil {
+		return "Failed to record the decision.", http.StatusInternalServerError
+	}
+	ctrl.Log.WithName("approval").Info("pause decided", "pod", d.Namespace+"/"+d.Name, "decision", d.Action, "approver", approver)
+	return fmt.Sprintf("%s/%s %s by %s.", d.Namespace, d.Name, d.Action, approver), http.StatusOK
+}
+
+// Tokens are base64url(JSON decision) + "." + base64url(HMAC-SHA256)
+func (s *ApprovalServer) sign(d decision) string {
+	payload, _ := json.Marshal(d)
+	enc := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(enc))
+	return enc + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *ApprovalServer) verify(token string) (decision, error) {
+	var d decision
+	enc, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return d, fmt.Errorf("invalid token")
+	}
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(enc))
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return d, fmt.Errorf("invalid token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil || json.Unmarshal(payload, &d) != nil {
+		return d, fmt.Errorf("invalid token")
+	}
+	if time.Now().Unix() > d.Expires {
+		return d, fmt.Errorf("this link has expired")
+	}
+	if d.Action != Approved && d.Action != Rejected {
+		return d, fmt.Errorf("invalid action")
+	}
+	return d, nil
+}
+
+// verifySlack checks the v0 request signature of a Slack callback
+func (s *ApprovalServer) verifySlack(h http.Header, body []byte) error {
+	ts, err := strconv.ParseInt(h.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing Slack timestamp")
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > slackMaxSkew || skew < -slackMaxSkew {
+		return fmt.Errorf("stale Slack request")
+	}
+	mac := hmac.New(sha256.New, s.SlackSigningSecret)
+	fmt.Fprintf(mac, "v0:%d:", ts)
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(want), []byte(h.Get("X-Slack-Signature"))) {
+		return fmt.Errorf("invalid Slack signature")
+	}
+	return nil
+}
+
+// replySlack posts the outcome in the thread of the notification
+func replySlack(responseURL, text string) {
+	body, _ := json.Marshal(map[string]interface{}{"text": text, "replace_original": false})
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(responseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		ctrl.Log.WithName("approval").Error(err, "failed to answer Slack")
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestVerifyToken(t *testing.T) {
+	s := &ApprovalServer{Secret: []byte("link-secret")}
+	valid := decision{Namespace: "finance", Name: "agent", UID: "uid-1", Action: Approved, Expires: time.Now().Add(time.Hour).Unix()}
+	token := s.sign(valid)
+	enc, sig, _ := strings.Cut(token, ".")
+
+	rejected := valid
+	rejected.Action = Rejected
+	otherEnc, _, _ := strings.Cut(s.sign(rejected), ".")
+	expired := valid
+	expired.Expires = time.Now().Add(-time.Minute).Unix()
+	deleted := valid
+	deleted.Action = "deleted"
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"valid", token, ""},
+		{"tampered payload", otherEnc + "." + sig, "invalid token signature"},
+		{"wrong signature", (&ApprovalServer{Secret: []byte("other-secret")}).sign(valid), "invalid token signature"},
+		{"bad signature encoding", enc + ".%%%", "invalid token signature"},
+		{"expired", s.sign(expired), "this link has expired"},
+		{"bad action", s.sign(deleted), "invalid action"},
+		{"malformed", enc, "invalid token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := s.verify(tt.token)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("verify: %v", err)
+				}
+				if d != valid {
+					t.Errorf("verify = %+v, want %+v", d, valid)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("verify error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// slackHeaders signs body like Slack does, at ts
+func slackHeaders(secret string, ts time.Time, body string) http.Header {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%d:%s", ts.Unix(), body)
+	h := http.Header{}
+	h.Set("X-Slack-Request-Timestamp", strconv.FormatInt(ts.Unix(), 10))
+	h.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return h
+}
+
+func TestVerifySlack(t *testing.T) {
+	s := &ApprovalServer{SlackSigningSecret: []byte("slack-secret")}
+	body := "payload=%7B%7D"
+	now := time.Now()
+
+	missing := slackHeaders("slack-secret", now, body)
+	missing.Del("X-Slack-Request-Timestamp")
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   string
+		err    string
+	}{
+		{"valid", slackHeaders("slack-secret", now, body), body, ""},
+		{"stale timestamp", slackHeaders("slack-secret", now.Add(-slackMaxSkew-time.Minute), body), body, "stale Slack request"},
+		{"future timestamp", slackHeaders("slack-secret", now.Add(slackMaxSkew+time.Minute), body), body, "stale Slack request"},
+		{"missing timestamp", missing, body, "missing Slack timestamp"},
+		{"wrong signature", slackHeaders("other-secret", now, body), body, "invalid Slack signature"},
+		{"tampered body", slackHeaders("slack-secret", now, body), "payload=%7B%22x%22%7D", "invalid Slack signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.verifySlack(tt.header, []byte(tt.body))
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("verifySlack: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("verifySlack error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// newApprovalServer returns a server whose cluster holds one frozen agent,
+// finance/agent, and an approve token for it
+func newApprovalServer(t *testing.T) (*ApprovalServer, string) {
+	t.Helper()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "finance",
+		Name:        "agent",
+		UID:         "uid-1",
+		Annotations: map[string]string{"semamesh.io/status": "FROZEN"},
+	}}
+	s := &ApprovalServer{
+		Client:             fake.NewClientBuilder().WithObjects(pod).Build(),
+		Secret:             []byte("link-secret"),
+		Approvers:          map[string]bool{"alice@example.com": true, "U0ALICE": true},
+		IdentityHeader:     "X-Forwarded-Email",
+		SlackSigningSecret: []byte("slack-secret"),
+	}
+	actions := s.Actions(pod, time.Now())
+	return s, actions[0].Value
+}
+
+// decisionOf returns the decision recorded on finance/agent, and by whom
+func decisionOf(t *testing.T, c client.Client) (string, string) {
+	t.Helper()
+	var pod corev1.Pod
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: "finance", Name: "agent"}, &pod); err != nil {
+		t.Fatal(err)
+	}
+	return pod.Annotations[ApprovalAnnotation], pod.Annotations[ApprovedByAnnotation]
+}
+
+func TestServeLinkApprovers(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity string
+		code     int
+		decided  string
+	}{
+		{"missing identity", "", http.StatusForbidden, ""},
+		{"not an approver", "mallory@example.com", http.StatusForbidden, ""},
+		{"approver", "alice@example.com", http.StatusOK, Approved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, token := newApprovalServer(t)
+			req := httptest.NewRequest(http.MethodPost, "/approval", strings.NewReader(url.Values{"token": {token}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.identity != "" {
+				req.Header.Set("X-Forwarded-Email", tt.identity)
+			}
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.code {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			decided, by := decisionOf(t, s.Client)
+			if decided != tt.decided {
+				t.Errorf("decision = %q, want %q", decided, tt.decided)
+			}
+			if tt.decided != "" && by != tt.identity {
+				t.Errorf("decided by %q, want %q", by, tt.identity)
+			}
+		})
+	}
+}
+
+func TestServeSlackApprovers(t *testing.T) {
+	tests := []struct {
+		name     string
+		userID   string
+		username string
+		reply    string
+		decided  string
+	}{
+		{"not an approver", "U0MALLORY", "mallory", "mallory may not approve agents", ""},
+		{"approver by ID", "U0ALICE", "alice", "finance/agent approved by slack:alice.", Approved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, token := newApprovalServer(t)
+			replies := make(chan string, 1)
+			slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var msg struct{ Text string }
+				json.NewDecoder(r.Body).Decode(&msg)
+				replies <- msg.Text
+			}))
+			defer slack.Close()
+
+			var cb slackCallback
+			cb.User.ID, cb.User.Username = tt.userID, tt.username
+			cb.Actions = append(cb.Actions, struct {
+				ActionID string `json:"action_id"`
+				Value    string `json:"value"`
+			}{"semamesh_approved", token})
+			cb.ResponseURL = slack.URL
+			payload, _ := json.Marshal(cb)
+			body := url.Values{"payload": {string(payload)}}.Encode()
+
+			req := httptest.NewRequest(http.MethodPost, "/approval/slack", strings.NewReader(body))
+			for k, v := range slackHeaders("slack-secret", time.Now(), body) {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			select {
+			case reply := <-replies:
+				if reply != tt.reply {
+					t.Errorf("reply = %q, want %q", reply, tt.reply)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no reply on response_url")
+			}
+			if decided, _ := decisionOf(t, s.Client); decided != tt.decided {
+				t.Errorf("decision = %q, want %q", decided, tt.decided)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Pause/freeze lifecycle events
+const (
+	PauseRequested    = "requested"
+	PauseFrozen       = "frozen"
+	PauseFreezeFailed = "freeze_failed"
+	PauseAutoRejected = "auto_rejected"
+	PauseApproved     = "approved"
+	PauseRejected     = "rejected"
+)
+
+// PauseReportedAnnotation holds the last lifecycle event counted for the
+// pending pause, so the retries of a failing freeze aren't counted again
+const PauseReportedAnnotation = "semamesh.io/pause-reported"
+
+var pauseEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "semamesh_agent_pause_events_total",
+		Help: "Pause/freeze lifecycle of agents (requested, frozen, freeze_failed, approved, rejected, auto_rejected)",
+	},
+	[]string{"namespace", "event"},
+)
+
+func init() {
+	// Served by the manager on --metrics-bind-address
+	metrics.Registry.MustRegister(pauseEvents)
+}
+
+var quotaListGVK = schema.GroupVersionKind{Group: "semamesh.io", Version: "v1alpha1", Kind: "SemaTokenQuotaList"}
+
+var (
+	quotaConsumedDesc = prometheus.NewDesc("semamesh_quota_tokens_consumed",
+		"Tokens consumed in the current cycle of a SemaTokenQuota", []string{"namespace", "quota", "model_match"}, nil)
+	quotaLimitDesc = prometheus.NewDesc("semamesh_quota_tokens_limit",
+		"Hard limit of a SemaTokenQuota", []string{"namespace", "quota", "model_match"}, nil)
+	quotaRemainingDesc = prometheus.NewDesc("semamesh_quota_remaining_ratio",
+		"Share of a SemaTokenQuota left in the current cycle (0 when exhausted)", []string{"namespace", "quota", "model_match"}, nil)
+)
+
+// +kubebuilder:rbac:groups=semamesh.io,resources=sematokenquotas,verbs=get;list;watch
+
+// QuotaCollector reports every SemaTokenQuota when scraped, so the gauges
+// never go stale when quotas are deleted
+type QuotaCollector struct {
+	Reader client.Reader
+}
+
+// NewQuotaCollector reads quotas with r; use the manager's API reader, the
+// cache doesn't hold them
+func NewQuotaCollector(r client.Reader) *QuotaCollector {
+	return &QuotaCollector{Reader: r}
+}
+
+func (c *QuotaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- quotaConsumedDesc
+	ch <- quotaLimitDesc
+	ch <- quotaRemainingDesc
+}
+
+func (c *QuotaCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Unstructured: the quota fields are read by path below
+	var list unstructured.UnstructuredList
+	list.SetGroupVersionKind(quotaListGVK)
+	if err := c.Reader.List(ctx, &list); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list SemaTokenQuotas for metrics")
+		return
+	}
+
+	for _, q := range list.Items {
+		// Older CRD manifests name the fields budget/used
+		limit := int64Field(q.Object, []string{"spec", "hardLimit"}, []string{"spec", "budget"})
+		consumed := int64Field(q.Object, []string{"status", "tokensConsumed"}, []string{"status", "used"})
+		modelMatch, _, _ := unstructured.NestedString(q.Object, "spec", "modelMatch")
+		if modelMatch == "" {
+			modelMatch = "*"
+		}
+		labels := []string{q.GetNamespace(), q.GetName(), modelMatch}
+
+		ch <- prometheus.MustNewConstMetric(quotaConsumedDesc, prometheus.GaugeValue, float64(consumed), labels...)
+		ch <- prometheus.MustNewConstMetric(quotaLimitDesc, prometheus.GaugeValue, float64(limit), labels...)
+		if limit > 0 {
+			remaining := float64(limit-consumed) / float64(limit)
+			if remaining < 0 {
+				remaining = 0
+			}
+			ch <- prometheus.MustNewConstMetric(quotaRemainingDesc, prometheus.GaugeValue, remaining, labels...)
+		}
+	}
+}
+
+// int64Field returns the first of paths set in obj
+func int64Field(obj map[string]interface{}, paths ...[]string) int64 {
+	for _, path := range paths {
+		if v, ok, _ := unstructured.NestedInt64(obj, path...); ok {
+			return v
+		}
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/semamesh/semamesh/pkg/notify"
+)
+
+// notifyPause tells on-call about a step of a pod's pause lifecycle
+func notifyPause(pod *corev1.Pod, event string, severity notify.Severity, text string, actions ...notify.Action) {
+	notify.Send(notify.Notification{
+		Severity:  severity,
+		Source:    "pause",
+		Rule:      event,
+		Namespace: pod.Namespace,
+		Title:     "SemaMesh agent " + pod.Namespace + "/" + pod.Name + ": " + event,
+		Text:      text,
+		Fields: map[string]string{
+			"Pod":  pod.Namespace + "/" + pod.Name,
+			"Node": pod.Spec.NodeName,
+		},
+		Actions: actions,
+	})
+}
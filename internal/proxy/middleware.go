@@ -2,12 +2,20 @@ package proxy
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/semamesh/semamesh/pkg/audit"
+	"github.com/semamesh/semamesh/pkg/metrics"
+	"github.com/semamesh/semamesh/pkg/sniffer"
+	"github.com/semamesh/semamesh/pkg/tracing"
 )
 
 // IntentMiddleware intercepts requests to check for policy violations
@@ -27,15 +35,26 @@ func IntentMiddleware(next http.Handler) http.Handler {
 		// Normalize text for analysis
 		bodyStr := strings.ToLower(string(bodyBytes))
 
+		_, span := tracing.Tracer().Start(r.Context(), "semamesh.policy")
+		// The waypoint has no workload identity or provider of its own
+		model, _ := sniffer.ParseRequest(bodyBytes)
+
 		// --- 2. LOGIC: Intent Detection ---
 		// If the prompt contains "delete", we trigger a policy violation
-		if strings.Contains(bodyStr, "delete") {
-			log.Println("INTENT_ANALYSIS: Destructive intent detected! Triggering PAUSE.")
+		if rule := evaluate(&builtinPolicy, bodyStr); rule != nil && rule.Action != "ALLOW" {
+			log.Printf("INTENT_ANALYSIS: Destructive intent detected (rule %s, risk %s)! Triggering %s.", rule.Name, rule.RiskLevel, rule.Action)
 
 			// Create a file to simulate the stateful pause (for the smoke test to see)
 			// In a real scenario, this would call the Controller API
 			_ = os.WriteFile("/tmp/semamesh-violation", []byte("violation"), 0644)
 
+			NotifyViolation("", clientHost(r), builtinPolicy.Name, rule)
+
+			span.SetAttributes(tracing.DecisionKey.String(rule.Action))
+			metrics.Errors.WithLabelValues("unknown", model, "unknown", metrics.ReasonPolicyBlock).Inc()
+			span.End()
+			auditDecision(r, model, bodyBytes, rule.Action, "policy "+builtinPolicy.Name+", rule "+rule.Name+" (risk "+rule.RiskLevel+")")
+
 			http.Error(w, "SemaMesh Policy Violation: Agent Paused", http.StatusForbidden)
 			return
 		}
@@ -55,14 +74,43 @@ func IntentMiddleware(next http.Handler) http.Handler {
 		// Check Quota
 		if tokenCount > limit {
 			log.Printf("QUOTA_VIOLATION: Request size %d exceeds limit %d", tokenCount, limit)
+			span.SetAttributes(tracing.DecisionKey.String("QUOTA_EXCEEDED"))
+			metrics.Errors.WithLabelValues("unknown", model, "unknown", metrics.ReasonQuotaBlock).Inc()
+			span.End()
+			auditDecision(r, model, bodyBytes, "QUOTA_EXCEEDED", fmt.Sprintf("request size %d exceeds limit %d", tokenCount, limit))
 			http.Error(w, "SemaMesh: Token Quota Exceeded", http.StatusTooManyRequests)
 			return
 		}
 
 		// Log success for the smoke test to grep
 		log.Printf("INTENT_ANALYSIS: Safe. Tokens: %d", tokenCount)
+		span.SetAttributes(tracing.DecisionKey.String("ALLOW"))
+		span.End()
 
 		// 4. Pass request to the actual LLM
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+// auditDecision records a request the waypoint stopped, so blocked requests
+// can be found with "semamesh audit query --decision". The waypoint doesn't
+// know which pod called: only the client address is recorded.
+func auditDecision(r *http.Request, model string, body []byte, decision, reason string) {
+	audit.Submit(audit.LogEntry{
+		Timestamp:     time.Now(),
+		Namespace:     "unknown",
+		ClientAddress: clientHost(r),
+		Model:         model,
+		PromptText:    sniffer.Summarize(sniffer.ProviderOpenAI, nil, body).Prompt,
+		Decision:      decision,
+		Reason:        reason,
+	})
+}
+
+// clientHost is the IP of the caller, without the ephemeral port
+func clientHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
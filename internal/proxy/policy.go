@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"strings"
+
+	semav1alpha1 "github.com/semamesh/semamesh/api/v1alpha1"
+	"github.com/semamesh/semamesh/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// builtinPolicy is what IntentMiddleware enforces
+var builtinPolicy = semav1alpha1.SemaPolicy{
+	ObjectMeta: metav1.ObjectMeta{Name: "builtin"},
+	Spec: semav1alpha1.SemaPolicySpec{
+		Rules: []semav1alpha1.PolicyRule{
+			{
+				Name:          "destructive-intent",
+				IntentMatches: []string{"delete"},
+				RiskLevel:     "High",
+				Action:        "PAUSE",
+			},
+		},
+	},
+}
+
+// evaluate returns the first rule of policy matching the normalized prompt,
+// or nil, and records the decision
+func evaluate(policy *semav1alpha1.SemaPolicy, prompt string) *semav1alpha1.PolicyRule {
+	metrics.PolicyEvaluations.WithLabelValues(policy.Name).Inc()
+
+	for i := range policy.Spec.Rules {
+		rule := &policy.Spec.Rules[i]
+		for _, match := range rule.IntentMatches {
+			if strings.Contains(prompt, strings.ToLower(match)) {
+				metrics.PolicyDecisions.WithLabelValues(policy.Name, rule.Name, rule.RiskLevel, rule.Action).Inc()
+				return rule
+			}
+		}
+	}
+	metrics.PolicyDecisions.WithLabelValues(policy.Name, "none", "None", "ALLOW").Inc()
+	return nil
+}
@@ -1,34 +1,46 @@
 package proxy
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"net/http"
-	"os"
-	"time"
+
+	semav1alpha1 "github.com/semamesh/semamesh/api/v1alpha1"
+	"github.com/semamesh/semamesh/pkg/notify"
 )
 
-// NotifyViolation sends a structured alert to a Slack Webhook
-func NotifyViolation(agentName, reason string) {
-	webhookURL := os.Getenv("SLACK_WEBHOOK_URL")
-	if webhookURL == "" {
-		return // Silently skip if no webhook is configured
-	}
+// riskSeverity maps policy risk levels to notification severities
+var riskSeverity = map[string]notify.Severity{
+	"Low":      notify.SeverityInfo,
+	"Medium":   notify.SeverityWarning,
+	"High":     notify.SeverityCritical,
+	"Critical": notify.SeverityCritical,
+}
 
-	// Create a professional-looking Slack message
-	payload := map[string]interface{}{
-		"text": fmt.Sprintf("⚠️ *SemaMesh Security Alert*\n*Agent:* %s\n*Violation:* %s\n*Timestamp:* %s",
-			agentName, reason, time.Now().Format(time.RFC822)),
+// NotifyViolation sends a policy violation alert through the configured
+// notification channels (see pkg/notify). namespace may be empty when the
+// caller wasn't resolved. Repeats are deduplicated per agent: another agent
+// tripping the same rule is another alert.
+func NotifyViolation(namespace, agentName, policy string, rule *semav1alpha1.PolicyRule) {
+	n := notify.Notification{
+		Severity:  riskSeverity[rule.RiskLevel],
+		Source:    "policy",
+		Rule:      rule.Name,
+		Namespace: namespace,
+		Agent:     agentName,
+		Title:     "SemaMesh Security Alert",
+		Text:      fmt.Sprintf("Rule %s matched a request from %s: %s.", rule.Name, agentName, rule.Action),
+		Fields: map[string]string{
+			"Agent":      agentName,
+			"Policy":     policy,
+			"Risk Level": rule.RiskLevel,
+			"Action":     rule.Action,
+		},
 	}
-
-	jsonPayload, _ := json.Marshal(payload)
-
-	// Send it asynchronously so we don't slow down the proxy
-	go func() {
-		resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(jsonPayload))
-		if err != nil || resp.StatusCode != http.StatusOK {
-			fmt.Printf("[Error] Failed to send Slack alert: %v\n", err)
-		}
-	}()
-}
\ No newline at end of file
+	if namespace != "" {
+		n.Fields["Namespace"] = namespace
+	}
+	// A rule can name its own channel (PauseSettings.Notify)
+	if rule.PauseSettings != nil && rule.PauseSettings.Notify != "" {
+		n.Channels = []string{rule.PauseSettings.Notify}
+	}
+	notify.Send(n)
+}
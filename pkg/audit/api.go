@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryTokenEnv holds the audit query API token when no token file is given
+const QueryTokenEnv = "SEMAMESH_AUDIT_TOKEN"
+
+// LoadQueryToken reads the token of the audit query API from file, or else
+// from $SEMAMESH_AUDIT_TOKEN. It is "" when neither is set.
+func LoadQueryToken(file string) (string, error) {
+	if file == "" {
+		return strings.TrimSpace(os.Getenv(QueryTokenEnv)), nil
+	}
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read audit query token: %v", err)
+	}
+	token := strings.TrimSpace(string(raw))
+	if token == "" {
+		return "", fmt.Errorf("audit query token file %s is empty", file)
+	}
+	return token, nil
+}
+
+// QueryHandler serves GET /audit/query over the audit index to callers
+// presenting token as a bearer token: the entries hold prompts.
+//
+// Parameters: namespace, pod, model (glob), decision, since, until, limit, cursor.
+// "since" and "until" accept a duration relative to now ("24h") or RFC3339.
+func QueryHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		idx := CurrentIndex()
+		if idx == nil {
+			http.Error(w, "audit index not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		q, err := ParseQuery(r.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result, err := idx.Search(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// ParseQuery builds a Query from URL parameters
+func ParseQuery(values url.Values, now time.Time) (Query, error) {
+	q := Query{
+		Namespace: values.Get("namespace"),
+		Pod:       values.Get("pod"),
+		Model:     values.Get("model"),
+		Decision:  values.Get("decision"),
+	}
+
+	var err error
+	if q.Since, err = parseTimeBound(values.Get("since"), now); err != nil {
+		return q, fmt.Errorf("invalid since: %v", err)
+	}
+	if q.Until, err = parseTimeBound(values.Get("until"), now); err != nil {
+		return q, fmt.Errorf("invalid until: %v", err)
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			return q, fmt.Errorf("invalid limit: %v", err)
+		}
+	}
+	if v := values.Get("cursor"); v != "" {
+		if q.Cursor, err = strconv.Atoi(v); err != nil {
+			return q, fmt.Errorf("invalid cursor: %v", err)
+		}
+	}
+	return q, nil
+}
+
+// parseTimeBound accepts "24h" (meaning 24h ago) or an RFC3339 timestamp
+func parseTimeBound(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
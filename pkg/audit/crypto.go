@@ -0,0 +1,220 @@
+package audit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SealedField is an envelope-encrypted audit field.
+// Every field gets its own random data key (DEK). The DEK encrypts the text with
+// AES-GCM and is then wrapped by the key encryption key (KEK) named by KeyID.
+// Only holders of the KEK (the security team's Secret) can recover the text.
+type SealedField struct {
+	KeyID      string `json:"kid"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Keyring holds the key encryption keys, indexed by key ID.
+// New entries are always sealed with the active key; older keys are kept so
+// logs written before a rotation can still be decrypted.
+type Keyring struct {
+	keys     map[string][]byte
+	activeID string
+}
+
+// ErrNoKeys is returned by LoadKeyring for a directory without keys, e.g. the
+// empty mount of an optional Secret that was never created
+var ErrNoKeys = errors.New("no audit keys found")
+
+// LoadKeyring reads KEKs from a directory, typically a mounted Kubernetes Secret.
+// Each file is one key: the file name is the key ID and the content is a
+// base64-encoded 32-byte AES key. If activeID is empty, the highest key ID
+// (in lexical order) is used, so "2026-01", "2026-07"... rotate naturally.
+func LoadKeyring(dir, activeID string) (*Keyring, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit key directory: %v", err)
+	}
+
+	kr := &Keyring{keys: make(map[string][]byte)}
+	var ids []string
+	for _, e := range entries {
+		// Secret volumes contain "..data" symlinks and hidden timestamp dirs
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		raw, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit key %q: %v", e.Name(), err)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+		if err != nil {
+			return nil, fmt.Errorf("audit key %q is not valid base64: %v", e.Name(), err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("audit key %q must be 32 bytes (AES-256), got %d", e.Name(), len(key))
+		}
+
+		kr.keys[e.Name()] = key
+		ids = append(ids, e.Name())
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w in %s", ErrNoKeys, dir)
+	}
+
+	if activeID == "" {
+		sort.Strings(ids)
+		activeID = ids[len(ids)-1]
+	}
+	if _, ok := kr.keys[activeID]; !ok {
+		return nil, fmt.Errorf("active audit key %q not found in %s", activeID, dir)
+	}
+	kr.activeID = activeID
+
+	return kr, nil
+}
+
+// ActiveKeyID returns the key ID used for new entries
+func (k *Keyring) ActiveKeyID() string {
+	return k.activeID
+}
+
+// Seal encrypts plaintext with a fresh data key wrapped by the active KEK.
+// The field name is bound as additional data so sealed values can't be swapped
+// between fields (e.g. a completion presented as a prompt).
+func (k *Keyring) Seal(field, plaintext string) (*SealedField, error) {
+	// 1. Generate a one-time data key
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+
+	// 2. Encrypt the text with the data key
+	nonce, ciphertext, err := gcmSeal(dek, []byte(plaintext), []byte(field))
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. Wrap the data key with the KEK (nonce is prepended to the wrapped key)
+	wrapNonce, wrapped, err := gcmSeal(k.keys[k.activeID], dek, []byte(k.activeID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &SealedField{
+		KeyID:      k.activeID,
+		WrappedKey: append(wrapNonce, wrapped...),
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// Open reverses Seal. It fails if the KEK is unknown or the data was tampered with.
+func (k *Keyring) Open(field string, sf *SealedField) (string, error) {
+	kek, ok := k.keys[sf.KeyID]
+	if !ok {
+		return "", fmt.Errorf("audit key %q not available", sf.KeyID)
+	}
+
+	// 1. Unwrap the data key
+	dek, err := gcmOpen(kek, sf.WrappedKey, []byte(sf.KeyID))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+
+	// 2. Decrypt the text
+	plaintext, err := gcmOpen(dek, append(append([]byte{}, sf.Nonce...), sf.Ciphertext...), []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %v", field, err)
+	}
+
+	return string(plaintext), nil
+}
+
+// SealEntry moves the sensitive text of an entry into sealed fields.
+// Metadata (namespace, model, tokens, cost...) is left readable for searching.
+func (k *Keyring) SealEntry(entry LogEntry) (LogEntry, error) {
+	prompt, err := k.Seal("prompt_text", entry.PromptText)
+	if err != nil {
+		return entry, err
+	}
+	completion, err := k.Seal("completion_text", entry.CompletionText)
+	if err != nil {
+		return entry, err
+	}
+
+	entry.PromptText = ""
+	entry.CompletionText = ""
+	entry.PromptSealed = prompt
+	entry.CompletionSealed = completion
+	return entry, nil
+}
+
+// OpenEntry restores the plaintext of an entry written with SealEntry.
+// Entries that were never sealed are returned unchanged.
+func (k *Keyring) OpenEntry(entry LogEntry) (LogEntry, error) {
+	if entry.PromptSealed != nil {
+		text, err := k.Open("prompt_text", entry.PromptSealed)
+		if err != nil {
+			return entry, err
+		}
+		entry.PromptText = text
+		entry.PromptSealed = nil
+	}
+	if entry.CompletionSealed != nil {
+		text, err := k.Open("completion_text", entry.CompletionSealed)
+		if err != nil {
+			return entry, err
+		}
+		entry.CompletionText = text
+		entry.CompletionSealed = nil
+	}
+	return entry, nil
+}
+
+func gcmSeal(key, plaintext, additionalData []byte) (nonce, ciphertext []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, additionalData), nil
+}
+
+// gcmOpen expects the nonce to be prepended to the ciphertext
+func gcmOpen(key, data, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
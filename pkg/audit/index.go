@@ -0,0 +1,316 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultIndexEntries is how many of the latest entries are searchable
+const DefaultIndexEntries = 500000
+
+// Index is an in-memory secondary index over the latest entries of the
+// append-only audit log. The log file stays the source of truth: the index
+// only remembers where each entry lives (byte offset) plus the fields we
+// filter on, and is rebuilt from the file on startup.
+type Index struct {
+	mu   sync.RWMutex
+	file *os.File
+	max  int
+
+	// Positions are counted from the first entry of the file, so cursors
+	// stay valid as the oldest records are dropped. base is the position of
+	// records[0].
+	base    int
+	records []record // In file order
+	// skew is the furthest an entry was written behind a newer one: entries
+	// are analyzed concurrently, so file order is only roughly time order
+	skew time.Duration
+
+	// Secondary indexes: field value -> positions (ascending)
+	byNamespace map[string][]int
+	byPod       map[string][]int
+	byModel     map[string][]int
+	byDecision  map[string][]int
+}
+
+type record struct {
+	offset    int64
+	length    int
+	timestamp time.Time
+	// latest is the newest timestamp up to this record, which unlike
+	// timestamp never decreases along the file
+	latest time.Time
+}
+
+// Query filters audit entries. Empty fields match everything.
+type Query struct {
+	Namespace string
+	Pod       string
+	Model     string // Glob pattern, e.g. "gpt-4*"
+	Decision  string
+	Since     time.Time
+	Until     time.Time
+
+	// Limit caps the page size; Cursor continues a previous page
+	Limit  int
+	Cursor int
+}
+
+// QueryResult is one page of matching entries, newest first
+type QueryResult struct {
+	Entries    []LogEntry `json:"entries"`
+	NextCursor int        `json:"next_cursor,omitempty"`
+}
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// OpenIndex scans the audit log at path and indexes its latest max entries
+// (DefaultIndexEntries if max <= 0)
+func OpenIndex(filePath string, max int) (*Index, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log for indexing: %v", err)
+	}
+
+	if max <= 0 {
+		max = DefaultIndexEntries
+	}
+	idx := &Index{
+		file:        file,
+		max:         max,
+		byNamespace: make(map[string][]int),
+		byPod:       make(map[string][]int),
+		byModel:     make(map[string][]int),
+		byDecision:  make(map[string][]int),
+	}
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var entry LogEntry
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr == nil {
+				idx.add(offset, len(line), entry)
+			}
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read audit log: %v", err)
+		}
+	}
+
+	return idx, nil
+}
+
+// Len returns the number of indexed entries
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.records)
+}
+
+// add indexes an entry that was written at offset (length includes the newline)
+func (idx *Index) add(offset int64, length int, entry LogEntry) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	rec := record{offset: offset, length: length, timestamp: entry.Timestamp, latest: entry.Timestamp}
+	if n := len(idx.records); n > 0 && idx.records[n-1].latest.After(rec.latest) {
+		rec.latest = idx.records[n-1].latest
+		if behind := rec.latest.Sub(rec.timestamp); behind > idx.skew {
+			idx.skew = behind
+		}
+	}
+	pos := idx.base + len(idx.records)
+	idx.records = append(idx.records, rec)
+
+	idx.byNamespace[entry.Namespace] = append(idx.byNamespace[entry.Namespace], pos)
+	idx.byPod[entry.PodName] = append(idx.byPod[entry.PodName], pos)
+	idx.byModel[entry.Model] = append(idx.byModel[entry.Model], pos)
+	idx.byDecision[entry.Decision] = append(idx.byDecision[entry.Decision], pos)
+
+	if len(idx.records) > idx.max {
+		idx.trim()
+	}
+}
+
+// trim drops the oldest tenth of the records, so the cost of rebuilding the
+// secondary indexes is paid once per many entries. Caller holds the lock.
+func (idx *Index) trim() {
+	drop := len(idx.records) - idx.max + idx.max/10
+	idx.records = append([]record(nil), idx.records[drop:]...)
+	idx.base += drop
+
+	for _, byField := range []map[string][]int{idx.byNamespace, idx.byPod, idx.byModel, idx.byDecision} {
+		for value, positions := range byField {
+			i := sort.SearchInts(positions, idx.base)
+			if i == len(positions) {
+				delete(byField, value)
+				continue
+			}
+			byField[value] = append([]int(nil), positions[i:]...)
+		}
+	}
+}
+
+// Search returns one page of entries matching q, latest written first.
+// Pass QueryResult.NextCursor back as Query.Cursor to get the next page.
+func (idx *Index) Search(q Query) (QueryResult, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultQueryLimit
+	}
+	if limit > maxQueryLimit {
+		limit = maxQueryLimit
+	}
+
+	idx.mu.RLock()
+	positions := idx.match(q, limit+1)
+	var page []record
+	next := 0
+	for _, pos := range positions {
+		if len(page) == limit {
+			next = pos + 1
+			break
+		}
+		page = append(page, idx.records[pos-idx.base])
+	}
+	idx.mu.RUnlock()
+
+	result := QueryResult{Entries: make([]LogEntry, 0, len(page)), NextCursor: next}
+	for _, rec := range page {
+		entry, err := idx.read(rec)
+		if err != nil {
+			return result, err
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	return result, nil
+}
+
+// match returns up to max matching positions in descending order (latest
+// written first). Caller must hold the read lock.
+func (idx *Index) match(q Query, max int) []int {
+	// 1. Narrow down by time. Records before lo are older than since, and
+	// records from hi on are newer than until, even when written out of order.
+	lo := 0
+	if !q.Since.IsZero() {
+		lo = sort.Search(len(idx.records), func(i int) bool {
+			return !idx.records[i].latest.Before(q.Since)
+		})
+	}
+	hi := len(idx.records)
+	if !q.Until.IsZero() {
+		hi = sort.Search(len(idx.records), func(i int) bool {
+			return idx.records[i].latest.Add(-idx.skew).After(q.Until)
+		})
+	}
+	lo, hi = lo+idx.base, hi+idx.base
+	// The cursor is an exclusive upper bound for the next page
+	if q.Cursor > 0 && q.Cursor < hi {
+		hi = q.Cursor
+	}
+	if lo >= hi {
+		return nil
+	}
+	inRange := func(pos int) bool {
+		ts := idx.records[pos-idx.base].timestamp
+		return !ts.Before(q.Since) && (q.Until.IsZero() || !ts.After(q.Until))
+	}
+
+	// 2. Intersect the secondary indexes for every field that is set
+	var sets [][]int
+	if q.Namespace != "" {
+		sets = append(sets, idx.byNamespace[q.Namespace])
+	}
+	if q.Pod != "" {
+		sets = append(sets, idx.byPod[q.Pod])
+	}
+	if q.Decision != "" {
+		sets = append(sets, idx.byDecision[q.Decision])
+	}
+	if q.Model != "" {
+		sets = append(sets, idx.modelPositions(q.Model))
+	}
+
+	var result []int
+	if len(sets) == 0 {
+		for pos := hi - 1; pos >= lo && len(result) < max; pos-- {
+			if inRange(pos) {
+				result = append(result, pos)
+			}
+		}
+		return result
+	}
+
+	// Walk the smallest set and probe the others
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	smallest := sets[0]
+	for i := len(smallest) - 1; i >= 0 && len(result) < max; i-- {
+		pos := smallest[i]
+		if pos >= hi {
+			continue
+		}
+		if pos < lo {
+			break
+		}
+		if inRange(pos) && containsAll(sets[1:], pos) {
+			result = append(result, pos)
+		}
+	}
+	return result
+}
+
+// modelPositions merges the positions of every model matching the glob
+func (idx *Index) modelPositions(pattern string) []int {
+	if positions, ok := idx.byModel[pattern]; ok {
+		return positions
+	}
+
+	var merged []int
+	for model, positions := range idx.byModel {
+		if ok, _ := path.Match(pattern, model); ok {
+			merged = append(merged, positions...)
+		}
+	}
+	sort.Ints(merged)
+	return merged
+}
+
+func containsAll(sets [][]int, pos int) bool {
+	for _, set := range sets {
+		i := sort.SearchInts(set, pos)
+		if i == len(set) || set[i] != pos {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *Index) read(rec record) (LogEntry, error) {
+	buf := make([]byte, rec.length)
+	if _, err := idx.file.ReadAt(buf, rec.offset); err != nil {
+		return LogEntry{}, fmt.Errorf("failed to read audit entry at %d: %v", rec.offset, err)
+	}
+
+	var entry LogEntry
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		return LogEntry{}, fmt.Errorf("corrupt audit entry at %d: %v", rec.offset, err)
+	}
+	return entry, nil
+}
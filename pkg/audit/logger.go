@@ -2,6 +2,7 @@ package audit
 
 import (
 	"encoding/json"
+	"io"
 	"log"
 	"os"
 	"time"
@@ -10,15 +11,64 @@ import (
 // Global channel to receive logs
 var logChannel chan LogEntry
 
+// auditIndex makes the written entries searchable (nil until Init)
+var auditIndex *Index
+
+// keyring seals prompt/completion text before it hits disk (nil = plaintext)
+var keyring *Keyring
+
+// indexEntries caps the audit index (see LimitIndex)
+var indexEntries = DefaultIndexEntries
+
 // LogEntry defines the structure of our audit JSON
 type LogEntry struct {
-	Timestamp      time.Time `json:"timestamp"`
-	Namespace      string    `json:"namespace"`
-	Model          string    `json:"model"`
-	PromptText     string    `json:"prompt_text"`
-	CompletionText string    `json:"completion_text"`
-	TotalTokens    int       `json:"total_tokens"`
-	CostEst        float64   `json:"cost_usd"`
+	Timestamp time.Time `json:"timestamp"`
+	Namespace string    `json:"namespace"`
+	PodName   string    `json:"pod,omitempty"`
+	Workload  string    `json:"workload,omitempty"`
+	Node      string    `json:"node,omitempty"`
+	// ClientAddress is the caller's IP, for callers not resolved to a pod
+	ClientAddress string `json:"client_address,omitempty"`
+
+	// IdentitySource tells how the caller was identified (ip, spiffe, token)
+	IdentitySource string `json:"identity_source,omitempty"`
+
+	// Labels are the promoted pod labels (e.g. team, cost_center)
+	Labels map[string]string `json:"labels,omitempty"`
+
+	Model string `json:"model"`
+	// RequestedModel is the model the client asked for, when a routing rule
+	// sent the request to Model instead
+	RequestedModel string `json:"requested_model,omitempty"`
+	// Route is the routing rule applied
+	Route string `json:"route,omitempty"`
+
+	PromptText     string  `json:"prompt_text,omitempty"`
+	CompletionText string  `json:"completion_text,omitempty"`
+	TotalTokens    int     `json:"total_tokens"`
+	CostEst        float64 `json:"cost_usd"`
+
+	// Decision records what SemaMesh did with the request (ALLOW, DENY, PAUSE...)
+	Decision string `json:"decision,omitempty"`
+	// Reason explains the decision when it isn't obvious (e.g. BYPASS)
+	Reason string `json:"reason,omitempty"`
+
+	// Sealed variants of the text fields, set when encryption is enabled
+	PromptSealed     *SealedField `json:"prompt_sealed,omitempty"`
+	CompletionSealed *SealedField `json:"completion_sealed,omitempty"`
+}
+
+// EnableEncryption turns on envelope encryption of prompt and completion text.
+// Must be called before Init.
+func EnableEncryption(kr *Keyring) {
+	keyring = kr
+	log.Printf("🔐 Audit encryption enabled (active key: %s)", kr.ActiveKeyID())
+}
+
+// LimitIndex sets how many of the latest entries are searchable. Older
+// entries stay in the log file. Must be called before Init.
+func LimitIndex(max int) {
+	indexEntries = max
 }
 
 // Init sets up the file writer in a background goroutine
@@ -32,7 +82,8 @@ func Init() {
 		// If we can't write to /var/log, fall back to stdout or local dir
 		log.Printf("❌ CRITICAL: Failed to open audit log at %s: %v", filePath, err)
 		log.Println("⚠️ Falling back to './audit.log'")
-		file, err = os.OpenFile("audit.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		filePath = "audit.log"
+		file, err = os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			log.Fatalf("❌ FATAL: Could not create audit log anywhere: %v", err)
 		}
@@ -40,20 +91,56 @@ func Init() {
 		log.Printf("✅ Audit Logger active. Writing to: %s", filePath)
 	}
 
+	// 3. Index what is already on disk so it can be queried
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		log.Fatalf("❌ FATAL: Could not seek audit log: %v", err)
+	}
+	if auditIndex, err = OpenIndex(filePath, indexEntries); err != nil {
+		log.Printf("⚠️ Audit search disabled: %v", err)
+	} else {
+		log.Printf("🔎 Audit index loaded (%d entries)", auditIndex.Len())
+	}
+
 	logChannel = make(chan LogEntry, 100)
 
-	// 3. Start the worker
+	// 4. Start the worker
 	go func() {
 		defer file.Close()
-		encoder := json.NewEncoder(file)
 		for entry := range logChannel {
-			if err := encoder.Encode(entry); err != nil {
+			if keyring != nil {
+				sealed, err := keyring.SealEntry(entry)
+				if err != nil {
+					// Never fall back to plaintext: drop the entry instead
+					log.Printf("❌ Error sealing audit entry, dropping it: %v", err)
+					continue
+				}
+				entry = sealed
+			}
+			line, err := json.Marshal(entry)
+			if err != nil {
+				log.Printf("❌ Error encoding audit entry: %v", err)
+				continue
+			}
+			line = append(line, '\n')
+			if _, err := file.Write(line); err != nil {
 				log.Printf("❌ Error writing audit entry: %v", err)
+				continue
+			}
+
+			if auditIndex != nil {
+				auditIndex.add(offset, len(line), entry)
 			}
+			offset += int64(len(line))
 		}
 	}()
 }
 
+// CurrentIndex returns the index over the audit log, or nil if unavailable
+func CurrentIndex() *Index {
+	return auditIndex
+}
+
 // Submit sends a log entry to the worker (Non-blocking)
 func Submit(entry LogEntry) {
 	select {
@@ -68,4 +155,4 @@ func Submit(entry LogEntry) {
 func LogAccess(namespace string, req []byte, resp []byte, tokens int) {
 	// This is a legacy helper if you still use it in older code.
 	// We prefer using 'Submit' directly with structured data.
-}
\ No newline at end of file
+}
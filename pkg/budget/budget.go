@@ -0,0 +1,472 @@
+// Package budget accounts the estimated cost of LLM traffic against
+// SemaCostBudgets: threshold and forecast alerts, and optional hard stops
+package budget
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/semamesh/SemaMesh/api/v1alpha1"
+	"github.com/semamesh/SemaMesh/pkg/identity"
+	"github.com/semamesh/SemaMesh/pkg/metrics"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	PeriodDaily   = "Daily"
+	PeriodWeekly  = "Weekly"
+	PeriodMonthly = "Monthly"
+
+	PhaseActive    = "Active"
+	PhaseWarning   = "Warning"
+	PhaseExhausted = "Exhausted"
+
+	// TeamLabel is the promoted label Spec.Team is matched against
+	TeamLabel = "team"
+
+	// DefaultSyncInterval is how often spend is published and totals refreshed
+	DefaultSyncInterval = 30 * time.Second
+)
+
+// DefaultThresholds apply to budgets that don't list any
+var DefaultThresholds = []int{50, 80, 100}
+
+// Resource is the SemaCostBudget API resource
+var Resource = schema.GroupVersionResource{Group: "semamesh.io", Version: "v1alpha1", Resource: "semacostbudgets"}
+
+// Alert is sent when a budget crosses one of its thresholds, or is forecast
+// to overspend
+type Alert struct {
+	Namespace string
+	Budget    string
+	Team      string
+	// Threshold crossed, in percent of Limit. 0 for a forecast alert.
+	Threshold int
+	Spent     float64
+	Limit     float64
+	Forecast  float64
+	Currency  string
+	PeriodEnd time.Time
+	HardStop  bool
+}
+
+// Tracker accounts the spend seen on this node against the SemaCostBudgets.
+// Nodes publish their share in the budget status and add up everyone's, so
+// alerts and hard stops follow the spend of the whole cluster, as of the
+// last sync.
+type Tracker struct {
+	// Notify is called once per budget, threshold and period, by whichever
+	// node claims the alert first
+	Notify func(Alert)
+	// Interval between syncs (DefaultSyncInterval if zero)
+	Interval time.Duration
+
+	client  dynamic.NamespaceableResourceInterface
+	lister  cache.GenericLister
+	synced  cache.InformerSynced
+	factory dynamicinformer.DynamicSharedInformerFactory
+	node    string
+
+	mu      sync.RWMutex
+	budgets map[types.UID]*tracked
+}
+
+// tracked is a budget and this node's view of its spend
+type tracked struct {
+	namespace string
+	name      string
+	spec      v1alpha1.SemaCostBudgetSpec
+	limit     float64
+
+	period    time.Time // start of the current period
+	local     float64   // spent through this node in the period
+	others    float64   // spent through other nodes, as of the last sync
+	published string    // local, as last written to the status
+	recovered bool      // local was restored from the status
+}
+
+// NewTracker watches the SemaCostBudgets of all namespaces. nodeName keys
+// this agent's share of the spend.
+func NewTracker(client dynamic.Interface, nodeName string) (*Tracker, error) {
+	if nodeName == "" {
+		return nil, fmt.Errorf("node name is required")
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 10*time.Minute)
+	informer := factory.ForResource(Resource)
+	return &Tracker{
+		client:  client.Resource(Resource),
+		lister:  informer.Lister(),
+		synced:  informer.Informer().HasSynced,
+		factory: factory,
+		node:    nodeName,
+		budgets: make(map[types.UID]*tracked),
+	}, nil
+}
+
+// Run syncs with the cluster every Interval, until stopCh closes
+func (t *Tracker) Run(stopCh <-chan struct{}) error {
+	t.factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, t.synced) {
+		return fmt.Errorf("failed to sync SemaCostBudget cache")
+	}
+
+	interval := t.Interval
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	t.sync()
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case <-ticker.C:
+			t.sync()
+		}
+	}
+}
+
+// Record charges cost to the budgets covering the caller
+func (t *Tracker) Record(meta identity.PodMetadata, cost float64) {
+	if cost <= 0 {
+		return
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range t.budgets {
+		if b.matches(meta) {
+			b.rollover(now)
+			b.local += cost
+		}
+	}
+}
+
+// Blocked reports the first hard-stop budget covering the caller that is
+// spent for the current period
+func (t *Tracker) Blocked(meta identity.PodMetadata) (string, bool) {
+	now := time.Now()
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, b := range t.budgets {
+		if !b.spec.HardStop || !b.matches(meta) {
+			continue
+		}
+		// Nothing is spent yet in a period that started since the last sync
+		if b.period.Equal(periodStart(now, b.spec.Period)) && b.local+b.others >= b.limit {
+			return b.name, true
+		}
+	}
+	return "", false
+}
+
+// Used returns the largest share of its limit (1 for 100%) a budget covering
+// the caller has spent in the current period, as of the last sync
+func (t *Tracker) Used(meta identity.PodMetadata) float64 {
+	now := time.Now()
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var used float64
+	for _, b := range t.budgets {
+		if !b.matches(meta) || b.limit <= 0 || !b.period.Equal(periodStart(now, b.spec.Period)) {
+			continue
+		}
+		if share := (b.local + b.others) / b.limit; share > used {
+			used = share
+		}
+	}
+	return used
+}
+
+func (b *tracked) matches(meta identity.PodMetadata) bool {
+	return meta.Namespace == b.namespace && (b.spec.Team == "" || meta.Promoted[TeamLabel] == b.spec.Team)
+}
+
+// rollover starts a new period when the current one is over
+func (b *tracked) rollover(now time.Time) {
+	if start := periodStart(now, b.spec.Period); !start.Equal(b.period) {
+		b.period = start
+		b.local, b.others = 0, 0
+		b.published = ""
+	}
+}
+
+// sync publishes this node's spend, refreshes the totals and sends the
+// alerts that are due
+func (t *Tracker) sync() {
+	objs, err := t.lister.List(labels.Everything())
+	if err != nil {
+		log.Printf("BUDGET: failed to list SemaCostBudgets: %v", err)
+		return
+	}
+
+	now := time.Now()
+	seen := make(map[types.UID]bool, len(objs))
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		var cb v1alpha1.SemaCostBudget
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &cb); err != nil {
+			log.Printf("BUDGET: skipping %s/%s: %v", u.GetNamespace(), u.GetName(), err)
+			continue
+		}
+		seen[cb.UID] = true
+		t.syncBudget(&cb, now)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for uid, b := range t.budgets {
+		if !seen[uid] {
+			delete(t.budgets, uid)
+			metrics.BudgetSpent.DeleteLabelValues(b.namespace, b.name)
+			metrics.BudgetLimit.DeleteLabelValues(b.namespace, b.name)
+			metrics.BudgetForecast.DeleteLabelValues(b.namespace, b.name)
+		}
+	}
+}
+
+func (t *Tracker) syncBudget(cb *v1alpha1.SemaCostBudget, now time.Time) {
+	limit, err := strconv.ParseFloat(cb.Spec.Limit, 64)
+	if err != nil || limit <= 0 {
+		log.Printf("BUDGET: skipping %s/%s: invalid limit %q", cb.Namespace, cb.Name, cb.Spec.Limit)
+		return
+	}
+
+	// 1. Merge our share with the other nodes'
+	t.mu.Lock()
+	b, ok := t.budgets[cb.UID]
+	if !ok {
+		b = &tracked{namespace: cb.Namespace, name: cb.Name}
+		t.budgets[cb.UID] = b
+	}
+	b.spec, b.limit = cb.Spec, limit
+	b.rollover(now)
+	period := metav1.NewTime(b.period)
+
+	// A restarted agent picks up where it left off
+	if !b.recovered {
+		b.recovered = true
+		if mine, ok := cb.Status.Nodes[t.node]; ok && mine.PeriodStart.Equal(&period) {
+			b.local += parseAmount(mine.Spent)
+			b.published = mine.Spent
+		}
+	}
+	b.others = 0
+	for node, s := range cb.Status.Nodes {
+		if node != t.node && s.PeriodStart.Equal(&period) {
+			b.others += parseAmount(s.Spent)
+		}
+	}
+	local := formatAmount(b.local)
+	spent := b.local + b.others
+	changed := local != b.published
+	t.mu.Unlock()
+
+	// 2. Forecast the end of the period at the current burn rate
+	end := periodEnd(period.Time, cb.Spec.Period)
+	length, elapsed := end.Sub(period.Time), now.Sub(period.Time)
+	forecast := spent
+	if elapsed > 0 {
+		forecast = spent / elapsed.Seconds() * length.Seconds()
+	}
+
+	thresholds := cb.Spec.Thresholds
+	if len(thresholds) == 0 {
+		thresholds = DefaultThresholds
+	}
+	var crossed []int
+	for _, th := range thresholds {
+		if spent*100 >= limit*float64(th) {
+			crossed = append(crossed, th)
+		}
+	}
+
+	phase := PhaseActive
+	switch {
+	case spent >= limit:
+		phase = PhaseExhausted
+	case len(crossed) > 0 || forecast > limit:
+		phase = PhaseWarning
+	}
+
+	metrics.BudgetSpent.WithLabelValues(cb.Namespace, cb.Name).Set(spent)
+	metrics.BudgetLimit.WithLabelValues(cb.Namespace, cb.Name).Set(limit)
+	metrics.BudgetForecast.WithLabelValues(cb.Namespace, cb.Name).Set(forecast)
+
+	// 3. Alerts not yet sent in this period. Early forecasts are mostly noise.
+	notified, forecastNotified := cb.Status.NotifiedThresholds, cb.Status.ForecastNotified
+	if !cb.Status.PeriodStart.Equal(&period) {
+		notified, forecastNotified = nil, false
+	}
+	var due []int
+	for _, th := range crossed {
+		if !contains(notified, th) {
+			due = append(due, th)
+		}
+	}
+	forecastDue := !forecastNotified && forecast > limit && spent < limit && elapsed >= length/10
+
+	status := &cb.Status
+	phaseChanged := status.Phase != phase
+	status.Spent, status.Forecast, status.Phase = formatAmount(spent), formatAmount(forecast), phase
+	mine := v1alpha1.NodeSpend{PeriodStart: period, Spent: local}
+
+	// 4. Claim the alerts with an update: only one node wins the race, and
+	// notifies. A new period resets the claims.
+	if len(due) > 0 || forecastDue || !status.PeriodStart.Equal(&period) {
+		status.PeriodStart = period
+		status.NotifiedThresholds = append(notified, due...)
+		status.ForecastNotified = forecastNotified || forecastDue
+		for node, s := range status.Nodes {
+			if !s.PeriodStart.Equal(&period) {
+				delete(status.Nodes, node)
+			}
+		}
+		if status.Nodes == nil {
+			status.Nodes = make(map[string]v1alpha1.NodeSpend)
+		}
+		status.Nodes[t.node] = mine
+
+		err := t.updateStatus(cb)
+		switch {
+		case err == nil:
+			t.markPublished(b, period.Time, local)
+			for _, th := range due {
+				t.notify(cb, Alert{Threshold: th, Spent: spent, Limit: limit, Forecast: forecast, PeriodEnd: end})
+			}
+			if forecastDue {
+				t.notify(cb, Alert{Spent: spent, Limit: limit, Forecast: forecast, PeriodEnd: end})
+			}
+			return
+		case apierrors.IsConflict(err):
+			// Another node claimed them, or our cache is behind: next sync tells
+		default:
+			log.Printf("BUDGET: failed to update %s/%s: %v", cb.Namespace, cb.Name, err)
+		}
+	}
+
+	// 5. Publish our share. Nodes only write their own entry, so merge
+	// patches don't conflict.
+	if !changed && !phaseChanged {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"spent":    status.Spent,
+			"forecast": status.Forecast,
+			"phase":    phase,
+			"nodes":    map[string]v1alpha1.NodeSpend{t.node: mine},
+		},
+	})
+	if err != nil {
+		return
+	}
+	if _, err := t.client.Namespace(cb.Namespace).Patch(context.Background(), cb.Name, types.MergePatchType, patch, metav1.PatchOptions{}, "status"); err != nil {
+		log.Printf("BUDGET: failed to publish spend of %s/%s: %v", cb.Namespace, cb.Name, err)
+		return
+	}
+	t.markPublished(b, period.Time, local)
+}
+
+func (t *Tracker) updateStatus(cb *v1alpha1.SemaCostBudget) error {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cb)
+	if err != nil {
+		return err
+	}
+	_, err = t.client.Namespace(cb.Namespace).UpdateStatus(context.Background(), &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	return err
+}
+
+// markPublished remembers what we wrote, unless a new period began meanwhile
+func (t *Tracker) markPublished(b *tracked, period time.Time, local string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if b.period.Equal(period) {
+		b.published = local
+	}
+}
+
+func (t *Tracker) notify(cb *v1alpha1.SemaCostBudget, alert Alert) {
+	alert.Namespace, alert.Budget, alert.Team = cb.Namespace, cb.Name, cb.Spec.Team
+	alert.Currency, alert.HardStop = cb.Spec.Currency, cb.Spec.HardStop
+	if alert.Currency == "" {
+		alert.Currency = "USD"
+	}
+
+	if alert.Threshold > 0 {
+		log.Printf("BUDGET_ALERT: %s/%s reached %d%% (%.2f of %.2f %s)", alert.Namespace, alert.Budget, alert.Threshold, alert.Spent, alert.Limit, alert.Currency)
+	} else {
+		log.Printf("BUDGET_ALERT: %s/%s forecast to overspend (%.2f of %.2f %s)", alert.Namespace, alert.Budget, alert.Forecast, alert.Limit, alert.Currency)
+	}
+	if t.Notify != nil {
+		t.Notify(alert)
+	}
+}
+
+// periodStart is the start of the period containing now, in UTC
+func periodStart(now time.Time, period string) time.Time {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case PeriodDaily:
+		return day
+	case PeriodWeekly:
+		// Weeks start on Monday
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	default:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func periodEnd(start time.Time, period string) time.Time {
+	switch period {
+	case PeriodDaily:
+		return start.AddDate(0, 0, 1)
+	case PeriodWeekly:
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// Amounts are kept as decimal strings in the API (no floats in CRDs)
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
+
+func parseAmount(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+func contains(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,352 @@
+// Package cache answers repeated LLM requests from earlier responses: an
+// exact-match cache keyed by namespace, model, normalized messages and
+// parameters, held in a per-namespace LRU with optional on-disk backing, and
+// a semantic tier for similar prompts
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/semamesh/SemaMesh/pkg/identity"
+	"github.com/semamesh/SemaMesh/pkg/metrics"
+)
+
+// Defaults of a namespace cache and of the cache files
+const (
+	DefaultTTL          = time.Hour
+	DefaultMaxEntries   = 1000
+	DefaultMaxSize      = 64 << 20
+	DefaultMaxEntrySize = 1 << 20
+	DefaultMaxDiskSize  = 1 << 30
+)
+
+// File is the format of the --cache-config YAML file:
+//
+//	default:              # namespaces not listed below (omit to cache only those)
+//	  ttl: 1h
+//	  maxEntries: 1000
+//	  maxSize: 64Mi
+//	namespaces:
+//	  retrieval:
+//	    ttl: 24h
+//	    maxSize: 512Mi
+//	  payments:
+//	    disabled: true
+//	dir: /var/cache/semamesh  # optional on-disk backing
+//	maxDiskSize: 2Gi
+//	semantic:                 # optional, for rules with semanticCache: true
+//	  embedder:
+//	    url: http://embeddings.ai.svc:8080/v1/embeddings
+//	    model: text-embedding-3-small
+//	    apiKey: ${EMBEDDINGS_API_KEY}
+//	  threshold: 0.95
+//
+// Settings a namespace leaves out come from default, then from the Default*
+// constants.
+type File struct {
+	Default    *NamespaceConfig           `json:"default,omitempty"`
+	Namespaces map[string]NamespaceConfig `json:"namespaces,omitempty"`
+
+	// MaxEntrySize is the largest response cached (default 1Mi)
+	MaxEntrySize *resource.Quantity `json:"maxEntrySize,omitempty"`
+
+	// Dir keeps responses on disk, so they outlive the in-memory LRU and
+	// restarts. Empty keeps them in memory only.
+	Dir string `json:"dir,omitempty"`
+	// MaxDiskSize caps Dir; the responses closest to expiry go first (default 1Gi)
+	MaxDiskSize *resource.Quantity `json:"maxDiskSize,omitempty"`
+
+	// Semantic enables the similar-prompt tier
+	Semantic *SemanticConfig `json:"semantic,omitempty"`
+}
+
+// NamespaceConfig is how a namespace's responses are cached
+type NamespaceConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// TTL of a response (default 1h)
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+	// MaxEntries and MaxSize cap the in-memory LRU (default 1000, 64Mi)
+	MaxEntries *int               `json:"maxEntries,omitempty"`
+	MaxSize    *resource.Quantity `json:"maxSize,omitempty"`
+}
+
+// policy is a resolved NamespaceConfig
+type policy struct {
+	ttl        time.Duration
+	maxEntries int
+	maxSize    int64
+}
+
+func (p policy) merge(cfg NamespaceConfig) policy {
+	if cfg.TTL != nil {
+		p.ttl = cfg.TTL.Duration
+	}
+	if cfg.MaxEntries != nil {
+		p.maxEntries = *cfg.MaxEntries
+	}
+	if cfg.MaxSize != nil {
+		p.maxSize = cfg.MaxSize.Value()
+	}
+	return p
+}
+
+// Entry is a cached response
+type Entry struct {
+	Namespace string      `json:"namespace"`
+	Model     string      `json:"model"`
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header"`
+	Body      []byte      `json:"body"`
+	// Cost is what the provider call was estimated to cost, i.e. what
+	// every hit saves
+	Cost    float64   `json:"cost"`
+	Stored  time.Time `json:"stored"`
+	Expires time.Time `json:"expires"`
+}
+
+func (e *Entry) size() int64 {
+	return int64(len(e.Body))
+}
+
+// Cache holds responses per namespace
+type Cache struct {
+	// Rules allow the semantic tier per prompt. Without them it never
+	// answers.
+	Rules Rules
+
+	def      *policy // nil: only the listed namespaces are cached
+	policies map[string]*policy
+	maxEntry int64
+	disk     *disk
+	semantic *semanticTier
+
+	mu     sync.Mutex
+	spaces map[string]*lru
+}
+
+// Setup builds a cache from the config file at path
+func Setup(path string) (*Cache, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache config: %v", err)
+	}
+	var f File
+	if err := yaml.UnmarshalStrict(raw, &f); err != nil {
+		return nil, fmt.Errorf("invalid cache config: %v", err)
+	}
+	return New(f)
+}
+
+// New builds a cache from a config file
+func New(f File) (*Cache, error) {
+	c := &Cache{
+		policies: make(map[string]*policy),
+		maxEntry: DefaultMaxEntrySize,
+		spaces:   make(map[string]*lru),
+	}
+	if f.MaxEntrySize != nil {
+		c.maxEntry = f.MaxEntrySize.Value()
+	}
+
+	base := policy{ttl: DefaultTTL, maxEntries: DefaultMaxEntries, maxSize: DefaultMaxSize}
+	if f.Default != nil {
+		base = base.merge(*f.Default)
+		if !f.Default.Disabled {
+			def := base
+			c.def = &def
+		}
+	}
+	for ns, cfg := range f.Namespaces {
+		if cfg.Disabled {
+			c.policies[ns] = nil
+			continue
+		}
+		p := base.merge(cfg)
+		if p.ttl <= 0 || p.maxEntries <= 0 || p.maxSize <= 0 {
+			return nil, fmt.Errorf("namespace %s: ttl, maxEntries and maxSize must be positive", ns)
+		}
+		c.policies[ns] = &p
+	}
+	if c.def != nil && (c.def.ttl <= 0 || c.def.maxEntries <= 0 || c.def.maxSize <= 0) {
+		return nil, fmt.Errorf("default: ttl, maxEntries and maxSize must be positive")
+	}
+
+	if f.Dir != "" {
+		max := int64(DefaultMaxDiskSize)
+		if f.MaxDiskSize != nil {
+			max = f.MaxDiskSize.Value()
+		}
+		d, err := openDisk(f.Dir, max)
+		if err != nil {
+			return nil, err
+		}
+		c.disk = d
+	}
+	if f.Semantic != nil {
+		t, err := newSemanticTier(*f.Semantic)
+		if err != nil {
+			return nil, fmt.Errorf("semantic: %v", err)
+		}
+		c.semantic = t
+	}
+	return c, nil
+}
+
+// Run prunes the disk backing until stopCh closes
+func (c *Cache) Run(stopCh <-chan struct{}) {
+	if c.disk == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		c.disk.prune(time.Now())
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Semantic reports whether the semantic tier is configured (it also needs Rules)
+func (c *Cache) Semantic() bool {
+	return c.semantic != nil
+}
+
+func (c *Cache) policy(namespace string) *policy {
+	if p, ok := c.policies[namespace]; ok {
+		return p
+	}
+	return c.def
+}
+
+// Lookup finds the cached response to a request: its own, or with the
+// semantic tier, a similar prompt's. The key is nil when the request can't be
+// cached: the namespace has no cache, the client asked to bypass it
+// (Cache-Control: no-cache or no-store) or the body isn't a completion.
+func (c *Cache) Lookup(ctx context.Context, meta identity.PodMetadata, provider string, r *http.Request, body []byte) (*Key, *Entry) {
+	namespace := meta.Namespace
+	if r.Method != http.MethodPost || c.policy(namespace) == nil {
+		return nil, nil
+	}
+	if cc := r.Header.Get("Cache-Control"); strings.Contains(cc, "no-cache") || strings.Contains(cc, "no-store") {
+		metrics.CacheLookups.WithLabelValues(namespace, "bypass").Inc()
+		return nil, nil
+	}
+	req, ok := parseRequest(body)
+	if !ok {
+		return nil, nil
+	}
+	prefix := [][]byte{[]byte(namespace), []byte(provider), []byte(r.URL.Path), []byte(r.Header.Get("Accept-Encoding"))}
+	k := &Key{namespace: namespace, exact: hash(append(prefix, req.canonical)...)}
+
+	now := time.Now()
+	if e := c.get(namespace, k.exact, now); e != nil {
+		metrics.CacheLookups.WithLabelValues(namespace, "hit").Inc()
+		metrics.CacheSaved.WithLabelValues(namespace, e.Model).Add(e.Cost)
+		return k, e
+	}
+
+	if c.semantic != nil && c.Rules != nil {
+		if e := c.similar(ctx, meta, k, req, prefix, now); e != nil {
+			metrics.CacheLookups.WithLabelValues(namespace, "semantic_hit").Inc()
+			metrics.CacheSaved.WithLabelValues(namespace, e.Model).Add(e.Cost)
+			return k, e
+		}
+	}
+
+	metrics.CacheLookups.WithLabelValues(namespace, "miss").Inc()
+	return k, nil
+}
+
+// similar looks up the response to the most similar prompt of the same
+// scope, if a rule allows it. k keeps the embedding for Store.
+func (c *Cache) similar(ctx context.Context, meta identity.PodMetadata, k *Key, req request, prefix [][]byte, now time.Time) *Entry {
+	rule, ok := c.Rules.SemanticReuse(meta, req.text)
+	if !ok {
+		return nil
+	}
+	vector, err := c.semantic.embed(ctx, req.text)
+	if err != nil {
+		metrics.CacheEmbeddingFailures.Inc()
+		log.Printf("CACHE: failed to embed a %s prompt: %v", meta.Namespace, err)
+		return nil
+	}
+	k.scope, k.rule, k.vector = hash(append(prefix, req.params)...), rule, vector
+
+	key, similarity := c.semantic.index.nearest(k.scope, vector)
+	if similarity < c.semantic.threshold {
+		return nil
+	}
+	e := c.get(meta.Namespace, key, now)
+	if e == nil {
+		c.semantic.index.remove(k.scope, key)
+		return nil
+	}
+	k.similarity = similarity
+	return e
+}
+
+// get returns a live response, from memory or else from disk
+func (c *Cache) get(namespace, key string, now time.Time) *Entry {
+	c.mu.Lock()
+	e := c.space(namespace).get(key, now)
+	c.mu.Unlock()
+	if e != nil || c.disk == nil {
+		return e
+	}
+	if e = c.disk.get(key, now); e == nil || e.Namespace != namespace {
+		return nil
+	}
+	// Back in memory, as the most recently used
+	c.mu.Lock()
+	c.space(namespace).put(key, e, c.policy(namespace))
+	c.mu.Unlock()
+	return e
+}
+
+// Store caches the response to the request Lookup returned k for
+func (c *Cache) Store(k *Key, e *Entry) {
+	p := c.policy(k.namespace)
+	if p == nil || e.size() > c.maxEntry || e.size() > p.maxSize {
+		return
+	}
+	e.Namespace = k.namespace
+	e.Stored = time.Now()
+	e.Expires = e.Stored.Add(p.ttl)
+
+	c.mu.Lock()
+	c.space(k.namespace).put(k.exact, e, p)
+	c.mu.Unlock()
+
+	if c.disk != nil {
+		if err := c.disk.put(k.exact, e); err != nil {
+			log.Printf("CACHE: failed to write %s response to disk: %v", k.namespace, err)
+		}
+	}
+	if k.vector != nil {
+		c.semantic.index.add(k.scope, k.exact, k.vector)
+	}
+}
+
+// space returns the LRU of a namespace. Callers hold c.mu.
+func (c *Cache) space(namespace string) *lru {
+	l, ok := c.spaces[namespace]
+	if !ok {
+		l = newLRU(namespace)
+		c.spaces[namespace] = l
+	}
+	return l
+}
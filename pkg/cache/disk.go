@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// disk keeps one JSON file per response. A file's modification time is the
+// response's expiry, so pruning never has to read them.
+type disk struct {
+	dir string
+	max int64
+}
+
+func openDisk(dir string, max int64) (*disk, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %v", err)
+	}
+	return &disk{dir: dir, max: max}, nil
+}
+
+func (d *disk) path(key string) string {
+	return filepath.Join(d.dir, key+".json")
+}
+
+func (d *disk) get(key string, now time.Time) *Entry {
+	raw, err := os.ReadFile(d.path(key))
+	if err != nil {
+		return nil
+	}
+	var e Entry
+	if json.Unmarshal(raw, &e) != nil || now.After(e.Expires) {
+		os.Remove(d.path(key))
+		return nil
+	}
+	return &e
+}
+
+// put writes e atomically, so readers never see half a response
+func (d *disk) put(key string, e *Entry) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(d.dir, "put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), e.Stored, e.Expires); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path(key))
+}
+
+// prune removes expired responses, then the ones closest to expiry until
+// the directory fits in max
+func (d *disk) prune(now time.Time) {
+	dirents, err := os.ReadDir(d.dir)
+	if err != nil {
+		log.Printf("CACHE: failed to read %s: %v", d.dir, err)
+		return
+	}
+
+	type file struct {
+		path    string
+		size    int64
+		expires time.Time
+	}
+	var files []file
+	var total int64
+	for _, de := range dirents {
+		info, err := de.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		p := filepath.Join(d.dir, de.Name())
+		switch {
+		case !strings.HasSuffix(de.Name(), ".json"):
+			// Left over by an interrupted put
+			if strings.HasPrefix(de.Name(), "put-") && now.Sub(info.ModTime()) > time.Hour {
+				os.Remove(p)
+			}
+		case now.After(info.ModTime()):
+			os.Remove(p)
+		default:
+			files = append(files, file{path: p, size: info.Size(), expires: info.ModTime()})
+			total += info.Size()
+		}
+	}
+
+	if total <= d.max {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].expires.Before(files[j].expires) })
+	for _, f := range files {
+		if total <= d.max {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// ignoredFields don't change what the model answers
+var ignoredFields = []string{"user", "metadata", "store"}
+
+// promptFields hold the conversation; everything else is a parameter
+var promptFields = []string{"messages", "system", "prompt"}
+
+// request is a completion request, normalized for the cache
+type request struct {
+	// canonical is the whole request: messages normalized, fields in order
+	canonical []byte
+	// params is canonical without the prompt fields
+	params []byte
+	// text is the conversation, for embeddings and policy rules
+	text string
+}
+
+// Key identifies a cacheable request (see Cache.Lookup)
+type Key struct {
+	namespace string
+	exact     string
+
+	// Semantic tier, set when a policy rule allows it
+	scope      string
+	rule       string
+	vector     []float32
+	similarity float64
+}
+
+// Similarity of the prompt the response was cached for, when it was
+// answered by the semantic tier (0 for exact hits)
+func (k *Key) Similarity() float64 {
+	return k.similarity
+}
+
+// Rule is the policy rule that allowed semantic reuse ("policy/rule")
+func (k *Key) Rule() string {
+	return k.rule
+}
+
+// parseRequest normalizes a completion request. ok is false for bodies that
+// aren't one.
+func parseRequest(body []byte) (req request, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// Keep numbers as written: 0.7 must not become 0.69999...
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if dec.Decode(&fields) != nil {
+		return req, false
+	}
+	if _, ok := fields["model"]; !ok {
+		return req, false
+	}
+	_, chat := fields["messages"]
+	_, legacy := fields["prompt"]
+	if !chat && !legacy {
+		return req, false
+	}
+
+	for _, f := range ignoredFields {
+		delete(fields, f)
+	}
+	var text []string
+	if messages, ok := fields["messages"].([]interface{}); ok {
+		for _, m := range messages {
+			if msg, ok := m.(map[string]interface{}); ok {
+				if content, ok := msg["content"]; ok {
+					msg["content"] = normalizeContent(content, &text)
+				}
+			}
+		}
+	}
+	for _, f := range []string{"system", "prompt"} {
+		if v, ok := fields[f]; ok {
+			fields[f] = normalizeContent(v, &text)
+		}
+	}
+	req.text = strings.Join(text, "\n")
+
+	// Maps marshal with sorted keys
+	var err error
+	if req.canonical, err = json.Marshal(fields); err != nil {
+		return req, false
+	}
+	for _, f := range promptFields {
+		delete(fields, f)
+	}
+	if req.params, err = json.Marshal(fields); err != nil {
+		return req, false
+	}
+	return req, true
+}
+
+// normalizeContent normalizes message content (a string or a list of
+// parts) and collects its text
+func normalizeContent(v interface{}, text *[]string) interface{} {
+	switch c := v.(type) {
+	case string:
+		s := normalizeText(c)
+		*text = append(*text, s)
+		return s
+	case []interface{}:
+		for _, part := range c {
+			if p, ok := part.(map[string]interface{}); ok {
+				if s, ok := p["text"].(string); ok {
+					p["text"] = normalizeText(s)
+					*text = append(*text, p["text"].(string))
+				}
+			}
+		}
+	}
+	return v
+}
+
+// normalizeText drops differences agents introduce without meaning to:
+// line endings and surrounding whitespace
+func normalizeText(s string) string {
+	return strings.TrimSpace(strings.ReplaceAll(s, "\r\n", "\n"))
+}
+
+// hash keys parts of a request: the namespace, the endpoint and the
+// accepted encodings come first, so nothing is shared across them
+func hash(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
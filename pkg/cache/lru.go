@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/semamesh/SemaMesh/pkg/metrics"
+)
+
+// lru is the in-memory cache of one namespace
+type lru struct {
+	namespace string
+	order     *list.List // of *item, most recently used first
+	items     map[string]*list.Element
+	size      int64
+}
+
+type item struct {
+	key   string
+	entry *Entry
+}
+
+func newLRU(namespace string) *lru {
+	return &lru{namespace: namespace, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (l *lru) get(key string, now time.Time) *Entry {
+	el, ok := l.items[key]
+	if !ok {
+		return nil
+	}
+	it := el.Value.(*item)
+	if now.After(it.entry.Expires) {
+		l.remove(el)
+		l.report()
+		return nil
+	}
+	l.order.MoveToFront(el)
+	return it.entry
+}
+
+// put adds e, then evicts the least recently used entries over p's limits
+func (l *lru) put(key string, e *Entry, p *policy) {
+	if el, ok := l.items[key]; ok {
+		l.remove(el)
+	}
+	l.items[key] = l.order.PushFront(&item{key: key, entry: e})
+	l.size += e.size()
+
+	for l.order.Len() > p.maxEntries || l.size > p.maxSize {
+		l.remove(l.order.Back())
+	}
+	l.report()
+}
+
+func (l *lru) remove(el *list.Element) {
+	it := l.order.Remove(el).(*item)
+	delete(l.items, it.key)
+	l.size -= it.entry.size()
+}
+
+func (l *lru) report() {
+	metrics.CacheEntries.WithLabelValues(l.namespace).Set(float64(l.order.Len()))
+	metrics.CacheSize.WithLabelValues(l.namespace).Set(float64(l.size))
+}
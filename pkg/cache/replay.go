@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response headers
+const (
+	// StatusHeader tells clients whether a cacheable request was answered
+	// from the cache: HIT or MISS
+	StatusHeader = "X-SemaMesh-Cache"
+	// SimilarityHeader is set on hits of the semantic tier
+	SimilarityHeader = "X-SemaMesh-Cache-Similarity"
+)
+
+// uncachedHeaders describe one particular response, not its content
+var uncachedHeaders = []string{"Content-Length", "Date", "Set-Cookie", "Connection", "Keep-Alive", "Transfer-Encoding", StatusHeader, SimilarityHeader}
+
+// Replay writes the response Lookup found for k. Streams are sent event by
+// event, so SSE clients see the framing they would get from the provider.
+func Replay(w http.ResponseWriter, k *Key, e *Entry) {
+	for name, v := range e.Header {
+		w.Header()[name] = v
+	}
+	w.Header().Set(StatusHeader, "HIT")
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(e.Stored).Seconds())))
+	if k.similarity > 0 {
+		w.Header().Set(SimilarityHeader, strconv.FormatFloat(k.similarity, 'f', 3, 64))
+	}
+
+	if !strings.HasPrefix(e.Header.Get("Content-Type"), "text/event-stream") {
+		w.Header().Set("Content-Length", strconv.Itoa(len(e.Body)))
+		w.WriteHeader(e.Status)
+		w.Write(e.Body)
+		return
+	}
+
+	w.WriteHeader(e.Status)
+	flusher, _ := w.(http.Flusher)
+	for rest := e.Body; len(rest) > 0; {
+		n := len(rest)
+		if i := bytes.Index(rest, []byte("\n\n")); i >= 0 {
+			n = i + 2
+		}
+		if _, err := w.Write(rest[:n]); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		rest = rest[n:]
+	}
+}
+
+// Recorder keeps a copy of a response on its way to the client, for Store
+type Recorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	max      int64
+	overflow bool
+}
+
+// Recorder wraps w to record what is written to it
+func (c *Cache) Recorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w, max: c.maxEntry}
+}
+
+func (r *Recorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.overflow {
+		if int64(r.body.Len()+len(p)) > r.max {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Flush keeps streams flowing through the recorder
+func (r *Recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Entry returns the recorded response, or nil if it mustn't be cached: not
+// a 200, too big or marked no-store
+func (r *Recorder) Entry() *Entry {
+	if r.status != http.StatusOK || r.overflow || strings.Contains(r.Header().Get("Cache-Control"), "no-store") {
+		return nil
+	}
+	header := r.ResponseWriter.Header().Clone()
+	for _, h := range uncachedHeaders {
+		header.Del(h)
+	}
+	return &Entry{Status: r.status, Header: header, Body: r.body.Bytes()}
+}
@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/semamesh/SemaMesh/pkg/identity"
+)
+
+// Defaults of the semantic tier
+const (
+	DefaultThreshold         = 0.95
+	DefaultSemanticEntries   = 5000
+	DefaultEmbeddingTimeout  = 2 * time.Second
+	stubDimensions           = 256
+	maxEmbeddingResponseSize = 4 << 20
+)
+
+// SemanticConfig is the second tier: a prompt close enough to a cached one
+// gets its response. Embeddings are compared within a namespace, model and
+// set of parameters, and only for prompts a policy rule allows (see Rules).
+type SemanticConfig struct {
+	Embedder EmbedderConfig `json:"embedder"`
+	// Threshold is the cosine similarity from which a response is reused
+	// (default 0.95)
+	Threshold float64 `json:"threshold,omitempty"`
+	// MaxEntries caps the prompts indexed, all scopes together, oldest out
+	// first (default 5000)
+	MaxEntries int `json:"maxEntries,omitempty"`
+}
+
+// EmbedderConfig says where embeddings come from
+type EmbedderConfig struct {
+	// URL of an OpenAI-compatible embeddings endpoint
+	URL   string `json:"url,omitempty"`
+	Model string `json:"model,omitempty"`
+	// APIKey is sent as a bearer token; ${VAR} is read from the environment
+	APIKey  string           `json:"apiKey,omitempty"`
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// Stub embeds locally by hashing words: prompts using the same words
+	// match. For local development and tests, not for paraphrases.
+	Stub bool `json:"stub,omitempty"`
+}
+
+// Rules decide which prompts may be answered with the response to a
+// similar one (see policy.Store)
+type Rules interface {
+	SemanticReuse(meta identity.PodMetadata, prompt string) (rule string, ok bool)
+}
+
+// embedder turns text into a unit vector
+type embedder interface {
+	embed(ctx context.Context, text string) ([]float32, error)
+}
+
+type semanticTier struct {
+	embedder  embedder
+	timeout   time.Duration
+	threshold float64
+	index     *index
+}
+
+func newSemanticTier(cfg SemanticConfig) (*semanticTier, error) {
+	t := &semanticTier{
+		timeout:   DefaultEmbeddingTimeout,
+		threshold: DefaultThreshold,
+		index:     newIndex(DefaultSemanticEntries),
+	}
+	if cfg.Threshold != 0 {
+		t.threshold = cfg.Threshold
+	}
+	if t.threshold <= 0 || t.threshold > 1 {
+		return nil, fmt.Errorf("threshold must be in (0, 1]")
+	}
+	if cfg.MaxEntries > 0 {
+		t.index = newIndex(cfg.MaxEntries)
+	}
+	if cfg.Embedder.Timeout != nil {
+		t.timeout = cfg.Embedder.Timeout.Duration
+	}
+
+	switch {
+	case cfg.Embedder.Stub:
+		t.embedder = stubEmbedder{}
+	case cfg.Embedder.URL != "":
+		t.embedder = &httpEmbedder{
+			url:    cfg.Embedder.URL,
+			model:  cfg.Embedder.Model,
+			apiKey: os.ExpandEnv(cfg.Embedder.APIKey),
+			client: &http.Client{},
+		}
+	default:
+		return nil, fmt.Errorf("embedder: url or stub is required")
+	}
+	return t, nil
+}
+
+func (t *semanticTier) embed(ctx context.Context, text string) ([]float32, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.embedder.embed(ctx, text)
+}
+
+// httpEmbedder calls an OpenAI-compatible /v1/embeddings endpoint
+type httpEmbedder struct {
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+func (e *httpEmbedder) embed(ctx context.Context, text string) ([]float32, error) {
+	body, _ := json.Marshal(map[string]interface{}{"model": e.model, "input": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings endpoint answered %s", resp.Status)
+	}
+	var out struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxEmbeddingResponseSize)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("invalid embeddings response: %v", err)
+	}
+	if len(out.Data) == 0 || len(out.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("embeddings response has no embedding")
+	}
+	return normalize(out.Data[0].Embedding), nil
+}
+
+// stubEmbedder hashes lowercased words into a fixed number of dimensions
+type stubEmbedder struct{}
+
+func (stubEmbedder) embed(_ context.Context, text string) ([]float32, error) {
+	v := make([]float32, stubDimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		h := fnv.New32a()
+		h.Write([]byte(w))
+		v[h.Sum32()%stubDimensions]++
+	}
+	return normalize(v), nil
+}
+
+// normalize scales v to unit length, so similarity is a dot product
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+// index finds the nearest cached prompt by brute force, which is fast
+// enough for a few thousand prompts
+type index struct {
+	max int
+
+	mu     sync.RWMutex
+	scopes map[string]*scope
+	// order holds every prompt, oldest first; max applies to all scopes
+	// together, since parameters like temperature make scopes plentiful
+	order *list.List
+}
+
+// scope holds the prompts sharing a namespace, model and parameters
+type scope struct {
+	keys    []string
+	vectors [][]float32
+	// elements in index.order
+	elements []*list.Element
+}
+
+// indexed is an index.order element
+type indexed struct {
+	scope, key string
+}
+
+func newIndex(max int) *index {
+	return &index{max: max, scopes: make(map[string]*scope), order: list.New()}
+}
+
+// nearest returns the key of the most similar prompt in a scope
+func (ix *index) nearest(name string, v []float32) (key string, similarity float64) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	s, ok := ix.scopes[name]
+	if !ok {
+		return "", 0
+	}
+	for i, w := range s.vectors {
+		if len(w) != len(v) {
+			continue
+		}
+		var dot float64
+		for j := range v {
+			dot += float64(v[j]) * float64(w[j])
+		}
+		if dot > similarity {
+			key, similarity = s.keys[i], dot
+		}
+	}
+	return key, similarity
+}
+
+func (ix *index) add(name, key string, v []float32) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.removeLocked(name, key)
+	s, ok := ix.scopes[name]
+	if !ok {
+		s = &scope{}
+		ix.scopes[name] = s
+	}
+	s.keys = append(s.keys, key)
+	s.vectors = append(s.vectors, v)
+	s.elements = append(s.elements, ix.order.PushBack(indexed{scope: name, key: key}))
+
+	for ix.order.Len() > ix.max {
+		oldest := ix.order.Front().Value.(indexed)
+		ix.removeLocked(oldest.scope, oldest.key)
+	}
+}
+
+// remove drops a prompt whose response is no longer cached
+func (ix *index) remove(name, key string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.removeLocked(name, key)
+}
+
+// len is the number of prompts indexed
+func (ix *index) len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.order.Len()
+}
+
+func (ix *index) removeLocked(name, key string) {
+	s, ok := ix.scopes[name]
+	if !ok {
+		return
+	}
+	for i, k := range s.keys {
+		if k == key {
+			ix.order.Remove(s.elements[i])
+			s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			s.vectors = append(s.vectors[:i], s.vectors[i+1:]...)
+			s.elements = append(s.elements[:i], s.elements[i+1:]...)
+			break
+		}
+	}
+	if len(s.keys) == 0 {
+		delete(ix.scopes, name)
+	}
+}
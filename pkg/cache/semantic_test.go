@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestStubEmbedderThreshold(t *testing.T) {
+	tier, err := newSemanticTier(SemanticConfig{Embedder: EmbedderConfig{Stub: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := tier.embed(context.Background(), "What are your opening hours?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tier.index.add("scope", "cached", cached)
+
+	tests := []struct {
+		prompt string
+		reused bool
+	}{
+		// Same words: case and punctuation don't matter
+		{"what are your OPENING hours", true},
+		{"Opening hours: what are your...", true},
+		// More words dilute the similarity below 0.95
+		{"What are your opening hours on Sunday?", false},
+		{"Cancel my subscription", false},
+	}
+	for _, tt := range tests {
+		v, err := tier.embed(context.Background(), tt.prompt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, similarity := tier.index.nearest("scope", v)
+		if reused := key == "cached" && similarity >= tier.threshold; reused != tt.reused {
+			t.Errorf("%q: similarity %.3f, reused = %v, want %v", tt.prompt, similarity, reused, tt.reused)
+		}
+	}
+
+	// Other scopes (namespace, model, parameters) never match
+	if key, _ := tier.index.nearest("other", cached); key != "" {
+		t.Errorf("nearest in another scope = %q, want none", key)
+	}
+}
+
+func TestSemanticThresholdValidation(t *testing.T) {
+	for _, threshold := range []float64{-0.5, 1.5} {
+		cfg := SemanticConfig{Embedder: EmbedderConfig{Stub: true}, Threshold: threshold}
+		if _, err := newSemanticTier(cfg); err == nil {
+			t.Errorf("threshold %v accepted", threshold)
+		}
+	}
+	if _, err := newSemanticTier(SemanticConfig{}); err == nil {
+		t.Error("config without an embedder accepted")
+	}
+}
+
+func TestIndexCapsAllScopes(t *testing.T) {
+	ix := newIndex(3)
+	v := []float32{1}
+	// One prompt per scope, as with a different temperature per request
+	for i := 0; i < 5; i++ {
+		ix.add(fmt.Sprintf("scope-%d", i), fmt.Sprintf("key-%d", i), v)
+	}
+	if got := ix.len(); got != 3 {
+		t.Errorf("indexed %d prompts, want 3", got)
+	}
+	if len(ix.scopes) != 3 {
+		t.Errorf("%d scopes left, want 3", len(ix.scopes))
+	}
+	for i, want := range []string{"", "", "key-2", "key-3", "key-4"} {
+		if key, _ := ix.nearest(fmt.Sprintf("scope-%d", i), v); key != want {
+			t.Errorf("scope-%d: nearest = %q, want %q", i, key, want)
+		}
+	}
+
+	// Re-adding a prompt makes it the newest
+	ix.add("scope-2", "key-2", v)
+	ix.add("scope-5", "key-5", v)
+	if key, _ := ix.nearest("scope-2", v); key != "key-2" {
+		t.Errorf("re-added prompt evicted")
+	}
+	if key, _ := ix.nearest("scope-3", v); key != "" {
+		t.Errorf("oldest prompt kept")
+	}
+
+	ix.remove("scope-2", "key-2")
+	if got := ix.len(); got != 2 {
+		t.Errorf("indexed %d prompts after remove, want 2", got)
+	}
+}
@@ -0,0 +1,130 @@
+package identity
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPResolver finds the real client IP when SemaMesh sits behind an
+// ingress, an egress gateway or another mesh sidecar. Forwarding headers are
+// only honoured when they were added by a trusted proxy, otherwise any client
+// could claim to be any pod.
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or single IPs
+func ParseTrustedProxies(spec string) (*ClientIPResolver, error) {
+	c := &ClientIPResolver{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			c.trusted = append(c.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, cidr, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR %q: %v", item, err)
+		}
+		c.trusted = append(c.trusted, cidr)
+	}
+	return c, nil
+}
+
+// IsTrusted reports whether ip belongs to a trusted proxy
+func (c *ClientIPResolver) IsTrusted(ip net.IP) bool {
+	if c == nil || ip == nil {
+		return false
+	}
+	for _, cidr := range c.trusted {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP of the original client.
+// The forwarding chain is walked right to left (nearest hop first) and the
+// first address that isn't a trusted proxy is the client. Entries further
+// left were written by that untrusted client and are ignored.
+func (c *ClientIPResolver) ClientIP(r *http.Request) string {
+	peer := hostOnly(r.RemoteAddr)
+	if !c.IsTrusted(net.ParseIP(peer)) {
+		return peer
+	}
+
+	// RFC 7239 Forwarded wins over the de-facto X-Forwarded-For
+	chain := forwardedFor(r.Header.Values("Forwarded"))
+	if len(chain) == 0 {
+		chain = xForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil {
+			// "unknown", obfuscated or garbage: stop at the last hop we trust
+			break
+		}
+		client = ip.String()
+		if !c.IsTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// xForwardedFor flattens "X-Forwarded-For: a, b" (possibly repeated) into [a b]
+func xForwardedFor(values []string) []string {
+	var chain []string
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				chain = append(chain, hostOnly(hop))
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedFor extracts the for= parameters of RFC 7239 Forwarded headers,
+// e.g. `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`
+func forwardedFor(values []string) []string {
+	var chain []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				chain = append(chain, hostOnly(strings.Trim(value, `"`)))
+			}
+		}
+	}
+	return chain
+}
+
+// hostOnly strips the port (and IPv6 brackets) from an address
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
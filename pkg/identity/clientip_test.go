@@ -0,0 +1,116 @@
+package identity
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	resolver, err := ParseTrustedProxies("10.0.0.0/24, 192.168.1.10, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		peer      string
+		xff       []string
+		forwarded []string
+		want      string
+	}{
+		{
+			name: "untrusted peer: headers ignored",
+			peer: "203.0.113.7:51000",
+			xff:  []string{"10.244.1.5"},
+			want: "203.0.113.7",
+		},
+		{
+			name: "trusted peer without headers",
+			peer: "10.0.0.2:51000",
+			want: "10.0.0.2",
+		},
+		{
+			name: "trusted peer",
+			peer: "10.0.0.2:51000",
+			xff:  []string{"10.244.1.5"},
+			want: "10.244.1.5",
+		},
+		{
+			name: "spoofed left-most entry behind a trusted proxy",
+			peer: "10.0.0.2:51000",
+			// The client wrote "10.244.9.9" itself; the proxy appended the
+			// address it saw
+			xff:  []string{"10.244.9.9, 10.244.1.5"},
+			want: "10.244.1.5",
+		},
+		{
+			name: "chain of trusted proxies",
+			peer: "192.168.1.10:51000",
+			xff:  []string{"10.244.9.9, 10.244.1.5", "10.0.0.3"},
+			want: "10.244.1.5",
+		},
+		{
+			name: "garbage hop stops at the last trusted one",
+			peer: "10.0.0.2:51000",
+			xff:  []string{"10.244.1.5, unknown, 10.0.0.3"},
+			want: "10.0.0.3",
+		},
+		{
+			name: "only trusted hops",
+			peer: "10.0.0.2:51000",
+			xff:  []string{"10.0.0.4, 10.0.0.3"},
+			want: "10.0.0.4",
+		},
+		{
+			name:      "Forwarded wins over X-Forwarded-For",
+			peer:      "10.0.0.2:51000",
+			xff:       []string{"10.244.9.9"},
+			forwarded: []string{`for=10.244.8.8;proto=http, for="10.244.1.5:4711"`},
+			want:      "10.244.1.5",
+		},
+		{
+			name:      "Forwarded IPv6",
+			peer:      "[fd00::1]:51000",
+			forwarded: []string{`For="[2001:db8:cafe::17]:4711"`},
+			want:      "2001:db8:cafe::17",
+		},
+		{
+			name:      "Forwarded obfuscated identifier",
+			peer:      "10.0.0.2:51000",
+			forwarded: []string{"for=_hidden"},
+			want:      "10.0.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			r.RemoteAddr = tt.peer
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			for _, v := range tt.forwarded {
+				r.Header.Add("Forwarded", v)
+			}
+			if got := resolver.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, spec := range []string{"10.0.0.0/33", "proxy.example.com", "10.0.0.1/24/8"} {
+		if _, err := ParseTrustedProxies(spec); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) accepted", spec)
+		}
+	}
+
+	// Without trusted proxies, nobody is trusted
+	var none *ClientIPResolver
+	if none.IsTrusted(net.ParseIP("10.0.0.1")) {
+		t.Error("nil resolver trusts 10.0.0.1")
+	}
+}
@@ -0,0 +1,181 @@
+package identity
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// DevIdentityHeader lets a local client pick its identity in dev mode, either
+// by name (an entry of the dev identities file) or as "namespace/pod[/sa]".
+// It is ignored outside dev mode.
+const DevIdentityHeader = "X-Sema-Identity"
+
+// SourceDev marks identities that came from the dev overrides
+const SourceDev = "dev"
+
+// DevIdentitiesFile is the format of the --dev-identities YAML file:
+//
+//	identities:
+//	  - name: billing-agent
+//	    cidr: 127.0.0.2/32
+//	    namespace: billing
+//	    podName: invoice-bot-7d9f
+//	    serviceAccount: invoice-bot
+//	    workloadKind: Deployment
+//	    workloadName: invoice-bot
+//	    labels: {app: invoice-bot}
+//	    annotations: {semamesh.io/team: finance}
+type DevIdentitiesFile struct {
+	Identities []DevIdentity `json:"identities"`
+}
+
+// DevIdentity maps an IP or CIDR (and/or a name) to a pod identity
+type DevIdentity struct {
+	Name string `json:"name,omitempty"`
+	IP   string `json:"ip,omitempty"`
+	CIDR string `json:"cidr,omitempty"`
+
+	Namespace      string            `json:"namespace"`
+	PodName        string            `json:"podName,omitempty"`
+	ServiceAccount string            `json:"serviceAccount,omitempty"`
+	NodeName       string            `json:"nodeName,omitempty"`
+	WorkloadKind   string            `json:"workloadKind,omitempty"`
+	WorkloadName   string            `json:"workloadName,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+}
+
+type devIdentity struct {
+	name string
+	cidr *net.IPNet // nil for header-only entries
+	meta PodMetadata
+}
+
+// LoadDevIdentities reads static identity overrides for dev mode, so
+// per-namespace policies, quotas and metrics can be tested without a cluster.
+// Entries are matched in file order; the first matching CIDR wins.
+func (m *Manager) LoadDevIdentities(path string) error {
+	if !m.devMode {
+		return fmt.Errorf("dev identities are only allowed in dev mode")
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read dev identities: %v", err)
+	}
+	var file DevIdentitiesFile
+	if err := yaml.UnmarshalStrict(raw, &file); err != nil {
+		return fmt.Errorf("invalid dev identities file: %v", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var entries []devIdentity
+	for i, id := range file.Identities {
+		if id.Namespace == "" {
+			return fmt.Errorf("dev identity #%d: namespace is required", i+1)
+		}
+
+		entry := devIdentity{name: id.Name}
+		switch {
+		case id.CIDR != "":
+			_, cidr, err := net.ParseCIDR(id.CIDR)
+			if err != nil {
+				return fmt.Errorf("dev identity #%d: %v", i+1, err)
+			}
+			entry.cidr = cidr
+		case id.IP != "":
+			ip := net.ParseIP(id.IP)
+			if ip == nil {
+				return fmt.Errorf("dev identity #%d: invalid ip %q", i+1, id.IP)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			entry.cidr = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		case id.Name == "":
+			return fmt.Errorf("dev identity #%d: needs an ip, a cidr or a name", i+1)
+		}
+
+		entry.meta = PodMetadata{
+			Namespace:      id.Namespace,
+			PodName:        id.PodName,
+			ServiceAccount: id.ServiceAccount,
+			NodeName:       id.NodeName,
+			WorkloadKind:   id.WorkloadKind,
+			WorkloadName:   id.WorkloadName,
+			Labels:         id.Labels,
+			Annotations:    id.Annotations,
+			Source:         SourceDev,
+		}
+		if len(m.promotions) > 0 {
+			entry.meta.Promoted = make(map[string]string, len(m.promotions))
+			for _, p := range m.promotions {
+				entry.meta.Promoted[p.Name] = p.valueFrom(id.Labels, id.Annotations)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	m.devIdentities = entries
+	log.Printf("🧪 Loaded %d dev identities from %s", len(entries), path)
+	return nil
+}
+
+// DevMode reports whether the manager runs without a cluster
+func (m *Manager) DevMode() bool {
+	return m.devMode
+}
+
+// DevHeaderIdentity resolves the X-Sema-Identity header. It only works in dev
+// mode: in a real cluster a client must never be able to pick its identity.
+func (m *Manager) DevHeaderIdentity(value string) (PodMetadata, bool) {
+	if !m.devMode || value == "" {
+		return PodMetadata{}, false
+	}
+
+	// 1. A named entry from the dev identities file
+	m.mutex.RLock()
+	for _, entry := range m.devIdentities {
+		if entry.name != "" && entry.name == value {
+			m.mutex.RUnlock()
+			return entry.meta, true
+		}
+	}
+	m.mutex.RUnlock()
+
+	// 2. An ad-hoc "namespace/pod[/serviceaccount]"
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return PodMetadata{}, false
+	}
+	meta := PodMetadata{Namespace: parts[0], PodName: parts[1], Source: SourceDev}
+	if len(parts) == 3 {
+		meta.ServiceAccount = parts[2]
+	}
+	return meta, true
+}
+
+// devIdentityForIP returns the first static identity whose CIDR contains ip
+func (m *Manager) devIdentityForIP(ip string) (PodMetadata, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return PodMetadata{}, false
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for _, entry := range m.devIdentities {
+		if entry.cidr != nil && entry.cidr.Contains(parsed) {
+			return entry.meta, true
+		}
+	}
+	return PodMetadata{}, false
+}
@@ -7,12 +7,11 @@ import (
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	// metav1 removed
-	"k8s.io/client-go/informers"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
-	// context removed
 )
 
 // PodMetadata holds the identity info we care about
@@ -20,25 +19,85 @@ type PodMetadata struct {
 	Namespace      string
 	PodName        string
 	ServiceAccount string
+	NodeName       string
+
+	// Top-level owner, e.g. Deployment/checkout (resolved through ReplicaSets)
+	WorkloadKind string
+	WorkloadName string
+
+	Labels      map[string]string
+	Annotations map[string]string // Only the selected keys, see SetAnnotationKeys
+
+	// Promoted holds the values of the promoted labels, by promoted name
+	Promoted map[string]string
+
+	// Source tells how the caller was identified (ip, spiffe, token)
+	Source string
 }
 
+// Workload returns the owner as "Kind/name" (empty for bare pods)
+func (p PodMetadata) Workload() string {
+	if p.WorkloadKind == "" {
+		return ""
+	}
+	return p.WorkloadKind + "/" + p.WorkloadName
+}
+
+// DefaultGracePeriod keeps a deleted pod's IP resolvable for a short while,
+// so requests still in flight from a just-deleted pod are attributed correctly.
+const DefaultGracePeriod = 30 * time.Second
+
 // Manager is a thread-safe store for IP -> Identity lookups
 type Manager struct {
-	ipMap   map[string]PodMetadata
+	ipMap   map[string]ipEntry
+	podIPs  map[types.UID][]string // Reverse index: the IPs each pod currently owns
 	mutex   sync.RWMutex
 	devMode bool
+
+	gracePeriod time.Duration
+
+	// Which annotations to keep and which labels to promote to metrics/audit
+	annotationKeys []string
+	promotions     []PromotedLabel
+
+	owners *ownerResolver
+
+	// Static identities for dev mode (see LoadDevIdentities)
+	devIdentities []devIdentity
+}
+
+// ipEntry binds an IP to the pod (by UID) that owns it.
+// Ownership checks use the UID, so a late event about an old pod can never
+// evict the new pod that has been given the same IP.
+type ipEntry struct {
+	uid     types.UID
+	meta    PodMetadata
+	expires time.Time // Zero while the pod is live; set when it goes away
 }
 
 // NewManager creates the store
 func NewManager(devMode bool) *Manager {
 	return &Manager{
-		ipMap:   make(map[string]PodMetadata),
-		devMode: devMode,
+		ipMap:       make(map[string]ipEntry),
+		podIPs:      make(map[types.UID][]string),
+		devMode:     devMode,
+		gracePeriod: DefaultGracePeriod,
+
+		annotationKeys: DefaultAnnotationKeys,
+		owners:         &ownerResolver{},
 	}
 }
 
-// StartWatcher connects to K8s and listens for Pod IP changes
-func (m *Manager) StartWatcher(kubeconfigPath string) error {
+// SetGracePeriod changes how long a released IP keeps resolving to its old pod
+func (m *Manager) SetGracePeriod(d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.gracePeriod = d
+}
+
+// StartWatcher connects to K8s and listens for Pod IP changes until stopCh
+// closes
+func (m *Manager) StartWatcher(kubeconfigPath string, opts WatchOptions, stopCh <-chan struct{}) error {
 	if m.devMode {
 		log.Println("⚠️ Identity Manager: Running in Dev Mode (No K8s connection)")
 		return nil
@@ -50,39 +109,54 @@ func (m *Manager) StartWatcher(kubeconfigPath string) error {
 		return fmt.Errorf("failed to build kubeconfig: %v", err)
 	}
 
-	// 2. Create the Client
+	// 2. Create the Clients (typed for Pods, metadata-only for their owners)
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return fmt.Errorf("failed to create k8s client: %v", err)
 	}
+	metaClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create k8s metadata client: %v", err)
+	}
 
-	// 3. Create an Informer Factory (Resync every 10 minutes)
-	factory := informers.NewSharedInformerFactory(clientset, 10*time.Minute)
-	podInformer := factory.Core().V1().Pods().Informer()
+	// 3. Create the Informers, scoped by the watch options
+	w := m.newWatch(clientset, metaClient, opts)
 
 	// 4. Register Event Handlers (Add, Update, Delete)
-	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			m.handlePodUpdate(obj)
-		},
-		UpdateFunc: func(oldObj, newObj interface{}) {
-			m.handlePodUpdate(newObj)
-		},
-		DeleteFunc: func(obj interface{}) {
-			m.handlePodDelete(obj)
-		},
-	})
+	for _, podInformer := range w.podInformers {
+		podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				m.handlePodUpdate(obj)
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				m.handlePodUpdate(newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				m.handlePodDelete(obj)
+			},
+		})
+	}
 
 	// 5. Start the Watcher in the background
-	stopper := make(chan struct{})
-	log.Println("⚡ Connected to Kubernetes API. Watching Pods...")
-	go podInformer.Run(stopper)
-
-	// Wait for cache sync so we don't miss existing pods
-	if !cache.WaitForCacheSync(stopper, podInformer.HasSynced) {
-		return fmt.Errorf("timed out waiting for caches to sync")
+	log.Printf("⚡ Connected to Kubernetes API. Watching Pods (%s)...", opts)
+	if err := w.start(stopCh); err != nil {
+		return err
 	}
 
+	// 6. Forget released IPs once their grace window is over
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				m.sweep()
+			}
+		}
+	}()
+
 	return nil
 }
 
@@ -93,47 +167,185 @@ func (m *Manager) handlePodUpdate(obj interface{}) {
 		return
 	}
 
-	// Only care if the Pod has an IP
-	if pod.Status.PodIP == "" {
+	// Finished pods give their IP back to the node, release it
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		m.releasePod(pod.UID)
+		return
+	}
+
+	// hostNetwork pods share the node IP, it can't identify any one of them
+	if pod.Spec.HostNetwork {
+		m.releasePod(pod.UID)
 		return
 	}
 
+	ips := podIPs(pod)
+	meta := m.buildMetadata(pod)
+
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	m.ipMap[pod.Status.PodIP] = PodMetadata{
+	// 1. Release IPs this pod no longer has (IP change, dual-stack update...)
+	now := time.Now()
+	for _, ip := range m.podIPs[pod.UID] {
+		if !containsString(ips, ip) {
+			m.releaseIPLocked(ip, pod.UID, now)
+		}
+	}
+
+	// 2. Claim the current IPs. The pod reporting an IP now is its owner,
+	// even if a previous pod's entry is still in its grace window.
+	for _, ip := range ips {
+		if prev, exists := m.ipMap[ip]; exists && prev.uid != pod.UID && prev.expires.IsZero() {
+			log.Printf("⚠️ Identity: IP %s moved from %s/%s to %s/%s", ip,
+				prev.meta.Namespace, prev.meta.PodName, pod.Namespace, pod.Name)
+		}
+		m.ipMap[ip] = ipEntry{uid: pod.UID, meta: meta}
+	}
+
+	if len(ips) == 0 {
+		delete(m.podIPs, pod.UID)
+	} else {
+		m.podIPs[pod.UID] = ips
+	}
+}
+
+// buildMetadata captures everything we attribute traffic by
+func (m *Manager) buildMetadata(pod *corev1.Pod) PodMetadata {
+	m.mutex.RLock()
+	owners, annotationKeys, promotions := m.owners, m.annotationKeys, m.promotions
+	m.mutex.RUnlock()
+
+	meta := PodMetadata{
 		Namespace:      pod.Namespace,
 		PodName:        pod.Name,
 		ServiceAccount: pod.Spec.ServiceAccountName,
+		NodeName:       pod.Spec.NodeName,
+		Labels:         pod.Labels,
+		Source:         SourceIP,
+	}
+	meta.WorkloadKind, meta.WorkloadName = owners.resolve(pod)
+
+	for _, key := range annotationKeys {
+		if v, ok := pod.Annotations[key]; ok {
+			if meta.Annotations == nil {
+				meta.Annotations = make(map[string]string)
+			}
+			meta.Annotations[key] = v
+		}
+	}
+
+	if len(promotions) > 0 {
+		meta.Promoted = make(map[string]string, len(promotions))
+		for _, p := range promotions {
+			meta.Promoted[p.Name] = p.valueFrom(pod.Labels, pod.Annotations)
+		}
 	}
+	return meta
 }
 
-// handlePodDelete removes the IP from the map
+// handlePodDelete releases the IPs owned by the deleted pod
 func (m *Manager) handlePodDelete(obj interface{}) {
+	// The informer hands us a tombstone if it missed the actual delete event
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
 	pod, ok := obj.(*corev1.Pod)
 	if !ok {
 		return
 	}
 
+	m.releasePod(pod.UID)
+}
+
+// releasePod starts the grace window for every IP owned by the pod
+func (m *Manager) releasePod(uid types.UID) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	delete(m.ipMap, pod.Status.PodIP)
+
+	now := time.Now()
+	for _, ip := range m.podIPs[uid] {
+		m.releaseIPLocked(ip, uid, now)
+	}
+	delete(m.podIPs, uid)
+}
+
+// releaseIPLocked expires an IP mapping, but only if uid still owns it.
+// Caller must hold the write lock.
+func (m *Manager) releaseIPLocked(ip string, uid types.UID, now time.Time) {
+	entry, exists := m.ipMap[ip]
+	if !exists || entry.uid != uid || !entry.expires.IsZero() {
+		return
+	}
+
+	if m.gracePeriod <= 0 {
+		delete(m.ipMap, ip)
+		return
+	}
+	entry.expires = now.Add(m.gracePeriod)
+	m.ipMap[ip] = entry
+}
+
+// sweep drops mappings whose grace window is over
+func (m *Manager) sweep() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	for ip, entry := range m.ipMap {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			delete(m.ipMap, ip)
+		}
+	}
+}
+
+// podIPs returns all IPs of a pod (both families on dual-stack clusters)
+func podIPs(pod *corev1.Pod) []string {
+	var ips []string
+	for _, podIP := range pod.Status.PodIPs {
+		if podIP.IP != "" {
+			ips = append(ips, podIP.IP)
+		}
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	return ips
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
 
 // GetIdentity looks up the metadata for a given IP
 func (m *Manager) GetIdentity(ip string) (PodMetadata, bool) {
-	// 1. Dev Mode Bypass (Localhost always returns fake identity)
+	// 1. Dev Mode Bypass: static overrides first, then localhost gets a fake identity
+	if m.devMode {
+		if meta, ok := m.devIdentityForIP(ip); ok {
+			return meta, true
+		}
+	}
 	if m.devMode && (ip == "127.0.0.1" || ip == "::1") {
 		return PodMetadata{
 			Namespace:      "dev-workspace",
 			PodName:        "curl-terminal-agent",
 			ServiceAccount: "admin-user",
+			Source:         SourceIP,
 		}, true
 	}
 
-	// 2. Real Lookup
+	// 2. Real Lookup (released IPs still resolve during their grace window)
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
-	meta, exists := m.ipMap[ip]
-	return meta, exists
-}
\ No newline at end of file
+	entry, exists := m.ipMap[ip]
+	if !exists || (!entry.expires.IsZero() && time.Now().After(entry.expires)) {
+		return PodMetadata{}, false
+	}
+	return entry.meta, true
+}
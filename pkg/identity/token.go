@@ -0,0 +1,466 @@
+package identity
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// TokenReviewVerifier validates projected ServiceAccount tokens by asking the
+// API server (TokenReview). Results are cached briefly to keep the API server
+// out of the hot path; failed reviews (API errors, cancelled requests) are not,
+// so the next request asks again.
+type TokenReviewVerifier struct {
+	client    kubernetes.Interface
+	audiences []string
+
+	mutex sync.Mutex
+	cache map[string]cachedReview
+}
+
+type cachedReview struct {
+	meta    PodMetadata
+	err     error
+	expires time.Time
+}
+
+// tokenReviewCacheTTL bounds how long a revoked token can still be accepted
+const tokenReviewCacheTTL = time.Minute
+
+// NewTokenReviewVerifier connects to the API server (in-cluster if kubeconfigPath is empty)
+func NewTokenReviewVerifier(kubeconfigPath string, audiences []string) (*TokenReviewVerifier, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kubeconfig: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create k8s client: %v", err)
+	}
+
+	return &TokenReviewVerifier{
+		client:    clientset,
+		audiences: audiences,
+		cache:     make(map[string]cachedReview),
+	}, nil
+}
+
+// Verify implements Verifier
+func (v *TokenReviewVerifier) Verify(r *http.Request) (PodMetadata, bool, error) {
+	token := r.Header.Get(TokenHeader)
+	if token == "" {
+		return PodMetadata{}, false, nil
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	key := string(sum[:])
+
+	v.mutex.Lock()
+	if cached, ok := v.cache[key]; ok && time.Now().Before(cached.expires) {
+		v.mutex.Unlock()
+		return cached.meta, cached.err == nil, cached.err
+	}
+	v.mutex.Unlock()
+
+	meta, definitive, err := v.review(r.Context(), token)
+	if !definitive {
+		return meta, false, err
+	}
+
+	v.mutex.Lock()
+	// Opportunistic cleanup so the cache can't grow without bound
+	if len(v.cache) > 10000 {
+		v.cache = make(map[string]cachedReview)
+	}
+	v.cache[key] = cachedReview{meta: meta, err: err, expires: time.Now().Add(tokenReviewCacheTTL)}
+	v.mutex.Unlock()
+
+	return meta, err == nil, err
+}
+
+// review asks the API server about the token. definitive is false when the
+// review itself failed, as opposed to the token being rejected.
+func (v *TokenReviewVerifier) review(ctx context.Context, token string) (meta PodMetadata, definitive bool, err error) {
+	review, err := v.client.AuthenticationV1().TokenReviews().Create(ctx, &authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{Token: token, Audiences: v.audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return PodMetadata{}, false, fmt.Errorf("token review failed: %v", err)
+	}
+	if !review.Status.Authenticated {
+		return PodMetadata{}, true, fmt.Errorf("token rejected: %s", review.Status.Error)
+	}
+
+	namespace, sa, ok := parseServiceAccountUser(review.Status.User.Username)
+	if !ok {
+		return PodMetadata{}, true, fmt.Errorf("token does not belong to a service account: %s", review.Status.User.Username)
+	}
+
+	meta = PodMetadata{Namespace: namespace, ServiceAccount: sa, Source: SourceToken}
+	// Bound (projected) tokens name the pod they were issued to
+	if pods := review.Status.User.Extra["authentication.kubernetes.io/pod-name"]; len(pods) > 0 {
+		meta.PodName = pods[0]
+	}
+	return meta, true, nil
+}
+
+// JWKSVerifier validates projected ServiceAccount tokens offline, against the
+// issuer's published signing keys (e.g. https://kubernetes.default.svc/openid/v1/jwks).
+type JWKSVerifier struct {
+	URL    string
+	Issuer string
+	// Audiences accepted, like TokenReview: a token must carry one of them
+	Audiences []string
+
+	httpClient  *http.Client
+	bearerToken string
+
+	mutex     sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+
+	// refreshing serializes fetches; attemptedAt is the last one, failed or not
+	refreshing  sync.Mutex
+	attemptedAt time.Time
+}
+
+const (
+	// jwksRefreshInterval is how often keys are refetched
+	jwksRefreshInterval = 10 * time.Minute
+	// jwksRetryInterval is the minimum delay between fetches: it rate-limits
+	// refetches for unknown key IDs and backs off after a failed fetch
+	jwksRetryInterval = 30 * time.Second
+)
+
+// NewJWKSVerifier creates a verifier. caFile and tokenFile are optional and
+// let the proxy fetch the API server's JWKS with its own ServiceAccount.
+func NewJWKSVerifier(url, issuer string, audiences []string, caFile, tokenFile string) (*JWKSVerifier, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	v := &JWKSVerifier{
+		URL:        url,
+		Issuer:     issuer,
+		Audiences:  audiences,
+		httpClient: &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}
+	if tokenFile != "" {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS bearer token: %v", err)
+		}
+		v.bearerToken = strings.TrimSpace(string(token))
+	}
+
+	v.attemptedAt = time.Now()
+	if err := v.refresh(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Verify implements Verifier
+func (v *JWKSVerifier) Verify(r *http.Request) (PodMetadata, bool, error) {
+	token := r.Header.Get(TokenHeader)
+	if token == "" {
+		return PodMetadata{}, false, nil
+	}
+
+	meta, err := v.verifyToken(token, time.Now())
+	return meta, err == nil, err
+}
+
+// serviceAccountClaims is the subset of a projected token we care about
+type serviceAccountClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	Expiry    int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+
+	Kubernetes struct {
+		Namespace string `json:"namespace"`
+		Pod       struct {
+			Name string `json:"name"`
+		} `json:"pod"`
+		ServiceAccount struct {
+			Name string `json:"name"`
+		} `json:"serviceaccount"`
+	} `json:"kubernetes.io"`
+}
+
+func (v *JWKSVerifier) verifyToken(token string, now time.Time) (PodMetadata, error) {
+	// 1. Split and decode the JWT
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return PodMetadata{}, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return PodMetadata{}, fmt.Errorf("malformed token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return PodMetadata{}, fmt.Errorf("malformed token signature: %v", err)
+	}
+
+	// 2. Check the signature with the issuer's key
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return PodMetadata{}, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(header.Alg, key, digest[:], signature); err != nil {
+		return PodMetadata{}, err
+	}
+
+	// 3. Check the claims
+	var claims serviceAccountClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return PodMetadata{}, fmt.Errorf("malformed token claims: %v", err)
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return PodMetadata{}, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if len(v.Audiences) > 0 && !hasAudience(claims.Audience, v.Audiences) {
+		return PodMetadata{}, fmt.Errorf("token not issued for audience %s", strings.Join(v.Audiences, ", "))
+	}
+	if claims.Expiry == 0 || now.Unix() >= claims.Expiry {
+		return PodMetadata{}, fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return PodMetadata{}, fmt.Errorf("token not valid yet")
+	}
+
+	namespace, sa, ok := parseServiceAccountUser(claims.Subject)
+	if !ok {
+		return PodMetadata{}, fmt.Errorf("token does not belong to a service account: %s", claims.Subject)
+	}
+
+	return PodMetadata{
+		Namespace:      namespace,
+		ServiceAccount: sa,
+		PodName:        claims.Kubernetes.Pod.Name,
+		Source:         SourceToken,
+	}, nil
+}
+
+// key returns the signing key. Keys are refetched when stale, or when the kid
+// is unknown (the issuer may have rotated its keys), at most every
+// jwksRetryInterval.
+func (v *JWKSVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mutex.RLock()
+	key, ok := v.keys[kid]
+	stale := time.Since(v.fetchedAt) > jwksRefreshInterval
+	v.mutex.RUnlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+
+	if ok {
+		// Stale: refresh unless someone else is, and keep serving with the
+		// keys we have if it fails
+		if v.refreshing.TryLock() {
+			v.tryRefresh()
+			v.refreshing.Unlock()
+		}
+		return key, nil
+	}
+
+	v.refreshing.Lock()
+	err := v.tryRefresh()
+	v.refreshing.Unlock()
+
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("unknown token signing key %q", kid)
+}
+
+// tryRefresh refetches the keys unless the last attempt was too recent.
+// The caller holds v.refreshing.
+func (v *JWKSVerifier) tryRefresh() error {
+	if time.Since(v.attemptedAt) < jwksRetryInterval {
+		return nil
+	}
+	v.attemptedAt = time.Now()
+	return v.refresh()
+}
+
+func (v *JWKSVerifier) refresh() error {
+	req, err := http.NewRequest(http.MethodGet, v.URL, nil)
+	if err != nil {
+		return err
+	}
+	if v.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+v.bearerToken)
+	}
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// verifySignature supports the algorithms Kubernetes signs tokens with
+func verifySignature(alg string, key crypto.PublicKey, digest, signature []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, signature); err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return fmt.Errorf("key type does not match %s", alg)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// hasAudience reports whether "aud" names one of audiences. It accepts both
+// the string and the array form of "aud".
+func hasAudience(raw json.RawMessage, audiences []string) bool {
+	var list []string
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		list = []string{single}
+	} else if json.Unmarshal(raw, &list) != nil {
+		return false
+	}
+	for _, a := range list {
+		for _, want := range audiences {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
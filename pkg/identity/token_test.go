@@ -0,0 +1,337 @@
+package identity
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testIssuer = "https://kubernetes.default.svc"
+
+// signToken builds a JWT signed with key
+func signToken(t *testing.T, key *rsa.PrivateKey, header, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	signed := segment(header) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// serveJWKS publishes the public halves of keys, by key ID
+func serveJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		var set struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		for kid, key := range keys {
+			set.Keys = append(set.Keys, jsonWebKey{
+				Kty: "RSA",
+				Kid: kid,
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &fetches
+}
+
+func TestJWKSVerifyToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := serveJWKS(t, map[string]*rsa.PrivateKey{"k1": key})
+	v, err := NewJWKSVerifier(srv.URL, testIssuer, []string{"semamesh", "vault"}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	claims := func(change func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": testIssuer,
+			"sub": "system:serviceaccount:finance:billing-agent",
+			"aud": []string{"semamesh"},
+			"exp": now.Add(time.Hour).Unix(),
+			"nbf": now.Add(-time.Minute).Unix(),
+			"kubernetes.io": map[string]interface{}{
+				"namespace": "finance",
+				"pod":       map[string]string{"name": "billing-agent-7d9f"},
+			},
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	rs256 := map[string]interface{}{"alg": "RS256", "kid": "k1"}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			token: signToken(t, key, rs256, claims(nil)),
+		},
+		{
+			name:  "string audience, second of the accepted ones",
+			token: signToken(t, key, rs256, claims(func(c map[string]interface{}) { c["aud"] = "vault" })),
+		},
+		{
+			name:    "wrong alg",
+			token:   signToken(t, key, map[string]interface{}{"alg": "HS256", "kid": "k1"}, claims(nil)),
+			wantErr: "unsupported token algorithm",
+		},
+		{
+			name:    "alg none",
+			token:   signToken(t, key, map[string]interface{}{"alg": "none", "kid": "k1"}, claims(nil)),
+			wantErr: "unsupported token algorithm",
+		},
+		{
+			name:    "alg not matching the key",
+			token:   signToken(t, key, map[string]interface{}{"alg": "ES256", "kid": "k1"}, claims(nil)),
+			wantErr: "key type does not match",
+		},
+		{
+			name:    "kid not in the JWKS",
+			token:   signToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "k2"}, claims(nil)),
+			wantErr: "unknown token signing key",
+		},
+		{
+			name:    "signed with another key",
+			token:   signToken(t, other, rs256, claims(nil)),
+			wantErr: "invalid token signature",
+		},
+		{
+			name:    "tampered claims",
+			token:   tamper(signToken(t, key, rs256, claims(nil)), claims(func(c map[string]interface{}) { c["sub"] = "system:serviceaccount:kube-system:admin" })),
+			wantErr: "invalid token signature",
+		},
+		{
+			name:    "expired",
+			token:   signToken(t, key, rs256, claims(func(c map[string]interface{}) { c["exp"] = now.Add(-time.Second).Unix() })),
+			wantErr: "token expired",
+		},
+		{
+			name:    "no expiry",
+			token:   signToken(t, key, rs256, claims(func(c map[string]interface{}) { delete(c, "exp") })),
+			wantErr: "token expired",
+		},
+		{
+			name:    "not valid yet",
+			token:   signToken(t, key, rs256, claims(func(c map[string]interface{}) { c["nbf"] = now.Add(time.Hour).Unix() })),
+			wantErr: "not valid yet",
+		},
+		{
+			name:    "wrong audience",
+			token:   signToken(t, key, rs256, claims(func(c map[string]interface{}) { c["aud"] = []string{"https://kubernetes.default.svc"} })),
+			wantErr: "not issued for audience",
+		},
+		{
+			name:    "wrong issuer",
+			token:   signToken(t, key, rs256, claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" })),
+			wantErr: "unexpected token issuer",
+		},
+		{
+			name:    "not a service account",
+			token:   signToken(t, key, rs256, claims(func(c map[string]interface{}) { c["sub"] = "alice" })),
+			wantErr: "does not belong to a service account",
+		},
+		{
+			name:    "malformed",
+			token:   "not.a-jwt",
+			wantErr: "malformed token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			r.Header.Set(TokenHeader, tt.token)
+			meta, ok, err := v.Verify(r)
+
+			if tt.wantErr != "" {
+				if ok || err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Verify = %v, %v, want error %q", ok, err, tt.wantErr)
+				}
+				return
+			}
+			if !ok || err != nil {
+				t.Fatalf("Verify = %v, %v, want ok", ok, err)
+			}
+			want := PodMetadata{Namespace: "finance", ServiceAccount: "billing-agent", PodName: "billing-agent-7d9f", Source: SourceToken}
+			if meta.Namespace != want.Namespace || meta.ServiceAccount != want.ServiceAccount || meta.PodName != want.PodName || meta.Source != want.Source {
+				t.Errorf("meta = %+v, want %+v", meta, want)
+			}
+		})
+	}
+
+	// Without a token, the verifier doesn't apply
+	if _, ok, err := v.Verify(httptest.NewRequest(http.MethodPost, "/", nil)); ok || err != nil {
+		t.Errorf("Verify without a token = %v, %v, want not ok and no error", ok, err)
+	}
+}
+
+// tamper replaces the claims of a signed token, keeping its signature
+func tamper(token string, claims map[string]interface{}) string {
+	parts := strings.Split(token, ".")
+	raw, _ := json.Marshal(claims)
+	parts[1] = base64.RawURLEncoding.EncodeToString(raw)
+	return strings.Join(parts, ".")
+}
+
+func TestJWKSRotation(t *testing.T) {
+	old, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]*rsa.PrivateKey{"old": old}
+	srv, fetches := serveJWKS(t, keys)
+	v, err := NewJWKSVerifier(srv.URL, testIssuer, []string{"semamesh"}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys["new"] = rotated
+
+	token := signToken(t, rotated, map[string]interface{}{"alg": "RS256", "kid": "new"}, map[string]interface{}{
+		"iss": testIssuer,
+		"sub": "system:serviceaccount:finance:billing-agent",
+		"aud": "semamesh",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	// Unknown key IDs refetch at most every jwksRetryInterval
+	if _, err := v.verifyToken(token, time.Now()); err == nil {
+		t.Fatal("token accepted before the keys were refetched")
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("%d fetches, want 1 (rate-limited)", n)
+	}
+
+	v.attemptedAt = time.Now().Add(-jwksRetryInterval)
+	if _, err := v.verifyToken(token, time.Now()); err != nil {
+		t.Errorf("token signed with the rotated key rejected: %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("%d fetches, want 2", n)
+	}
+}
+
+func TestTokenReviewCachesDefinitiveResults(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     authv1.TokenReviewStatus
+		apiErr     error
+		wantOK     bool
+		wantCached bool
+	}{
+		{
+			name: "authenticated",
+			status: authv1.TokenReviewStatus{
+				Authenticated: true,
+				User: authv1.UserInfo{
+					Username: "system:serviceaccount:finance:billing-agent",
+					Extra:    map[string]authv1.ExtraValue{"authentication.kubernetes.io/pod-name": {"billing-agent-7d9f"}},
+				},
+			},
+			wantOK:     true,
+			wantCached: true,
+		},
+		{
+			name:       "rejected",
+			status:     authv1.TokenReviewStatus{Authenticated: false, Error: "token expired"},
+			wantCached: true,
+		},
+		{
+			name:       "not a service account",
+			status:     authv1.TokenReviewStatus{Authenticated: true, User: authv1.UserInfo{Username: "alice"}},
+			wantCached: true,
+		},
+		{
+			name:   "API error",
+			apiErr: errors.New("connection refused"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			var reviews int
+			client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				reviews++
+				if tt.apiErr != nil {
+					return true, nil, tt.apiErr
+				}
+				review := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview).DeepCopy()
+				if got := review.Spec.Audiences; len(got) != 1 || got[0] != "semamesh" {
+					t.Errorf("audiences = %v, want [semamesh]", got)
+				}
+				review.Status = tt.status
+				return true, review, nil
+			})
+			v := &TokenReviewVerifier{client: client, audiences: []string{"semamesh"}, cache: make(map[string]cachedReview)}
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil).WithContext(context.Background())
+			r.Header.Set(TokenHeader, "token")
+			for i := 0; i < 2; i++ {
+				meta, ok, err := v.Verify(r)
+				if ok != tt.wantOK || (err == nil) != tt.wantOK {
+					t.Fatalf("Verify #%d = %v, %v, want ok %v", i+1, ok, err, tt.wantOK)
+				}
+				if ok && (meta.Namespace != "finance" || meta.ServiceAccount != "billing-agent" || meta.PodName != "billing-agent-7d9f") {
+					t.Errorf("meta = %+v", meta)
+				}
+			}
+
+			want := 2
+			if tt.wantCached {
+				want = 1
+			}
+			if reviews != want {
+				t.Errorf("%d token reviews for two requests, want %d", reviews, want)
+			}
+		})
+	}
+}
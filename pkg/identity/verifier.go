@@ -0,0 +1,76 @@
+package identity
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Identity sources, recorded in PodMetadata.Source
+const (
+	SourceIP     = "ip"
+	SourceSPIFFE = "spiffe"
+	SourceToken  = "token"
+)
+
+// TokenHeader carries a projected ServiceAccount token. The Authorization
+// header is left alone because it holds the provider's API key.
+const TokenHeader = "X-Sema-Token"
+
+// Verifier proves who the caller is from the request itself (a certificate
+// or a token), instead of trusting the source IP.
+type Verifier interface {
+	// Verify returns ok=false if the request carries no credential for this
+	// verifier, and an error if it carries one that doesn't check out.
+	Verify(r *http.Request) (meta PodMetadata, ok bool, err error)
+}
+
+// SPIFFEVerifier reads the caller identity from a verified mTLS client
+// certificate (X.509 SVID) with an ID like spiffe://<domain>/ns/<ns>/sa/<sa>.
+// The TLS server must be configured to verify client certs against the SPIFFE
+// trust bundle; this only maps an already-verified certificate to a workload.
+type SPIFFEVerifier struct {
+	TrustDomain string
+}
+
+// Verify implements Verifier
+func (v *SPIFFEVerifier) Verify(r *http.Request) (PodMetadata, bool, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return PodMetadata{}, false, nil
+	}
+
+	leaf := r.TLS.VerifiedChains[0][0]
+	for _, uri := range leaf.URIs {
+		if uri.Scheme != "spiffe" {
+			continue
+		}
+		if uri.Host != v.TrustDomain {
+			return PodMetadata{}, false, fmt.Errorf("SPIFFE ID %s is not in trust domain %s", uri, v.TrustDomain)
+		}
+
+		// Kubernetes SVIDs use the /ns/<namespace>/sa/<service-account> path
+		parts := strings.Split(strings.Trim(uri.Path, "/"), "/")
+		if len(parts) != 4 || parts[0] != "ns" || parts[2] != "sa" || parts[1] == "" || parts[3] == "" {
+			return PodMetadata{}, false, fmt.Errorf("SPIFFE ID %s is not a Kubernetes workload ID", uri)
+		}
+
+		return PodMetadata{
+			Namespace:      parts[1],
+			ServiceAccount: parts[3],
+			Source:         SourceSPIFFE,
+		}, true, nil
+	}
+
+	// Not an SVID (e.g. a client certificate for something else): another
+	// verifier may still identify the caller
+	return PodMetadata{}, false, nil
+}
+
+// parseServiceAccountUser splits "system:serviceaccount:<ns>:<name>"
+func parseServiceAccountUser(username string) (namespace, name string, ok bool) {
+	parts := strings.Split(username, ":")
+	if len(parts) != 4 || parts[0] != "system" || parts[1] != "serviceaccount" {
+		return "", "", false
+	}
+	return parts[2], parts[3], parts[2] != "" && parts[3] != ""
+}
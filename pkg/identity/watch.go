@@ -0,0 +1,198 @@
+package identity
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WatchOptions restrict which pods the identity watcher keeps in memory.
+// On big clusters, a cluster-wide pod cache costs more than the agents we
+// govern, so scope it down as far as the deployment allows.
+type WatchOptions struct {
+	// Namespaces to watch (empty = all namespaces)
+	Namespaces []string
+
+	// LabelSelector only keeps matching pods (e.g. "semamesh.io/agent=true")
+	LabelSelector string
+
+	// NodeName only keeps pods scheduled on this node. Use it when running as
+	// a DaemonSet where every caller is a local pod (set from NODE_NAME).
+	NodeName string
+}
+
+func (o WatchOptions) String() string {
+	var parts []string
+	if len(o.Namespaces) == 0 {
+		parts = append(parts, "all namespaces")
+	} else {
+		parts = append(parts, "namespaces="+strings.Join(o.Namespaces, ","))
+	}
+	if o.LabelSelector != "" {
+		parts = append(parts, "labels="+o.LabelSelector)
+	}
+	if o.NodeName != "" {
+		parts = append(parts, "node="+o.NodeName)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Validate checks the selectors before we hand them to the API server
+func (o WatchOptions) Validate() error {
+	if o.LabelSelector != "" {
+		if _, err := labels.Parse(o.LabelSelector); err != nil {
+			return fmt.Errorf("invalid label selector: %v", err)
+		}
+	}
+	return nil
+}
+
+var (
+	replicaSetsResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}
+	jobsResource        = schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+)
+
+// watch groups the informer factories created for one set of WatchOptions
+type watch struct {
+	podFactories   []informers.SharedInformerFactory
+	ownerFactories []metadatainformer.SharedInformerFactory
+	podInformers   []cache.SharedIndexInformer
+}
+
+// newWatch builds one pod factory (and one owner factory) per namespace.
+// Pods are trimmed to the fields we use before they enter the cache, and
+// ReplicaSets/Jobs are watched metadata-only since we only need their owners.
+func (m *Manager) newWatch(clientset kubernetes.Interface, metaClient metadata.Interface, opts WatchOptions) *watch {
+	namespaces := opts.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	tweakPods := func(lo *metav1.ListOptions) {
+		lo.LabelSelector = opts.LabelSelector
+		if opts.NodeName != "" {
+			lo.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", opts.NodeName).String()
+		}
+	}
+
+	w := &watch{}
+	owners := &ownerResolver{
+		replicaSets: make(map[string]cache.GenericLister),
+		jobs:        make(map[string]cache.GenericLister),
+	}
+
+	for _, ns := range namespaces {
+		// Resync every 10 minutes
+		podFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 10*time.Minute,
+			informers.WithNamespace(ns),
+			informers.WithTweakListOptions(tweakPods),
+			informers.WithTransform(m.trimPod),
+		)
+		w.podInformers = append(w.podInformers, podFactory.Core().V1().Pods().Informer())
+		w.podFactories = append(w.podFactories, podFactory)
+
+		ownerFactory := metadatainformer.NewFilteredSharedInformerFactory(metaClient, 10*time.Minute, ns, nil)
+		owners.replicaSets[ns] = ownerFactory.ForResource(replicaSetsResource).Lister()
+		owners.jobs[ns] = ownerFactory.ForResource(jobsResource).Lister()
+		w.ownerFactories = append(w.ownerFactories, ownerFactory)
+	}
+
+	m.mutex.Lock()
+	m.owners = owners
+	m.mutex.Unlock()
+
+	return w
+}
+
+// start runs every factory and waits for the initial sync.
+// Owners sync first so new pods resolve their workload from the cache.
+func (w *watch) start(stopper <-chan struct{}) error {
+	for _, f := range w.ownerFactories {
+		f.Start(stopper)
+	}
+	for _, f := range w.ownerFactories {
+		for resource, synced := range f.WaitForCacheSync(stopper) {
+			if !synced {
+				return fmt.Errorf("timed out waiting for %v cache to sync", resource)
+			}
+		}
+	}
+
+	for _, f := range w.podFactories {
+		f.Start(stopper)
+	}
+	for _, f := range w.podFactories {
+		for informerType, synced := range f.WaitForCacheSync(stopper) {
+			if !synced {
+				return fmt.Errorf("timed out waiting for %v cache to sync", informerType)
+			}
+		}
+	}
+	return nil
+}
+
+// trimPod drops everything the identity cache doesn't need (container specs,
+// managed fields, volumes, statuses...) before the pod is stored.
+// Only the kept annotations and promoted keys survive.
+func (m *Manager) trimPod(obj interface{}) (interface{}, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		// Tombstones and anything else pass through untouched
+		return obj, nil
+	}
+
+	m.mutex.RLock()
+	keep := make(map[string]bool, len(m.annotationKeys)+len(m.promotions))
+	for _, key := range m.annotationKeys {
+		keep[key] = true
+	}
+	for _, p := range m.promotions {
+		keep[p.Key] = true
+	}
+	m.mutex.RUnlock()
+
+	var annotations map[string]string
+	for k, v := range pod.Annotations {
+		if keep[k] {
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			annotations[k] = v
+		}
+	}
+
+	return &corev1.Pod{
+		TypeMeta: pod.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              pod.Name,
+			Namespace:         pod.Namespace,
+			UID:               pod.UID,
+			ResourceVersion:   pod.ResourceVersion,
+			Labels:            pod.Labels,
+			Annotations:       annotations,
+			OwnerReferences:   pod.OwnerReferences,
+			DeletionTimestamp: pod.DeletionTimestamp,
+		},
+		Spec: corev1.PodSpec{
+			NodeName:           pod.Spec.NodeName,
+			ServiceAccountName: pod.Spec.ServiceAccountName,
+			HostNetwork:        pod.Spec.HostNetwork,
+		},
+		Status: corev1.PodStatus{
+			Phase:  pod.Status.Phase,
+			PodIP:  pod.Status.PodIP,
+			PodIPs: pod.Status.PodIPs,
+		},
+	}, nil
+}
@@ -0,0 +1,165 @@
+package identity
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultAnnotationKeys are the pod annotations kept for chargeback
+var DefaultAnnotationKeys = []string{"semamesh.io/team", "semamesh.io/cost-center"}
+
+// reservedLabelNames are the labels the per-workload metrics already have
+var reservedLabelNames = map[string]bool{
+	"type": true, "model": true, "namespace": true, "workload_kind": true, "workload": true,
+}
+
+// PromotedLabel copies a pod label or annotation into metrics and audit
+// fields under a short, Prometheus-safe name (e.g. team <- semamesh.io/team).
+type PromotedLabel struct {
+	Name string
+	Key  string
+}
+
+// ParsePromotedLabels parses "name=key,name=key". A bare "key" is promoted
+// under its own name with the prefix dropped and '.', '-', '/' turned into '_'.
+// Names must be valid Prometheus label names and not clash with the labels
+// the metrics already have.
+func ParsePromotedLabels(spec string) ([]PromotedLabel, error) {
+	var result []PromotedLabel
+	seen := make(map[string]bool)
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		var p PromotedLabel
+		if name, key, ok := strings.Cut(item, "="); ok {
+			p = PromotedLabel{Name: strings.TrimSpace(name), Key: strings.TrimSpace(key)}
+		} else {
+			p = PromotedLabel{Name: sanitizeLabelName(item), Key: item}
+		}
+
+		if p.Name == "" || p.Key == "" {
+			return nil, fmt.Errorf("invalid promoted label %q", item)
+		}
+		if !model.LegacyValidation.IsValidLabelName(p.Name) {
+			return nil, fmt.Errorf("promoted label %q: %q is not a valid label name", item, p.Name)
+		}
+		if reservedLabelNames[p.Name] {
+			return nil, fmt.Errorf("promoted label %q: %q is reserved", item, p.Name)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("promoted label %q defined twice", p.Name)
+		}
+		seen[p.Name] = true
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// PromotedNames returns the promoted names, in order
+func PromotedNames(promotions []PromotedLabel) []string {
+	names := make([]string, 0, len(promotions))
+	for _, p := range promotions {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// valueFrom prefers the annotation (explicit ownership) over the label
+func (p PromotedLabel) valueFrom(labels, annotations map[string]string) string {
+	if v, ok := annotations[p.Key]; ok {
+		return v
+	}
+	return labels[p.Key]
+}
+
+func sanitizeLabelName(key string) string {
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		key = key[i+1:]
+	}
+	return strings.NewReplacer(".", "_", "-", "_").Replace(key)
+}
+
+// SetAnnotationKeys chooses which pod annotations are kept in PodMetadata
+func (m *Manager) SetAnnotationKeys(keys []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.annotationKeys = keys
+}
+
+// SetPromotedLabels chooses which labels/annotations are promoted.
+// Must be called before StartWatcher.
+func (m *Manager) SetPromotedLabels(promotions []PromotedLabel) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.promotions = promotions
+}
+
+// ownerResolver walks owner references up to the top-level workload.
+// Listers are metadata-only and keyed by watched namespace ("" = all).
+type ownerResolver struct {
+	replicaSets map[string]cache.GenericLister
+	jobs        map[string]cache.GenericLister
+}
+
+// resolve returns the kind and name of the pod's top-level owner.
+// Deployment -> ReplicaSet -> Pod and CronJob -> Job -> Pod are followed;
+// anything else (StatefulSet, DaemonSet...) is the controller itself.
+func (o *ownerResolver) resolve(pod *corev1.Pod) (string, string) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return "", ""
+	}
+
+	switch ref.Kind {
+	case "ReplicaSet":
+		if parent, found := controllerOf(o.replicaSets, pod.Namespace, ref.Name); found {
+			if parent != nil {
+				return parent.Kind, parent.Name
+			}
+			return ref.Kind, ref.Name
+		}
+		// Not in the cache yet: Deployments name their ReplicaSets "<name>-<pod-template-hash>"
+		if hash := pod.Labels["pod-template-hash"]; hash != "" && strings.HasSuffix(ref.Name, "-"+hash) {
+			return "Deployment", strings.TrimSuffix(ref.Name, "-"+hash)
+		}
+
+	case "Job":
+		if parent, _ := controllerOf(o.jobs, pod.Namespace, ref.Name); parent != nil {
+			return parent.Kind, parent.Name
+		}
+	}
+
+	return ref.Kind, ref.Name
+}
+
+// controllerOf looks up an object in the cache and returns its controller.
+// found is false if the object isn't cached.
+func controllerOf(listers map[string]cache.GenericLister, namespace, name string) (*metav1.OwnerReference, bool) {
+	lister, ok := listers[namespace]
+	if !ok {
+		lister, ok = listers[metav1.NamespaceAll]
+	}
+	if !ok {
+		return nil, false
+	}
+
+	obj, err := lister.ByNamespace(namespace).Get(name)
+	if err != nil {
+		return nil, false
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, false
+	}
+	return metav1.GetControllerOfNoCopy(accessor), true
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Snapshot suffixes providers append to model names
+var snapshotSuffix = regexp.MustCompile(`(` +
+	`-\d{4}-\d{2}-\d{2}` + // gpt-4o-2024-08-06
+	`|[-@]20\d{6}` + // claude-3-5-sonnet-20241022, claude-3-5-sonnet@20241022
+	`|-(0[1-9]|1[0-2])\d{2}` + // gpt-4-0613, gpt-3.5-turbo-0125
+	`)$`)
+
+// NormalizeModel folds snapshot names into their model family, e.g.
+// gpt-4o-2024-08-06 -> gpt-4o and claude-3-5-sonnet-20241022 -> claude-3-5-sonnet
+func NormalizeModel(model string) string {
+	model = strings.ToLower(strings.TrimSpace(model))
+	return snapshotSuffix.ReplaceAllString(model, "")
+}
+
+// ParseModelAliases parses "from=to,from=to"
+func ParseModelAliases(spec string) (map[string]string, bool) {
+	aliases := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(item, "=")
+		if !ok || from == "" || to == "" {
+			return nil, false
+		}
+		aliases[strings.ToLower(strings.TrimSpace(from))] = strings.TrimSpace(to)
+	}
+	return aliases, true
+}
@@ -1,32 +1,300 @@
 package metrics
 
 import (
-    "github.com/prometheus/client_golang/prometheus"
-    "github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
-    TokenCounter = promauto.NewCounterVec(
-       prometheus.CounterOpts{
-          Name: "semamesh_llm_tokens_total",
-          Help: "Total number of LLM tokens processed by SemaMesh",
-       },
-       []string{"type", "model", "namespace"},
-    )
-
-    CostCounter = promauto.NewCounterVec(
-       prometheus.CounterOpts{
-          Name: "semamesh_llm_cost_est_total",
-          Help: "Estimated cost of LLM traffic in USD",
-       },
-       []string{"model", "namespace"},
-    )
-
-    RequestsTotal = promauto.NewCounterVec(
-           prometheus.CounterOpts{
-              Name: "semamesh_http_requests_total",
-              Help: "Total number of HTTP requests proxied",
-           },
-           []string{"namespace", "status"},
-     )
-)
\ No newline at end of file
+	TokenCounter = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_llm_tokens_total",
+			Help: "Total number of LLM tokens processed by SemaMesh",
+		},
+		[]string{"type", "model", "namespace"},
+	)
+
+	CostCounter = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_llm_cost_est_total",
+			Help: "Estimated cost of LLM traffic in USD",
+		},
+		[]string{"model", "namespace"},
+	)
+
+	RequestsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_http_requests_total",
+			Help: "Total number of HTTP requests proxied",
+		},
+		[]string{"namespace", "status"},
+	)
+
+	TransparentConnections = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_transparent_connections_total",
+			Help: "Connections redirected by the eBPF interceptor, by provider (from the TLS SNI) and mode",
+		},
+		[]string{"provider", "mode"},
+	)
+
+	BypassedConnections = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_bypassed_connections_total",
+			Help: "Connections from fail-open pods that skipped inspection, by reason (waypoint_unhealthy, overloaded)",
+		},
+		[]string{"namespace", "reason"},
+	)
+
+	WaypointHealthy = fixedMetric(prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "semamesh_waypoint_healthy",
+			Help: "1 while the node's transparent proxy answers health checks, 0 while fail-open pods bypass it",
+		},
+	))
+)
+
+// Latency and error metrics of LLM calls, to tell provider slowness from ours.
+// model is the model the client requested ("unknown" if we couldn't read it).
+var (
+	UpstreamDuration = newHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "semamesh_llm_upstream_duration_seconds",
+			Help:    "Time from sending an LLM request upstream to the end of its response",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+		},
+		[]string{"namespace", "model", "provider"},
+	)
+
+	TimeToFirstToken = newHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "semamesh_llm_time_to_first_token_seconds",
+			Help:    "Time from sending a streaming LLM request upstream to its first response bytes",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16},
+		},
+		[]string{"namespace", "model", "provider"},
+	)
+
+	OutputTokensPerSecond = newHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "semamesh_llm_output_tokens_per_second",
+			Help:    "Completion tokens per second of generation (after the first token when streaming)",
+			Buckets: []float64{5, 10, 20, 40, 80, 160, 320},
+		},
+		[]string{"namespace", "model", "provider"},
+	)
+
+	RequestSize = newHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "semamesh_llm_request_size_bytes",
+			Help:    "Size of LLM request bodies",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+		},
+		[]string{"namespace", "model", "provider"},
+	)
+
+	ResponseSize = newHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "semamesh_llm_response_size_bytes",
+			Help:    "Size of LLM response bodies",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+		},
+		[]string{"namespace", "model", "provider"},
+	)
+
+	InFlightRequests = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "semamesh_llm_inflight_requests",
+			Help: "LLM requests currently waiting on or streaming from the provider",
+		},
+		[]string{"namespace", "model", "provider"},
+	)
+
+	Errors = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_llm_errors_total",
+			Help: "Failed LLM requests, by reason (see the Reason* constants)",
+		},
+		[]string{"namespace", "model", "provider", "reason"},
+	)
+)
+
+// Governance outcomes, for the security dashboards
+var (
+	PolicyEvaluations = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_policy_evaluations_total",
+			Help: "Requests evaluated against a policy",
+		},
+		[]string{"policy"},
+	)
+
+	PolicyDecisions = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_policy_decisions_total",
+			Help: "Policy decisions, by the matching rule (\"none\" when no rule matched), its risk level and action",
+		},
+		[]string{"policy", "rule", "risk_level", "action"},
+	)
+)
+
+// SemaCostBudget state. Every node reports the cluster-wide spend as of its
+// last sync, so aggregate with max().
+var (
+	BudgetSpent = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "semamesh_budget_spent_usd",
+			Help: "Spend in the current period of a SemaCostBudget, over all nodes",
+		},
+		[]string{"namespace", "budget"},
+	)
+
+	BudgetLimit = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "semamesh_budget_limit_usd",
+			Help: "Limit of a SemaCostBudget per period",
+		},
+		[]string{"namespace", "budget"},
+	)
+
+	BudgetForecast = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "semamesh_budget_forecast_usd",
+			Help: "Spend a SemaCostBudget is expected to reach by the end of the period, at the current burn rate",
+		},
+		[]string{"namespace", "budget"},
+	)
+)
+
+// Notification deliveries, per configured channel (see pkg/notify)
+var (
+	NotificationsSent = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_notifications_sent_total",
+			Help: "Notifications delivered, by channel and channel type",
+		},
+		[]string{"channel", "type"},
+	)
+
+	NotificationsFailed = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_notifications_failed_total",
+			Help: "Notifications that could not be delivered after all retries, by channel and channel type",
+		},
+		[]string{"channel", "type"},
+	)
+
+	NotificationsDropped = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_notifications_dropped_total",
+			Help: "Notifications not sent on purpose, by channel and reason (duplicate, rate_limited, queue_full)",
+		},
+		[]string{"channel", "reason"},
+	)
+)
+
+// Model routing (see pkg/routing)
+var RoutingDecisions = newCounterVec(
+	prometheus.CounterOpts{
+		Name: "semamesh_routing_decisions_total",
+		Help: "Requests a routing rule sent to another model or upstream, by the model asked for and the model used",
+	},
+	[]string{"namespace", "rule", "requested_model", "model"},
+)
+
+// Response cache (see pkg/cache). Hit rate is (hit + semantic_hit) / all but bypass.
+var (
+	CacheLookups = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_cache_lookups_total",
+			Help: "Response cache lookups, by result (hit, semantic_hit, miss, bypass)",
+		},
+		[]string{"namespace", "result"},
+	)
+
+	CacheSaved = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_cache_saved_usd_total",
+			Help: "Estimated cost of the provider calls answered from the response cache, in USD",
+		},
+		[]string{"namespace", "model"},
+	)
+
+	CacheEntries = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "semamesh_cache_entries",
+			Help: "Responses held in the in-memory cache",
+		},
+		[]string{"namespace"},
+	)
+
+	CacheSize = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "semamesh_cache_size_bytes",
+			Help: "Size of the responses held in the in-memory cache",
+		},
+		[]string{"namespace"},
+	)
+
+	CacheEmbeddingFailures = fixedMetric(prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "semamesh_cache_embedding_failures_total",
+			Help: "Prompts the semantic cache could not embed (looked up as misses)",
+		},
+	))
+)
+
+// Error reasons of semamesh_llm_errors_total
+const (
+	ReasonUpstreamTimeout     = "upstream_timeout"
+	ReasonUpstreamUnreachable = "upstream_unreachable"
+	ReasonProvider429         = "provider_429"
+	ReasonProvider5xx         = "provider_5xx"
+	ReasonPolicyBlock         = "policy_block"
+	ReasonQuotaBlock          = "quota_block"
+	ReasonBudgetBlock         = "budget_block"
+)
+
+// LabelOverflow counts label values replaced by OtherValue: raise
+// --metrics-max-label-values or drop the label if it keeps growing
+var LabelOverflow = fixedMetric(prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "semamesh_metrics_label_overflow_total",
+		Help: "Label values reported as \"other\" because the label reached its cap of distinct values",
+	},
+	[]string{"metric", "label"},
+))
+
+// Per-workload metrics for chargeback. Their label set depends on the promoted
+// pod labels, so they are created by InitWorkloadMetrics instead of at startup.
+// They count the same tokens and cost as TokenCounter and CostCounter, which
+// are kept as they are for existing dashboards: sum one family or the other,
+// never both.
+var (
+	WorkloadTokenCounter *CounterVec
+	WorkloadCostCounter  *CounterVec
+
+	// PromotedLabels are the extra label names, in the order values are passed
+	PromotedLabels []string
+)
+
+// InitWorkloadMetrics registers the per-workload metrics with the promoted
+// labels appended to the base label set. Call it once, at startup.
+func InitWorkloadMetrics(promoted []string) {
+	PromotedLabels = promoted
+
+	WorkloadTokenCounter = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_llm_workload_tokens_total",
+			Help: "Total number of LLM tokens processed by SemaMesh, per workload",
+		},
+		append([]string{"type", "model", "namespace", "workload_kind", "workload"}, promoted...),
+	)
+
+	WorkloadCostCounter = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "semamesh_llm_workload_cost_est_total",
+			Help: "Estimated cost of LLM traffic in USD, per workload",
+		},
+		append([]string{"model", "namespace", "workload_kind", "workload"}, promoted...),
+	)
+}
@@ -0,0 +1,272 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// OtherValue replaces label values beyond Config.MaxLabelValues
+const OtherValue = "other"
+
+// DefaultMaxLabelValues bounds the distinct values of each label of each metric
+const DefaultMaxLabelValues = 250
+
+// Registry holds every SemaMesh metric; serve it with Handler. Configure
+// replaces it.
+var Registry = prometheus.NewRegistry()
+
+// fixed are registered as they are, whatever the Config
+var fixed []prometheus.Collector
+
+var (
+	_ = fixedMetric(collectors.NewGoCollector())
+	_ = fixedMetric(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+)
+
+func fixedMetric[C prometheus.Collector](c C) C {
+	mu.Lock()
+	defer mu.Unlock()
+	fixed = append(fixed, c)
+	Registry.MustRegister(c)
+	return c
+}
+
+// Handler serves Registry in the Prometheus exposition format. Get it after
+// Configure.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Config controls the labels of SemaMesh metrics, to keep series in check
+type Config struct {
+	// DropLabels are not exported: "model" drops it from every metric,
+	// "semamesh_llm_tokens_total:namespace" from one metric only
+	DropLabels []string
+	// MaxLabelValues caps the distinct values of each label of each metric
+	// (0 = unlimited); later values are reported as OtherValue
+	MaxLabelValues int
+	// NormalizeModels strips snapshot dates from model names (see NormalizeModel)
+	NormalizeModels bool
+	// ModelAliases rename models, after normalization (e.g. "gpt-4o-mini" -> "gpt-4o")
+	ModelAliases map[string]string
+}
+
+// DefaultConfig exports every label, normalizes models and caps label values
+func DefaultConfig() Config {
+	return Config{MaxLabelValues: DefaultMaxLabelValues, NormalizeModels: true}
+}
+
+var (
+	mu       sync.Mutex
+	config   = DefaultConfig()
+	families []family
+)
+
+// family is a labelled metric whose label set depends on the Config
+type family interface {
+	rebuild()
+}
+
+// Configure applies cfg to every metric, in a new Registry. Call it once at
+// startup, before serving traffic: labelled metrics are re-created and lose
+// what they recorded.
+func Configure(cfg Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	config = cfg
+	// A registry remembers the label names of unregistered metrics: start over
+	Registry = prometheus.NewRegistry()
+	Registry.MustRegister(fixed...)
+	for _, f := range families {
+		f.rebuild()
+	}
+}
+
+// labeler maps the values callers pass, one per label the metric declares,
+// to the values of the labels actually exported
+type labeler struct {
+	metric string
+	names  []string
+
+	kept   []int
+	limits []*limiter
+}
+
+func (l *labeler) configure(cfg Config) []string {
+	drop := make(map[string]bool)
+	for _, d := range cfg.DropLabels {
+		if metric, label, ok := strings.Cut(d, ":"); ok {
+			if metric == l.metric {
+				drop[label] = true
+			}
+		} else {
+			drop[d] = true
+		}
+	}
+
+	var exported []string
+	l.kept, l.limits = nil, nil
+	for i, name := range l.names {
+		if drop[name] {
+			continue
+		}
+		exported = append(exported, name)
+		l.kept = append(l.kept, i)
+		l.limits = append(l.limits, newLimiter(l.metric, name, cfg.MaxLabelValues))
+	}
+	return exported
+}
+
+func (l *labeler) values(lvs []string) []string {
+	out := make([]string, len(l.kept))
+	for i, idx := range l.kept {
+		v := ""
+		if idx < len(lvs) {
+			v = lvs[idx]
+		}
+		if name := l.names[idx]; name == "model" || strings.HasSuffix(name, "_model") {
+			v = modelName(v)
+		}
+		out[i] = l.limits[i].admit(v)
+	}
+	return out
+}
+
+// modelName applies the configured normalization and aliases
+func modelName(model string) string {
+	mu.Lock()
+	cfg := config
+	mu.Unlock()
+
+	if cfg.NormalizeModels {
+		model = NormalizeModel(model)
+	}
+	if alias, ok := cfg.ModelAliases[model]; ok {
+		return alias
+	}
+	return model
+}
+
+// limiter admits the first max distinct values of a label
+type limiter struct {
+	metric, label string
+	max           int
+
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+func newLimiter(metric, label string, max int) *limiter {
+	return &limiter{metric: metric, label: label, max: max, seen: make(map[string]struct{})}
+}
+
+func (l *limiter) admit(v string) string {
+	if l.max <= 0 {
+		return v
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[v]; ok {
+		return v
+	}
+	if len(l.seen) >= l.max {
+		LabelOverflow.WithLabelValues(l.metric, l.label).Inc()
+		return OtherValue
+	}
+	l.seen[v] = struct{}{}
+	return v
+}
+
+// CounterVec is a prometheus.CounterVec whose labels follow the Config
+type CounterVec struct {
+	opts prometheus.CounterOpts
+	labeler
+	vec *prometheus.CounterVec
+}
+
+func newCounterVec(opts prometheus.CounterOpts, labels []string) *CounterVec {
+	v := &CounterVec{opts: opts, labeler: labeler{metric: opts.Name, names: labels}}
+	// Called directly (not through family) so package initialization
+	// orders config before the metrics
+	mu.Lock()
+	defer mu.Unlock()
+	v.rebuild()
+	families = append(families, v)
+	return v
+}
+
+func (v *CounterVec) rebuild() {
+	v.vec = prometheus.NewCounterVec(v.opts, v.configure(config))
+	Registry.MustRegister(v.vec)
+}
+
+// WithLabelValues takes a value for every declared label, dropped or not
+func (v *CounterVec) WithLabelValues(lvs ...string) prometheus.Counter {
+	return v.vec.WithLabelValues(v.values(lvs)...)
+}
+
+// GaugeVec is a prometheus.GaugeVec whose labels follow the Config
+type GaugeVec struct {
+	opts prometheus.GaugeOpts
+	labeler
+	vec *prometheus.GaugeVec
+}
+
+func newGaugeVec(opts prometheus.GaugeOpts, labels []string) *GaugeVec {
+	v := &GaugeVec{opts: opts, labeler: labeler{metric: opts.Name, names: labels}}
+	// Called directly (not through family) so package initialization
+	// orders config before the metrics
+	mu.Lock()
+	defer mu.Unlock()
+	v.rebuild()
+	families = append(families, v)
+	return v
+}
+
+func (v *GaugeVec) rebuild() {
+	v.vec = prometheus.NewGaugeVec(v.opts, v.configure(config))
+	Registry.MustRegister(v.vec)
+}
+
+// WithLabelValues takes a value for every declared label, dropped or not
+func (v *GaugeVec) WithLabelValues(lvs ...string) prometheus.Gauge {
+	return v.vec.WithLabelValues(v.values(lvs)...)
+}
+
+// DeleteLabelValues removes the series, e.g. of an object that is gone
+func (v *GaugeVec) DeleteLabelValues(lvs ...string) bool {
+	return v.vec.DeleteLabelValues(v.values(lvs)...)
+}
+
+// HistogramVec is a prometheus.HistogramVec whose labels follow the Config
+type HistogramVec struct {
+	opts prometheus.HistogramOpts
+	labeler
+	vec *prometheus.HistogramVec
+}
+
+func newHistogramVec(opts prometheus.HistogramOpts, labels []string) *HistogramVec {
+	v := &HistogramVec{opts: opts, labeler: labeler{metric: opts.Name, names: labels}}
+	// Called directly (not through family) so package initialization
+	// orders config before the metrics
+	mu.Lock()
+	defer mu.Unlock()
+	v.rebuild()
+	families = append(families, v)
+	return v
+}
+
+func (v *HistogramVec) rebuild() {
+	v.vec = prometheus.NewHistogramVec(v.opts, v.configure(config))
+	Registry.MustRegister(v.vec)
+}
+
+// WithLabelValues takes a value for every declared label, dropped or not
+func (v *HistogramVec) WithLabelValues(lvs ...string) prometheus.Observer {
+	return v.vec.WithLabelValues(v.values(lvs)...)
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLabelerDropLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		drop     []string
+		exported []string
+		values   []string
+	}{
+		{
+			name:     "nothing dropped",
+			exported: []string{"type", "model", "namespace"},
+			values:   []string{"prompt", "gpt-4o", "finance"},
+		},
+		{
+			name:     "dropped everywhere",
+			drop:     []string{"namespace"},
+			exported: []string{"type", "model"},
+			values:   []string{"prompt", "gpt-4o"},
+		},
+		{
+			name:     "dropped from this metric",
+			drop:     []string{"test_tokens_total:type"},
+			exported: []string{"model", "namespace"},
+			values:   []string{"gpt-4o", "finance"},
+		},
+		{
+			name:     "dropped from another metric",
+			drop:     []string{"other_metric:type"},
+			exported: []string{"type", "model", "namespace"},
+			values:   []string{"prompt", "gpt-4o", "finance"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &labeler{metric: "test_tokens_total", names: []string{"type", "model", "namespace"}}
+			exported := l.configure(Config{DropLabels: tt.drop})
+			if !reflect.DeepEqual(exported, tt.exported) {
+				t.Errorf("exported labels = %v, want %v", exported, tt.exported)
+			}
+			// Callers always pass every declared label
+			if got := l.values([]string{"prompt", "gpt-4o", "finance"}); !reflect.DeepEqual(got, tt.values) {
+				t.Errorf("values = %v, want %v", got, tt.values)
+			}
+		})
+	}
+}
+
+func TestLabelerNormalizesModels(t *testing.T) {
+	l := &labeler{metric: "test_cost_total", names: []string{"model", "requested_model", "namespace"}}
+	l.configure(DefaultConfig())
+
+	got := l.values([]string{"gpt-4o-2024-08-06", "claude-3-5-sonnet-20241022", "team-2024-08-06"})
+	want := []string{"gpt-4o", "claude-3-5-sonnet", "team-2024-08-06"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+}
+
+func TestLimiterOverflow(t *testing.T) {
+	l := newLimiter("test_overflow_total", "namespace", 2)
+	overflow := LabelOverflow.WithLabelValues("test_overflow_total", "namespace")
+
+	for _, tt := range []struct {
+		value, want string
+	}{
+		{"a", "a"},
+		{"b", "b"},
+		{"c", OtherValue},
+		// Values seen before the cap keep their series
+		{"a", "a"},
+		{"d", OtherValue},
+	} {
+		if got := l.admit(tt.value); got != tt.want {
+			t.Errorf("admit(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+	if got := testutil.ToFloat64(overflow); got != 2 {
+		t.Errorf("overflow count = %v, want 2", got)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := newLimiter("test_unlimited_total", "namespace", 0)
+	for _, v := range []string{"a", "b", "c"} {
+		if got := l.admit(v); got != v {
+			t.Errorf("admit(%q) = %q, want it unchanged", v, got)
+		}
+	}
+}
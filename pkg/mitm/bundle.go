@@ -0,0 +1,145 @@
+package mitm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// InterceptLabel opts a namespace in: its pods' TLS traffic to providers
+	// is intercepted, and they get the CA bundle to trust it
+	InterceptLabel = "semamesh.io/tls-intercept"
+	// InterceptEnabled is the label value that opts in
+	InterceptEnabled = "enabled"
+
+	// BundleConfigMap is created in every opted-in namespace. Mount it and
+	// point SSL_CERT_FILE / NODE_EXTRA_CA_CERTS / REQUESTS_CA_BUNDLE at it.
+	BundleConfigMap = "semamesh-ca-bundle"
+	// BundleKey is the ConfigMap key holding the PEM bundle
+	BundleKey = "ca.crt"
+)
+
+// OptIn tracks which namespaces opted in to TLS interception
+type OptIn struct {
+	client kubernetes.Interface
+	lister corelisters.NamespaceLister
+	synced cache.InformerSynced
+	bundle []byte
+}
+
+// WatchNamespaces starts watching namespaces labelled InterceptLabel=enabled,
+// until stopCh closes
+func WatchNamespaces(clientset kubernetes.Interface, stopCh <-chan struct{}) (*OptIn, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 10*time.Minute,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = InterceptLabel + "=" + InterceptEnabled
+		}),
+	)
+	nsInformer := factory.Core().V1().Namespaces()
+	o := &OptIn{
+		client: clientset,
+		lister: nsInformer.Lister(),
+		synced: nsInformer.Informer().HasSynced,
+	}
+
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, o.synced) {
+		return nil, fmt.Errorf("failed to sync namespace cache")
+	}
+	return o, nil
+}
+
+// Enabled reports whether pods in namespace opted in to interception
+func (o *OptIn) Enabled(namespace string) bool {
+	if o == nil || namespace == "" {
+		return false
+	}
+	_, err := o.lister.Get(namespace)
+	return err == nil
+}
+
+// SyncBundle keeps the BundleConfigMap of every opted-in namespace up to
+// date with bundle. Enable it on a single replica, not on every node agent.
+func (o *OptIn) SyncBundle(bundle []byte, stopCh <-chan struct{}) {
+	o.bundle = bundle
+
+	syncAll := func() {
+		namespaces, err := o.lister.List(labels.Everything())
+		if err != nil {
+			log.Printf("CA_BUNDLE: Failed to list namespaces: %v", err)
+			return
+		}
+		for _, ns := range namespaces {
+			if err := o.ensureBundle(ns.Name); err != nil {
+				log.Printf("CA_BUNDLE: Failed to sync %s/%s: %v", ns.Name, BundleConfigMap, err)
+			}
+		}
+	}
+
+	// Namespaces opting in are picked up within a minute, and anyone
+	// editing the ConfigMap gets reverted
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			syncAll()
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (o *OptIn) ensureBundle(namespace string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cms := o.client.CoreV1().ConfigMaps(namespace)
+	existing, err := cms.Get(ctx, BundleConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      BundleConfigMap,
+				Namespace: namespace,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "semamesh"},
+			},
+			Data: map[string]string{BundleKey: string(o.bundle)},
+		}
+		if _, err := cms.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		log.Printf("CA_BUNDLE: Created %s/%s", namespace, BundleConfigMap)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal([]byte(existing.Data[BundleKey]), o.bundle) {
+		return nil
+	}
+	updated := existing.DeepCopy()
+	if updated.Data == nil {
+		updated.Data = map[string]string{}
+	}
+	updated.Data[BundleKey] = string(o.bundle)
+	if _, err := cms.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	log.Printf("CA_BUNDLE: Updated %s/%s", namespace, BundleConfigMap)
+	return nil
+}
@@ -0,0 +1,174 @@
+// Package mitm terminates transparently redirected TLS connections with
+// certificates minted from a cluster-local CA, so the sniffer can read
+// traffic from agents we can't reconfigure.
+package mitm
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultLeafLifetime keeps minted certificates short-lived: a leaked leaf
+	// key is only useful for a few hours
+	DefaultLeafLifetime = 6 * time.Hour
+	// maxCachedLeaves bounds the cache; we only mint for a handful of providers
+	maxCachedLeaves = 1024
+)
+
+// CA mints leaf certificates for intercepted server names
+type CA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+
+	// LeafLifetime of minted certificates
+	LeafLifetime time.Duration
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// LoadCA parses a PEM certificate and private key (PKCS#1, PKCS#8 or EC)
+func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA key pair: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA certificate: %v", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %q is not a CA", cert.Subject.CommonName)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA key type %T", pair.PrivateKey)
+	}
+
+	return &CA{
+		cert:         cert,
+		key:          key,
+		LeafLifetime: DefaultLeafLifetime,
+		leaves:       make(map[string]*tls.Certificate),
+	}, nil
+}
+
+// LoadCAFromSecret reads the CA from a kubernetes.io/tls Secret (tls.crt, tls.key)
+func LoadCAFromSecret(clientset kubernetes.Interface, namespace, name string) (*CA, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA secret %s/%s: %v", namespace, name, err)
+	}
+	return LoadCA(secret.Data["tls.crt"], secret.Data["tls.key"])
+}
+
+// BundlePEM returns the CA certificate clients must trust
+func (ca *CA) BundlePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// TLSConfig returns a server config minting a certificate for each SNI
+func (ca *CA) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The transparent listener speaks HTTP/1.1 only
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return ca.Certificate(hello.ServerName)
+		},
+	}
+}
+
+// Certificate returns a cached leaf for serverName, minting a new one when
+// there is none or it's past two thirds of its lifetime
+func (ca *CA) Certificate(serverName string) (*tls.Certificate, error) {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if serverName == "" {
+		return nil, fmt.Errorf("no SNI in ClientHello: can't pick a certificate")
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	now := time.Now()
+	if leaf, ok := ca.leaves[serverName]; ok {
+		renewAt := leaf.Leaf.NotAfter.Add(-ca.LeafLifetime / 3)
+		if now.Before(renewAt) {
+			return leaf, nil
+		}
+	}
+
+	leaf, err := ca.mint(serverName, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(ca.leaves) >= maxCachedLeaves {
+		ca.leaves = make(map[string]*tls.Certificate)
+	}
+	ca.leaves[serverName] = leaf
+	return leaf, nil
+}
+
+func (ca *CA) mint(serverName string, now time.Time) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	notAfter := now.Add(ca.LeafLifetime)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: serverName, Organization: []string{"SemaMesh"}},
+		// Allow for clock skew between nodes and pods
+		NotBefore:   now.Add(-5 * time.Minute),
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(serverName); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{serverName}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mint certificate for %s: %v", serverName, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// chatSender posts to Slack or Microsoft Teams incoming webhooks
+type chatSender struct {
+	url    string
+	teams  bool
+	client *http.Client
+}
+
+var severityEmoji = map[Severity]string{
+	SeverityInfo:     "ℹ️",
+	SeverityWarning:  "⚠️",
+	SeverityCritical: "🚨",
+}
+
+// Teams card colors
+var severityColor = map[Severity]string{
+	SeverityInfo:     "2E77D0",
+	SeverityWarning:  "FFA500",
+	SeverityCritical: "D40000",
+}
+
+func (s *chatSender) send(ctx context.Context, n Notification) error {
+	var payload interface{}
+	if s.teams {
+		facts := make([]map[string]string, 0, len(n.Fields))
+		for _, line := range strings.Split(strings.TrimSpace(n.details()), "\n") {
+			if k, v, ok := strings.Cut(line, ": "); ok {
+				facts = append(facts, map[string]string{"name": k, "value": v})
+			}
+		}
+		card := map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    n.Title,
+			"themeColor": severityColor[n.Severity],
+			"title":      n.Title,
+			"text":       n.Text,
+			"sections":   []map[string]interface{}{{"facts": facts}},
+		}
+		if len(n.Actions) > 0 {
+			var actions []map[string]interface{}
+			for _, a := range n.Actions {
+				actions = append(actions, map[string]interface{}{
+					"@type":   "OpenUri",
+					"name":    a.Label,
+					"targets": []map[string]string{{"os": "default", "uri": a.URL}},
+				})
+			}
+			card["potentialAction"] = actions
+		}
+		payload = card
+	} else {
+		text := fmt.Sprintf("%s *%s*", severityEmoji[n.Severity], n.Title)
+		if n.Text != "" {
+			text += "\n" + n.Text
+		}
+		for _, line := range strings.Split(strings.TrimSpace(n.details()), "\n") {
+			if k, v, ok := strings.Cut(line, ": "); ok {
+				text += fmt.Sprintf("\n*%s:* %s", k, v)
+			}
+		}
+		msg := map[string]interface{}{"text": text}
+		if len(n.Actions) > 0 {
+			// Buttons open the link; with a Slack app, clicks also come
+			// back to its interactivity URL with the Value
+			var buttons []map[string]interface{}
+			for _, a := range n.Actions {
+				button := map[string]interface{}{
+					"type":      "button",
+					"action_id": a.ID,
+					"text":      map[string]string{"type": "plain_text", "text": a.Label},
+					"url":       a.URL,
+				}
+				if a.Value != "" {
+					button["value"] = a.Value
+				}
+				if a.Style != "" {
+					button["style"] = a.Style
+				}
+				buttons = append(buttons, button)
+			}
+			msg["blocks"] = []map[string]interface{}{
+				{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": text}},
+				{"type": "actions", "elements": buttons},
+			}
+		}
+		payload = msg
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return permanent{err}
+	}
+	return post(ctx, s.client, s.url, body, nil)
+}
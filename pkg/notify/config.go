@@ -0,0 +1,261 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Channel types
+const (
+	TypeSlack     = "slack"
+	TypeTeams     = "teams"
+	TypeWebhook   = "webhook"
+	TypePagerDuty = "pagerduty"
+	TypeEmail     = "email"
+)
+
+// Delivery defaults of a channel
+const (
+	DefaultRetries      = 3
+	DefaultDedupWindow  = 5 * time.Minute
+	DefaultMaxPerMinute = 30
+
+	queueSize = 100
+)
+
+// File is the format of the --notify-config YAML file:
+//
+//	channels:
+//	  - name: oncall
+//	    type: pagerduty
+//	    routingKey: ${PAGERDUTY_ROUTING_KEY}
+//	  - name: platform
+//	    type: slack
+//	    url: ${SLACK_WEBHOOK_URL}
+//	    dedupWindow: 10m
+//	  - name: siem
+//	    type: webhook
+//	    url: https://siem.example.com/hooks/semamesh
+//	    secret: ${SIEM_HMAC_KEY}
+//	  - name: finops
+//	    type: email
+//	    smtp: {host: smtp.example.com, port: 587, username: semamesh, password: "${SMTP_PASSWORD}"}
+//	    from: semamesh@example.com
+//	    to: [finops@example.com]
+//	routes:
+//	  - minSeverity: critical
+//	    channels: [oncall]
+//	    continue: true
+//	  - sources: [budget]
+//	    channels: [finops]
+//	  - channels: [platform]
+//
+// Routes are tried in order and the first match wins, unless it says
+// continue. ${VAR} references in urls, keys and SMTP credentials are read
+// from the environment, so secrets can come from a mounted Secret.
+type File struct {
+	Channels []ChannelConfig `json:"channels"`
+	Routes   []RouteConfig   `json:"routes"`
+}
+
+// ChannelConfig is a destination and how to deliver to it
+type ChannelConfig struct {
+	Name string `json:"name"`
+	// Type is slack, teams, webhook, pagerduty or email
+	Type string `json:"type"`
+
+	// URL to POST to (slack, teams, webhook; overrides the PagerDuty Events API)
+	URL string `json:"url,omitempty"`
+	// Secret signs webhook bodies with HMAC-SHA256
+	Secret string `json:"secret,omitempty"`
+	// RoutingKey is the PagerDuty integration key
+	RoutingKey string `json:"routingKey,omitempty"`
+
+	// Email settings
+	SMTP *SMTPConfig `json:"smtp,omitempty"`
+	From string      `json:"from,omitempty"`
+	To   []string    `json:"to,omitempty"`
+
+	// Templates (text/template over the Notification) for the title and text
+	TitleTemplate string `json:"titleTemplate,omitempty"`
+	TextTemplate  string `json:"textTemplate,omitempty"`
+
+	// Retries after a failed delivery (default 3)
+	Retries *int `json:"retries,omitempty"`
+	// DedupWindow drops repeats of a notification for this long (default 5m, 0s disables)
+	DedupWindow *metav1.Duration `json:"dedupWindow,omitempty"`
+	// MaxPerMinute caps deliveries (default 30, 0 for unlimited)
+	MaxPerMinute *int `json:"maxPerMinute,omitempty"`
+}
+
+// SMTPConfig is the mail server of an email channel
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// TLS connects with implicit TLS (port 465). Otherwise STARTTLS is used
+	// when the server offers it.
+	TLS bool `json:"tls,omitempty"`
+}
+
+// RouteConfig sends matching notifications to channels. Empty matchers
+// match everything; namespaces and rules are globs.
+type RouteConfig struct {
+	Namespaces  []string `json:"namespaces,omitempty"`
+	Rules       []string `json:"rules,omitempty"`
+	Sources     []string `json:"sources,omitempty"`
+	MinSeverity Severity `json:"minSeverity,omitempty"`
+	Channels    []string `json:"channels"`
+	Continue    bool     `json:"continue,omitempty"`
+}
+
+// Setup enables notifications from the config file at path or, without one,
+// posts everything to $SLACK_WEBHOOK_URL like earlier releases. Without
+// either, notifications stay off.
+func Setup(path string) error {
+	var f File
+	switch {
+	case path != "":
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read notification config: %v", err)
+		}
+		if err := yaml.UnmarshalStrict(raw, &f); err != nil {
+			return fmt.Errorf("invalid notification config: %v", err)
+		}
+	case os.Getenv("SLACK_WEBHOOK_URL") != "":
+		f = File{
+			Channels: []ChannelConfig{{Name: "slack", Type: TypeSlack, URL: "${SLACK_WEBHOOK_URL}"}},
+			Routes:   []RouteConfig{{Channels: []string{"slack"}}},
+		}
+	default:
+		return nil
+	}
+
+	d, err := New(f)
+	if err != nil {
+		return err
+	}
+	Init(d)
+	return nil
+}
+
+// New builds a dispatcher from a config file
+func New(f File) (*Dispatcher, error) {
+	d := &Dispatcher{channels: make(map[string]*channel)}
+
+	for i, cfg := range f.Channels {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("channel #%d: name is required", i+1)
+		}
+		if _, dup := d.channels[cfg.Name]; dup {
+			return nil, fmt.Errorf("channel %q is defined twice", cfg.Name)
+		}
+		ch, err := newChannel(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("channel %q: %v", cfg.Name, err)
+		}
+		d.channels[cfg.Name] = ch
+	}
+
+	for i, cfg := range f.Routes {
+		if len(cfg.Channels) == 0 {
+			return nil, fmt.Errorf("route #%d: channels are required", i+1)
+		}
+		for _, name := range cfg.Channels {
+			if _, ok := d.channels[name]; !ok {
+				return nil, fmt.Errorf("route #%d: unknown channel %q", i+1, name)
+			}
+		}
+		if cfg.MinSeverity != "" && cfg.MinSeverity.rank() == 0 {
+			return nil, fmt.Errorf("route #%d: invalid minSeverity %q (info|warning|critical)", i+1, cfg.MinSeverity)
+		}
+		d.routes = append(d.routes, route{
+			namespaces:  cfg.Namespaces,
+			rules:       cfg.Rules,
+			sources:     cfg.Sources,
+			minSeverity: cfg.MinSeverity,
+			channels:    cfg.Channels,
+			cont:        cfg.Continue,
+		})
+	}
+	return d, nil
+}
+
+func newChannel(cfg ChannelConfig) (*channel, error) {
+	ch := &channel{
+		name:     cfg.Name,
+		kind:     cfg.Type,
+		retries:  DefaultRetries,
+		dedup:    DefaultDedupWindow,
+		perMin:   DefaultMaxPerMinute,
+		queue:    make(chan Notification, queueSize),
+		lastSent: make(map[string]time.Time),
+	}
+	if cfg.Retries != nil {
+		ch.retries = *cfg.Retries
+	}
+	if cfg.DedupWindow != nil {
+		ch.dedup = cfg.DedupWindow.Duration
+	}
+	if cfg.MaxPerMinute != nil {
+		ch.perMin = *cfg.MaxPerMinute
+	}
+
+	var err error
+	if cfg.TitleTemplate != "" {
+		if ch.title, err = template.New("title").Parse(cfg.TitleTemplate); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.TextTemplate != "" {
+		if ch.text, err = template.New("text").Parse(cfg.TextTemplate); err != nil {
+			return nil, err
+		}
+	}
+
+	url := os.ExpandEnv(cfg.URL)
+	switch cfg.Type {
+	case TypeSlack, TypeTeams:
+		if url == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		ch.sender = &chatSender{url: url, teams: cfg.Type == TypeTeams, client: httpClient()}
+	case TypeWebhook:
+		if url == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		ch.sender = &webhookSender{url: url, secret: []byte(os.ExpandEnv(cfg.Secret)), client: httpClient()}
+	case TypePagerDuty:
+		key := os.ExpandEnv(cfg.RoutingKey)
+		if key == "" {
+			return nil, fmt.Errorf("routingKey is required")
+		}
+		if url == "" {
+			url = pagerDutyEventsURL
+		}
+		ch.sender = &pagerDutySender{url: url, routingKey: key, client: httpClient()}
+	case TypeEmail:
+		if cfg.SMTP == nil || cfg.SMTP.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+			return nil, fmt.Errorf("smtp.host, from and to are required")
+		}
+		smtp := *cfg.SMTP
+		smtp.Username, smtp.Password = os.ExpandEnv(smtp.Username), os.ExpandEnv(smtp.Password)
+		if smtp.Port == 0 {
+			smtp.Port = 587
+			if smtp.TLS {
+				smtp.Port = 465
+			}
+		}
+		ch.sender = &emailSender{smtp: smtp, from: cfg.From, to: cfg.To}
+	default:
+		return nil, fmt.Errorf("unknown type %q (slack|teams|webhook|pagerduty|email)", cfg.Type)
+	}
+	return ch, nil
+}
@@ -0,0 +1,345 @@
+// Package notify delivers alerts (policy violations, budget thresholds...) to
+// Slack, Microsoft Teams, signed webhooks, PagerDuty and email, routed by
+// namespace, severity and rule
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/semamesh/SemaMesh/pkg/metrics"
+)
+
+// Severity of a notification
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+func (s Severity) rank() int {
+	switch s {
+	case SeverityInfo:
+		return 1
+	case SeverityWarning:
+		return 2
+	case SeverityCritical:
+		return 3
+	}
+	return 0
+}
+
+// sendTimeout bounds a single delivery attempt
+const sendTimeout = 10 * time.Second
+
+// Notification is an event someone should hear about
+type Notification struct {
+	Severity Severity `json:"severity"`
+	// Source is the subsystem that raised it (policy, budget...)
+	Source string `json:"source"`
+	// Rule that fired: a policy rule, a budget name...
+	Rule      string `json:"rule,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// Agent is the workload that caused it, when there is one
+	Agent string `json:"agent,omitempty"`
+
+	Title string `json:"title"`
+	Text  string `json:"text"`
+	// Fields are extra details, rendered as "key: value"
+	Fields map[string]string `json:"fields,omitempty"`
+
+	// DedupKey identifies repeats of the same event (default: source,
+	// namespace, agent, rule and title)
+	DedupKey string    `json:"dedupKey"`
+	Time     time.Time `json:"time"`
+
+	// Actions offered with the notification, e.g. approve/reject
+	Actions []Action `json:"actions,omitempty"`
+
+	// Channels, if set, bypass the routes (e.g. PauseSettings.Notify)
+	Channels []string `json:"-"`
+}
+
+// Action is a button (Slack, Teams) or link (email, PagerDuty, webhook)
+type Action struct {
+	// ID tells interactive callbacks which action was picked
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	URL   string `json:"url"`
+	// Value is sent back by interactive channels (Slack buttons)
+	Value string `json:"value,omitempty"`
+	// Style is "primary" or "danger"
+	Style string `json:"style,omitempty"`
+}
+
+// details renders Fields as sorted "key: value" lines
+func (n Notification) details() string {
+	keys := make([]string, 0, len(n.Fields))
+	for k := range n.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", k, n.Fields[k])
+	}
+	return b.String()
+}
+
+// Dispatcher routes notifications to channels. Every channel has its own
+// queue, so a slow mail server doesn't hold up the pager.
+type Dispatcher struct {
+	routes   []route
+	channels map[string]*channel
+}
+
+type route struct {
+	namespaces  []string
+	rules       []string
+	sources     []string
+	minSeverity Severity
+	channels    []string
+	cont        bool
+}
+
+// sender is a channel backend
+type sender interface {
+	send(ctx context.Context, n Notification) error
+}
+
+// permanent marks errors that retrying won't fix (e.g. a 4xx answer)
+type permanent struct{ error }
+
+func (p permanent) Unwrap() error { return p.error }
+
+// channel is a configured destination, with its own delivery policy
+type channel struct {
+	name    string
+	kind    string
+	sender  sender
+	title   *template.Template
+	text    *template.Template
+	retries int
+	dedup   time.Duration
+	perMin  int
+	queue   chan Notification
+
+	mu          sync.Mutex
+	lastSent    map[string]time.Time
+	windowStart time.Time
+	inWindow    int
+}
+
+var std *Dispatcher
+
+// Init makes d the dispatcher used by Send, and starts its workers. Call it
+// once, at startup.
+func Init(d *Dispatcher) {
+	for _, ch := range d.channels {
+		go ch.run()
+	}
+	std = d
+	log.Printf("📣 Notifications enabled (%d channels, %d routes)", len(d.channels), len(d.routes))
+}
+
+// Enabled reports whether notifications are configured
+func Enabled() bool {
+	return std != nil
+}
+
+// Send queues n for delivery (non-blocking). It is a no-op until Init.
+func Send(n Notification) {
+	if std != nil {
+		std.Dispatch(n)
+	}
+}
+
+// Dispatch queues n on the channels it is routed to
+func (d *Dispatcher) Dispatch(n Notification) {
+	if n.Severity == "" {
+		n.Severity = SeverityWarning
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	if n.DedupKey == "" {
+		n.DedupKey = strings.Join([]string{n.Source, n.Namespace, n.Agent, n.Rule, n.Title}, "/")
+	}
+
+	targets := n.Channels
+	if len(targets) == 0 {
+		for _, r := range d.routes {
+			if !r.matches(n) {
+				continue
+			}
+			targets = append(targets, r.channels...)
+			if !r.cont {
+				break
+			}
+		}
+	}
+
+	queued := make(map[string]bool, len(targets))
+	for _, name := range targets {
+		ch, ok := d.channels[name]
+		if !ok {
+			log.Printf("NOTIFY: unknown channel %q for %q", name, n.Title)
+			continue
+		}
+		if !queued[name] {
+			queued[name] = true
+			ch.enqueue(n)
+		}
+	}
+}
+
+func (r *route) matches(n Notification) bool {
+	return n.Severity.rank() >= r.minSeverity.rank() &&
+		matchAny(r.namespaces, n.Namespace) &&
+		matchAny(r.rules, n.Rule) &&
+		matchAny(r.sources, n.Source)
+}
+
+// matchAny matches v against glob patterns; no patterns match everything
+func matchAny(patterns []string, v string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue applies dedup and rate limits, then queues n
+func (c *channel) enqueue(n Notification) {
+	now := time.Now()
+
+	c.mu.Lock()
+	if c.duplicate(n.DedupKey, now) {
+		c.mu.Unlock()
+		metrics.NotificationsDropped.WithLabelValues(c.name, "duplicate").Inc()
+		return
+	}
+	if c.perMin > 0 {
+		if now.Sub(c.windowStart) >= time.Minute {
+			c.windowStart, c.inWindow = now, 0
+		}
+		if c.inWindow >= c.perMin {
+			c.mu.Unlock()
+			metrics.NotificationsDropped.WithLabelValues(c.name, "rate_limited").Inc()
+			return
+		}
+		c.inWindow++
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.queue <- n:
+	default:
+		metrics.NotificationsDropped.WithLabelValues(c.name, "queue_full").Inc()
+		log.Printf("NOTIFY: %s queue full, dropping %q", c.name, n.Title)
+	}
+}
+
+// duplicate reports whether key was delivered within the dedup window.
+// The caller holds c.mu.
+func (c *channel) duplicate(key string, now time.Time) bool {
+	last, ok := c.lastSent[key]
+	return ok && c.dedup > 0 && now.Sub(last) < c.dedup
+}
+
+// sent starts the dedup window of key. Only deliveries count, so a failed
+// one doesn't suppress the retries of the next occurrences.
+func (c *channel) sent(key string) {
+	if c.dedup <= 0 {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSent[key] = now
+	// Forget keys that can't suppress anything anymore
+	if len(c.lastSent) > 1024 {
+		for k, t := range c.lastSent {
+			if now.Sub(t) >= c.dedup {
+				delete(c.lastSent, k)
+			}
+		}
+	}
+}
+
+// run delivers queued notifications, retrying with backoff
+func (c *channel) run() {
+	for n := range c.queue {
+		// Copies queued while the first was being delivered
+		c.mu.Lock()
+		dup := c.duplicate(n.DedupKey, time.Now())
+		c.mu.Unlock()
+		if dup {
+			metrics.NotificationsDropped.WithLabelValues(c.name, "duplicate").Inc()
+			continue
+		}
+
+		msg, err := c.render(n)
+		if err == nil {
+			err = c.deliver(msg)
+		}
+		if err != nil {
+			metrics.NotificationsFailed.WithLabelValues(c.name, c.kind).Inc()
+			log.Printf("NOTIFY: failed to deliver %q to %s: %v", n.Title, c.name, err)
+			continue
+		}
+		c.sent(n.DedupKey)
+		metrics.NotificationsSent.WithLabelValues(c.name, c.kind).Inc()
+	}
+}
+
+func (c *channel) deliver(n Notification) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := c.sender.send(ctx, n)
+		cancel()
+
+		var perm permanent
+		if err == nil || errors.As(err, &perm) || attempt >= c.retries {
+			return err
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+	}
+}
+
+// render applies the channel's templates to the title and text
+func (c *channel) render(n Notification) (Notification, error) {
+	var b strings.Builder
+	if c.title != nil {
+		if err := c.title.Execute(&b, n); err != nil {
+			return n, fmt.Errorf("title template: %v", err)
+		}
+		n.Title = b.String()
+		b.Reset()
+	}
+	if c.text != nil {
+		if err := c.text.Execute(&b, n); err != nil {
+			return n, fmt.Errorf("text template: %v", err)
+		}
+		n.Text = b.String()
+	}
+	return n, nil
+}
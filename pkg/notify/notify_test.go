@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/semamesh/SemaMesh/pkg/metrics"
+)
+
+// receiver collects the notifications posted to a webhook channel
+type receiver struct {
+	mu       sync.Mutex
+	received []Notification
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var n Notification
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rc.mu.Lock()
+	rc.received = append(rc.received, n)
+	rc.mu.Unlock()
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.received)
+}
+
+// startDispatcher routes everything to a webhook channel named name, posting
+// to rc, with the default delivery policy
+func startDispatcher(t *testing.T, name string, rc *receiver) *Dispatcher {
+	t.Helper()
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+
+	d, err := New(File{
+		Channels: []ChannelConfig{{Name: name, Type: TypeWebhook, URL: srv.URL}},
+		Routes:   []RouteConfig{{Channels: []string{name}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range d.channels {
+		go ch.run()
+		t.Cleanup(func() { close(ch.queue) })
+	}
+	return d
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// violation is what the waypoint sends when agent trips rule
+func violation(namespace, agent, rule string) Notification {
+	return Notification{
+		Severity:  SeverityCritical,
+		Source:    "policy",
+		Rule:      rule,
+		Namespace: namespace,
+		Agent:     agent,
+		Title:     "SemaMesh Security Alert",
+		Text:      "Rule " + rule + " matched a request from " + agent + ": PAUSE.",
+	}
+}
+
+func TestDedupPerSource(t *testing.T) {
+	rc := &receiver{}
+	d := startDispatcher(t, "dedup-per-source", rc)
+	duplicates := metrics.NotificationsDropped.WithLabelValues("dedup-per-source", "duplicate")
+
+	// Two agents trip the same rule within the dedup window: both alerts go out
+	d.Dispatch(violation("finance", "10.244.1.5", "no-deletes"))
+	waitFor(t, "agent A's alert", func() bool { return rc.count() == 1 })
+	d.Dispatch(violation("finance", "10.244.2.7", "no-deletes"))
+	waitFor(t, "agent B's alert", func() bool { return rc.count() == 2 })
+
+	// The same agent again is a repeat
+	d.Dispatch(violation("finance", "10.244.1.5", "no-deletes"))
+	waitFor(t, "the repeat to be dropped", func() bool { return testutil.ToFloat64(duplicates) == 1 })
+
+	// The same agent name in another namespace is another agent
+	d.Dispatch(violation("payments", "10.244.1.5", "no-deletes"))
+	waitFor(t, "the other namespace's alert", func() bool { return rc.count() == 3 })
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for i, want := range []string{"10.244.1.5", "10.244.2.7", "10.244.1.5"} {
+		if got := rc.received[i].Agent; got != want {
+			t.Errorf("alert #%d from %q, want %q", i+1, got, want)
+		}
+	}
+}
+
+func TestDefaultDedupKey(t *testing.T) {
+	rc := &receiver{}
+	d := startDispatcher(t, "default-dedup-key", rc)
+	duplicates := metrics.NotificationsDropped.WithLabelValues("default-dedup-key", "duplicate")
+
+	n := Notification{Source: "budget", Namespace: "finance", Rule: "monthly", Title: "Budget at 80%"}
+	d.Dispatch(n)
+	waitFor(t, "the first alert", func() bool { return rc.count() == 1 })
+	rc.mu.Lock()
+	key := rc.received[0].DedupKey
+	rc.mu.Unlock()
+	if key != "budget/finance//monthly/Budget at 80%" {
+		t.Errorf("default dedup key = %q", key)
+	}
+
+	d.Dispatch(n)
+	waitFor(t, "the repeat to be dropped", func() bool { return testutil.ToFloat64(duplicates) == 1 })
+
+	n.Namespace = "payments"
+	d.Dispatch(n)
+	waitFor(t, "the other namespace's alert", func() bool { return rc.count() == 2 })
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutySender triggers PagerDuty incidents (Events API v2). Repeats of
+// an event share its dedup key, so they land on the open incident.
+type pagerDutySender struct {
+	url        string
+	routingKey string
+	client     *http.Client
+}
+
+// PagerDuty severities: critical, error, warning, info
+var pagerDutySeverity = map[Severity]string{
+	SeverityInfo:     "info",
+	SeverityWarning:  "warning",
+	SeverityCritical: "critical",
+}
+
+func (s *pagerDutySender) send(ctx context.Context, n Notification) error {
+	source, _ := os.Hostname()
+	if node := os.Getenv("NODE_NAME"); node != "" {
+		source = node
+	}
+
+	details := map[string]string{"text": n.Text}
+	for k, v := range n.Fields {
+		details[k] = v
+	}
+	var links []map[string]string
+	for _, a := range n.Actions {
+		links = append(links, map[string]string{"href": a.URL, "text": a.Label})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"links":        links,
+		"routing_key":  s.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    n.DedupKey,
+		"payload": map[string]interface{}{
+			"summary":        n.Title,
+			"source":         source,
+			"severity":       pagerDutySeverity[n.Severity],
+			"timestamp":      n.Time.UTC().Format(time.RFC3339),
+			"component":      n.Namespace,
+			"group":          n.Source,
+			"class":          n.Rule,
+			"custom_details": details,
+		},
+	})
+	if err != nil {
+		return permanent{err}
+	}
+	return post(ctx, s.client, s.url, body, nil)
+}
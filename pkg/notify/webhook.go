@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of signed webhook deliveries. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)): receivers
+// recompute it and reject stale timestamps to stop replays.
+const (
+	SignatureHeader = "X-SemaMesh-Signature"
+	TimestampHeader = "X-SemaMesh-Timestamp"
+)
+
+// webhookSender posts the notification as JSON
+type webhookSender struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func (s *webhookSender) send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return permanent{err}
+	}
+
+	header := http.Header{}
+	if len(s.secret) > 0 {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		header.Set(TimestampHeader, ts)
+		header.Set(SignatureHeader, "sha256="+Sign(s.secret, ts, body))
+	}
+	return post(ctx, s.client, s.url, body, header)
+}
+
+// Sign computes the hex HMAC of a webhook delivery, for receivers to compare
+// (with hmac.Equal) against SignatureHeader
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
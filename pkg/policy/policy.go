@@ -0,0 +1,155 @@
+// Package policy reads the SemaPolicies that apply to a workload, for the
+// parts of the proxy that follow their rules (e.g. the response cache)
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/semamesh/SemaMesh/api/v1alpha1"
+	"github.com/semamesh/SemaMesh/pkg/identity"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+// Resource is the SemaPolicy API resource
+var Resource = schema.GroupVersionResource{Group: "semamesh.io", Version: "v1alpha1", Resource: "semapolicies"}
+
+// Store holds SemaPolicies, watched in the cluster or loaded from a file
+type Store struct {
+	// Watched policies
+	lister  cache.GenericLister
+	synced  cache.InformerSynced
+	factory dynamicinformer.DynamicSharedInformerFactory
+
+	// Loaded policies
+	static []v1alpha1.SemaPolicy
+}
+
+// NewWatcher watches the SemaPolicies of all namespaces
+func NewWatcher(kubeconfigPath string) (*Store, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kubeconfig: %v", err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create k8s client: %v", err)
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 10*time.Minute)
+	informer := factory.ForResource(Resource)
+	return &Store{
+		lister:  informer.Lister(),
+		synced:  informer.Informer().HasSynced,
+		factory: factory,
+	}, nil
+}
+
+// Run starts watching and waits for the first list
+func (s *Store) Run(stopCh <-chan struct{}) error {
+	if s.factory == nil {
+		return nil
+	}
+	s.factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, s.synced) {
+		return fmt.Errorf("failed to sync SemaPolicy cache")
+	}
+	return nil
+}
+
+// Load reads SemaPolicy manifests (separated by ---) from a file, for dev
+// mode. Policies without a namespace apply to every namespace.
+func Load(path string) (*Store, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policies: %v", err)
+	}
+	s := &Store{}
+	for i, doc := range bytes.Split(raw, []byte("\n---")) {
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		var p v1alpha1.SemaPolicy
+		if err := yaml.Unmarshal(doc, &p); err != nil {
+			return nil, fmt.Errorf("invalid policy #%d: %v", i+1, err)
+		}
+		s.static = append(s.static, p)
+	}
+	return s, nil
+}
+
+// policies returns the policies selecting a workload, by name
+func (s *Store) policies(meta identity.PodMetadata) []v1alpha1.SemaPolicy {
+	var candidates []v1alpha1.SemaPolicy
+	if s.lister != nil {
+		objs, err := s.lister.ByNamespace(meta.Namespace).List(labels.Everything())
+		if err != nil {
+			return nil
+		}
+		for _, obj := range objs {
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			var p v1alpha1.SemaPolicy
+			if runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &p) == nil {
+				candidates = append(candidates, p)
+			}
+		}
+	}
+	for _, p := range s.static {
+		if p.Namespace == "" || p.Namespace == meta.Namespace {
+			candidates = append(candidates, p)
+		}
+	}
+
+	var selected []v1alpha1.SemaPolicy
+	for _, p := range candidates {
+		selector, err := metav1.LabelSelectorAsSelector(&p.Spec.Selector)
+		if err == nil && selector.Matches(labels.Set(meta.Labels)) {
+			selected = append(selected, p)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+	return selected
+}
+
+// Match returns the first rule matching the prompt, in the policies
+// selecting the workload (in name order), like the waypoint evaluates them
+func (s *Store) Match(meta identity.PodMetadata, prompt string) (policy string, rule *v1alpha1.PolicyRule) {
+	prompt = strings.ToLower(prompt)
+	for _, p := range s.policies(meta) {
+		for i := range p.Spec.Rules {
+			for _, match := range p.Spec.Rules[i].IntentMatches {
+				if strings.Contains(prompt, strings.ToLower(match)) {
+					return p.Name, &p.Spec.Rules[i]
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+// SemanticReuse tells whether the rule matching the prompt allows answering
+// it with the response to a similar prompt
+func (s *Store) SemanticReuse(meta identity.PodMetadata, prompt string) (rule string, ok bool) {
+	policy, r := s.Match(meta, prompt)
+	if r == nil || !r.SemanticCache {
+		return "", false
+	}
+	return policy + "/" + r.Name, true
+}
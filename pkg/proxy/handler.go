@@ -2,21 +2,56 @@ package proxy
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/semamesh/SemaMesh/pkg/audit"
+	"github.com/semamesh/SemaMesh/pkg/budget"
+	"github.com/semamesh/SemaMesh/pkg/cache"
 	"github.com/semamesh/SemaMesh/pkg/identity"
+	"github.com/semamesh/SemaMesh/pkg/metrics"
+	"github.com/semamesh/SemaMesh/pkg/routing"
 	"github.com/semamesh/SemaMesh/pkg/sniffer"
+	"github.com/semamesh/SemaMesh/pkg/tracing"
+	"github.com/semamesh/SemaMesh/pkg/translate"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SemaHandler struct {
-	target        *url.URL
-	client        *http.Client
-	identityMgr   *identity.Manager
+	target      *url.URL
+	client      *http.Client
+	dialer      *net.Dialer
+	identityMgr *identity.Manager
+
+	// Strong identity (see UseVerifiers)
+	verifiers       []identity.Verifier
+	requireVerified bool
+
+	// Proxies whose forwarding headers we believe (see UseTrustedProxies)
+	clientIPs *identity.ClientIPResolver
+
+	// Cost budgets charged and enforced (see UseBudgets)
+	budgets *budget.Tracker
+
+	// Responses answered without the provider (see UseCache)
+	cache *cache.Cache
+
+	// Model and upstream rewrites (see UseRouter)
+	router *routing.Router
+
+	// Models served by other APIs (see UseTranslator)
+	translator *translate.Translator
 }
 
 func NewSemaHandler(targetURL string, idMgr *identity.Manager) (*SemaHandler, error) {
@@ -25,13 +60,19 @@ func NewSemaHandler(targetURL string, idMgr *identity.Manager) (*SemaHandler, er
 		return nil, err
 	}
 
-	return &SemaHandler{
+	h := &SemaHandler{
 		target:      u,
 		identityMgr: idMgr,
-		client: &http.Client{
-			Timeout: 60 * time.Second,
-		},
-	}, nil
+		dialer:      &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = h.dialContext
+	h.client = &http.Client{
+		Timeout:   60 * time.Second,
+		Transport: transport,
+	}
+	return h, nil
 }
 
 func (h *SemaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -46,34 +87,301 @@ func (h *SemaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	r.Body = io.NopCloser(bytes.NewBuffer(reqBodyBytes))
 
 	// 2. Prepare Upstream Request
-	outReq, _ := http.NewRequestWithContext(r.Context(), r.Method, h.target.String()+r.URL.Path, bytes.NewBuffer(reqBodyBytes))
-	for k, v := range r.Header {
-		outReq.Header[k] = v
+	target := h.target
+	if tc, ok := TransparentFromContext(r.Context()); ok {
+		// Redirected by the eBPF interceptor: go where the client was going
+		// (dialContext connects to the original destination IP)
+		target = &url.URL{Scheme: "http", Host: r.Host}
+		if tc.Intercepted {
+			target.Scheme = "https"
+		}
+		if target.Host == "" {
+			target.Host = tc.OriginalDst.String()
+		}
 	}
-	outReq.Host = h.target.Host
+	provider := sniffer.ProviderForHost(target.Host)
+	model, stream := sniffer.ParseRequest(reqBodyBytes)
 
 	// 3. Resolve Identity
-	hostIP, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		hostIP = r.RemoteAddr
+	ctx := r.Context()
+	_, policySpan := tracing.Tracer().Start(ctx, "semamesh.policy")
+	meta, ok, reason := h.resolveIdentity(r)
+	if !ok {
+		policySpan.SetAttributes(tracing.DecisionKey.String("DENY"))
+		policySpan.SetStatus(codes.Error, reason)
+		policySpan.End()
+		metrics.Errors.WithLabelValues("unknown", model, provider, metrics.ReasonPolicyBlock).Inc()
+		log.Printf("IDENTITY_REJECTED: %s from %s", reason, r.RemoteAddr)
+		http.Error(w, "SemaMesh: Workload identity required", http.StatusUnauthorized)
+		return
 	}
-	meta, found := h.identityMgr.GetIdentity(hostIP)
-	namespace := "unknown-source"
-	if found {
-		namespace = meta.Namespace
+	identityAttrs := []attribute.KeyValue{tracing.NamespaceKey.String(meta.Namespace), tracing.PodKey.String(meta.PodName)}
+	policySpan.SetAttributes(append(identityAttrs, tracing.DecisionKey.String("ALLOW"))...)
+	policySpan.End()
+	trace.SpanFromContext(ctx).SetAttributes(identityAttrs...)
+
+	// 3b. Hard-stop cost budgets
+	if h.budgets != nil {
+		if name, blocked := h.budgets.Blocked(meta); blocked {
+			metrics.Errors.WithLabelValues(meta.Namespace, model, provider, metrics.ReasonBudgetBlock).Inc()
+			log.Printf("BUDGET_EXHAUSTED: %s/%s blocked by budget %s", meta.Namespace, meta.PodName, name)
+			audit.Submit(audit.LogEntry{
+				Timestamp:      time.Now(),
+				Namespace:      meta.Namespace,
+				PodName:        meta.PodName,
+				Workload:       meta.Workload(),
+				Node:           meta.NodeName,
+				Labels:         meta.Promoted,
+				IdentitySource: meta.Source,
+				Model:          model,
+				Decision:       "DENY",
+				Reason:         "cost budget " + name + " exhausted",
+			})
+			http.Error(w, "SemaMesh: Cost Budget Exhausted", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	// 3c. Model routing
+	var requestedModel, route string
+	// Set when a rule moved the request to another host: the client's
+	// credentials are for the original one
+	var otherHost bool
+	var routeKey string
+	if h.router != nil {
+		if d, ok := h.router.Route(meta, model, reqBodyBytes, time.Now()); ok {
+			if d.Model != model {
+				body, err := routing.Rewrite(reqBodyBytes, d.Model)
+				if err != nil {
+					http.Error(w, "SemaMesh: Invalid request body", http.StatusBadRequest)
+					return
+				}
+				reqBodyBytes = body
+			}
+			if d.Target != nil {
+				otherHost = !strings.EqualFold(d.Target.Host, target.Host)
+				routeKey = d.APIKey
+				target = d.Target
+				provider = sniffer.ProviderForHost(target.Host)
+				ctx = rerouted(ctx)
+			}
+			metrics.RoutingDecisions.WithLabelValues(meta.Namespace, d.Rule, model, d.Model).Inc()
+			requestedModel, route, model = model, d.Rule, d.Model
+			trace.SpanFromContext(ctx).SetAttributes(tracing.RouteKey.String(d.Rule), tracing.RequestedModelKey.String(requestedModel))
+		}
+	}
+
+	// 3d. Provider translation: the model is an alias for another API
+	var translation *translate.Translation
+	if h.translator != nil {
+		if t, ok := h.translator.Lookup(model); ok {
+			if translation, err = t.Translate(reqBodyBytes); err != nil {
+				http.Error(w, "SemaMesh: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			target, provider = t.URL(), t.Provider()
+			ctx = rerouted(ctx)
+		}
+	}
+
+	// What the sniffer, the audit log and the cache know of the request
+	exchange := sniffer.Request{
+		Meta:           meta,
+		Body:           reqBodyBytes,
+		Provider:       provider,
+		Translated:     translation != nil,
+		Model:          model,
+		Stream:         stream,
+		Charge:         h.charge(meta),
+		RequestedModel: requestedModel,
+		Route:          route,
+	}
+
+	// 3e. Response cache
+	var cacheKey *cache.Key
+	var recorder *cache.Recorder
+	if h.cache != nil {
+		var hit *cache.Entry
+		cacheKey, hit = h.cache.Lookup(ctx, meta, provider, r, reqBodyBytes)
+		if hit != nil {
+			result := "hit"
+			if cacheKey.Similarity() > 0 {
+				result = "semantic_hit"
+			}
+			trace.SpanFromContext(ctx).SetAttributes(tracing.CacheKey.String(result))
+			h.serveCached(w, cacheKey, hit, exchange)
+			return
+		}
+		if cacheKey != nil {
+			trace.SpanFromContext(ctx).SetAttributes(tracing.CacheKey.String("miss"))
+			w.Header().Set(cache.StatusHeader, "MISS")
+			recorder = h.cache.Recorder(w)
+			w = recorder
+		}
 	}
 
 	// 4. Execute Request
+	spanName, genAIAttrs := tracing.Request(provider, r.URL.Path, reqBodyBytes)
+	ctx, upstream := tracing.Tracer().Start(ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(genAIAttrs...),
+		trace.WithAttributes(semconv.ServerAddress(target.Hostname())),
+	)
+	defer upstream.End()
+
+	var outReq *http.Request
+	if translation != nil {
+		if outReq, err = translation.Request(ctx); err != nil {
+			http.Error(w, "SemaMesh: Invalid translated request", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		outReq, _ = http.NewRequestWithContext(ctx, r.Method, target.String()+r.URL.Path, bytes.NewBuffer(reqBodyBytes))
+		for k, v := range r.Header {
+			outReq.Header[k] = v
+		}
+		// Identity headers are for us, never for the provider
+		outReq.Header.Del(identity.TokenHeader)
+		outReq.Header.Del(identity.DevIdentityHeader)
+		if otherHost || routeKey != "" {
+			setCredentials(outReq.Header, provider, routeKey)
+		}
+		outReq.Host = target.Host
+	}
+	// The provider sees our upstream span as its parent
+	tracing.Inject(ctx, outReq.Header)
+
+	labels := []string{meta.Namespace, model, provider}
+	metrics.RequestSize.WithLabelValues(labels...).Observe(float64(len(reqBodyBytes)))
+	inFlight := metrics.InFlightRequests.WithLabelValues(labels...)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	sent := time.Now()
 	resp, err := h.client.Do(outReq)
+	if err == nil && translation != nil {
+		// Back to the OpenAI format the client and the sniffer expect
+		resp, err = translation.Response(resp)
+	}
 	if err != nil {
+		reason := metrics.ReasonUpstreamUnreachable
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			reason = metrics.ReasonUpstreamTimeout
+		}
+		metrics.Errors.WithLabelValues(append(labels, reason)...).Inc()
+		upstream.RecordError(err)
+		upstream.SetStatus(codes.Error, reason)
 		http.Error(w, "SemaMesh: Upstream LLM Unreachable", http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
+	upstream.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		upstream.SetStatus(codes.Error, resp.Status)
+	}
 
 	// 5. Sniff (Pass reqBodyBytes too!)
-	err = sniffer.ProxyAndSniff(w, resp, namespace, reqBodyBytes)
+	exchange.Sent = sent
+	err = sniffer.ProxyAndSniff(ctx, w, resp, exchange)
 	if err != nil {
 		log.Printf("Error during proxy/sniff: %v", err)
+		return
 	}
-}
\ No newline at end of file
+	if recorder != nil {
+		h.cacheResponse(cacheKey, recorder, exchange)
+	}
+}
+
+// UseBudgets charges the estimated cost of requests to the SemaCostBudgets
+// covering the caller, and rejects requests over a hard-stop budget
+func (h *SemaHandler) UseBudgets(t *budget.Tracker) {
+	h.budgets = t
+}
+
+func (h *SemaHandler) charge(meta identity.PodMetadata) func(float64) {
+	if h.budgets == nil {
+		return nil
+	}
+	return func(cost float64) { h.budgets.Record(meta, cost) }
+}
+
+// UseCache answers repeated requests from c instead of the provider
+func (h *SemaHandler) UseCache(c *cache.Cache) {
+	h.cache = c
+}
+
+// serveCached answers from the response cache. Nothing is charged, but the
+// exchange is audited like any other.
+func (h *SemaHandler) serveCached(w http.ResponseWriter, k *cache.Key, e *cache.Entry, req sniffer.Request) {
+	meta := req.Meta
+	cache.Replay(w, k, e)
+	metrics.RequestsTotal.WithLabelValues(meta.Namespace, strconv.Itoa(e.Status)).Inc()
+
+	reason := "cache hit"
+	if k.Similarity() > 0 {
+		reason = fmt.Sprintf("semantic cache hit (rule %s, similarity %.3f)", k.Rule(), k.Similarity())
+	}
+	summary := sniffer.Summarize(req.Format(), e.Body, req.Body)
+	audit.Submit(audit.LogEntry{
+		Timestamp:      time.Now(),
+		Namespace:      meta.Namespace,
+		PodName:        meta.PodName,
+		Workload:       meta.Workload(),
+		Node:           meta.NodeName,
+		Labels:         meta.Promoted,
+		IdentitySource: meta.Source,
+		Model:          e.Model,
+		RequestedModel: req.RequestedModel,
+		Route:          req.Route,
+		PromptText:     summary.Prompt,
+		CompletionText: summary.Completion,
+		Decision:       "ALLOW",
+		Reason:         reason,
+	})
+}
+
+// cacheResponse stores a recorded response with what it cost, which is
+// what every later hit saves
+func (h *SemaHandler) cacheResponse(key *cache.Key, rec *cache.Recorder, req sniffer.Request) {
+	e := rec.Entry()
+	if e == nil {
+		return
+	}
+	summary := sniffer.Summarize(req.Format(), e.Body, req.Body)
+	e.Model, e.Cost = summary.Model, summary.Cost
+	if e.Model == "" {
+		e.Model = req.Model
+	}
+	h.cache.Store(key, e)
+}
+
+// UseRouter sends requests to the model and upstream its rules pick
+func (h *SemaHandler) UseRouter(r *routing.Router) {
+	h.router = r
+}
+
+// credentialHeaders carry the client's API key, for OpenAI, Azure OpenAI,
+// Anthropic and Gemini
+var credentialHeaders = []string{"Authorization", "Api-Key", "X-Api-Key", "X-Goog-Api-Key"}
+
+// setCredentials replaces the client's credentials with apiKey (none if
+// empty), in the header the provider expects
+func setCredentials(h http.Header, provider, apiKey string) {
+	for _, name := range credentialHeaders {
+		h.Del(name)
+	}
+	switch {
+	case apiKey == "":
+	case provider == sniffer.ProviderAnthropic:
+		h.Set("X-Api-Key", apiKey)
+	default:
+		h.Set("Authorization", "Bearer "+apiKey)
+	}
+}
+
+// UseTranslator sends the requests for its aliased models to the Anthropic
+// or Gemini API, in their format
+func (h *SemaHandler) UseTranslator(t *translate.Translator) {
+	h.translator = t
+}
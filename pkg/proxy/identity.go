@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/semamesh/SemaMesh/pkg/identity"
+)
+
+// UseVerifiers enables strong workload identity. Verified identities (mTLS
+// SVID or ServiceAccount token) take precedence over the source-IP mapping.
+// If requireVerified is set, requests without a verified identity are rejected.
+func (h *SemaHandler) UseVerifiers(requireVerified bool, verifiers ...identity.Verifier) {
+	h.verifiers = verifiers
+	h.requireVerified = requireVerified
+}
+
+// UseTrustedProxies honours X-Forwarded-For / Forwarded headers set by the
+// given proxies when looking up the caller's IP
+func (h *SemaHandler) UseTrustedProxies(resolver *identity.ClientIPResolver) {
+	h.clientIPs = resolver
+}
+
+// clientIP returns the caller's IP, looking through trusted proxies
+func (h *SemaHandler) clientIP(r *http.Request) string {
+	if h.clientIPs != nil {
+		return h.clientIPs.ClientIP(r)
+	}
+
+	hostIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		hostIP = r.RemoteAddr
+	}
+	return hostIP
+}
+
+// resolveIdentity works out who sent the request.
+// It returns ok=false (with a reason) when the request must be rejected.
+func (h *SemaHandler) resolveIdentity(r *http.Request) (identity.PodMetadata, bool, string) {
+	// 1. Source IP mapping (weak: breaks behind NAT and can be spoofed)
+	ipMeta, ipFound := h.identityMgr.GetIdentity(h.clientIP(r))
+
+	// 2. Strong identity, if the request carries a credential. A credential
+	// that doesn't check out rejects the request unless another one does.
+	var rejected error
+	for _, v := range h.verifiers {
+		meta, ok, err := v.Verify(r)
+		if err != nil {
+			if rejected == nil {
+				rejected = err
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		// The IP mapping can still add pod details (labels, workload...),
+		// but only if it agrees with the verified identity
+		if ipFound && ipMeta.Namespace == meta.Namespace && ipMeta.ServiceAccount == meta.ServiceAccount &&
+			(meta.PodName == "" || meta.PodName == ipMeta.PodName) {
+			ipMeta.Source = meta.Source
+			return ipMeta, true, ""
+		}
+		return meta, true, ""
+	}
+
+	if rejected != nil {
+		return identity.PodMetadata{}, false, rejected.Error()
+	}
+	if h.requireVerified {
+		return identity.PodMetadata{}, false, "no verified workload identity"
+	}
+
+	// 3. Dev mode: the client may pick its identity with a header
+	if meta, ok := h.identityMgr.DevHeaderIdentity(r.Header.Get(identity.DevIdentityHeader)); ok {
+		return meta, true, ""
+	}
+
+	if !ipFound {
+		return identity.PodMetadata{Namespace: "unknown-source"}, true, ""
+	}
+	return ipMeta, true, ""
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/semamesh/SemaMesh/pkg/identity"
+)
+
+// proxyProtoHeaderTimeout bounds how long a trusted peer has to send the header
+const proxyProtoHeaderTimeout = 5 * time.Second
+
+// proxyProtoV2Signature starts every PROXY protocol v2 header
+var proxyProtoV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtoListener accepts PROXY protocol v1/v2 headers from trusted peers
+// (load balancers, egress gateways) and reports the original client address
+// as the connection's RemoteAddr. Connections from untrusted peers are left
+// untouched, so nobody else can spoof their source.
+type ProxyProtoListener struct {
+	net.Listener
+	Trusted *identity.ClientIPResolver
+}
+
+// Accept wraps the connection; the header itself is read lazily by the
+// connection's own goroutine so a slow peer can't stall the accept loop.
+func (l *ProxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	peer, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !l.Trusted.IsTrusted(peer.IP) {
+		return conn, nil
+	}
+	return &proxyProtoConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+type proxyProtoConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtoConn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(proxyProtoHeaderTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	// A trusted peer may still send plain traffic (e.g. health checks)
+	prefix, err := c.reader.Peek(5)
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	switch {
+	case string(prefix) == "PROXY":
+		c.remoteAddr, c.err = readProxyProtoV1(c.reader)
+	case bytes.Equal(prefix, proxyProtoV2Signature[:5]):
+		c.remoteAddr, c.err = readProxyProtoV2(c.reader)
+	}
+
+	if c.err != nil {
+		log.Printf("PROXY_PROTOCOL: Invalid header from %s: %v", c.Conn.RemoteAddr(), c.err)
+	}
+}
+
+// readProxyProtoV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n"
+func readProxyProtoV1(r *bufio.Reader) (net.Addr, error) {
+	// The v1 header is at most 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("v1 header too long or not terminated")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed v1 header %q", strings.TrimSpace(string(line)))
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("malformed v1 source address")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyProtoV2 parses the binary v2 header
+func readProxyProtoV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:12], proxyProtoV2Signature) {
+		return nil, fmt.Errorf("bad v2 signature")
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version %d", header[12]>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL command: the proxy itself is talking (health check)
+	if header[12]&0x0F == 0 {
+		return nil, nil
+	}
+
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("short v2 IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("short v2 IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default:
+		// UDP, unix sockets...: keep the peer address
+		return nil, nil
+	}
+}
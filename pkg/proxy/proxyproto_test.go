@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/semamesh/SemaMesh/pkg/identity"
+)
+
+// proxyProtoV2 builds a v2 header: command 1 is PROXY, 0 is LOCAL
+func proxyProtoV2(command, family byte, addresses []byte) []byte {
+	h := append([]byte(nil), proxyProtoV2Signature...)
+	h = append(h, 0x20|command, family)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(addresses)))
+	return append(h, addresses...)
+}
+
+// ipv4Block is the v2 address block of 10.244.1.5:40000 -> 10.0.0.9:443
+var ipv4Block = []byte{10, 244, 1, 5, 10, 0, 0, 9, 0x9c, 0x40, 0x01, 0xbb}
+
+// acceptFrom sends data to a ProxyProtoListener from 127.0.0.1, then closes
+// the client side, and returns the accepted connection
+func acceptFrom(t *testing.T, trusted string, data []byte) net.Conn {
+	t.Helper()
+	resolver, err := identity.ParseTrustedProxies(trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &ProxyProtoListener{Listener: inner, Trusted: resolver}
+	t.Cleanup(func() { l.Close() })
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestProxyProtoListener(t *testing.T) {
+	payload := []byte("POST /v1/chat/completions HTTP/1.1\r\n")
+
+	tests := []struct {
+		name    string
+		trusted string
+		data    []byte
+		// wantAddr is the reported client; "" keeps the peer address
+		wantAddr string
+		wantBody []byte
+		wantErr  bool
+	}{
+		{
+			name:     "v1 TCP4",
+			trusted:  "127.0.0.1",
+			data:     append([]byte("PROXY TCP4 10.244.1.5 10.0.0.9 40000 443\r\n"), payload...),
+			wantAddr: "10.244.1.5:40000",
+			wantBody: payload,
+		},
+		{
+			name:     "v1 TCP6",
+			trusted:  "127.0.0.1",
+			data:     append([]byte("PROXY TCP6 2001:db8::5 2001:db8::9 40000 443\r\n"), payload...),
+			wantAddr: "[2001:db8::5]:40000",
+			wantBody: payload,
+		},
+		{
+			name:     "v1 UNKNOWN",
+			trusted:  "127.0.0.1",
+			data:     append([]byte("PROXY UNKNOWN\r\n"), payload...),
+			wantBody: payload,
+		},
+		{
+			name:    "v1 malformed address",
+			trusted: "127.0.0.1",
+			data:    append([]byte("PROXY TCP4 pod-a 10.0.0.9 40000 443\r\n"), payload...),
+			wantErr: true,
+		},
+		{
+			name:    "v1 not terminated",
+			trusted: "127.0.0.1",
+			data:    append([]byte("PROXY TCP4 10.244.1.5 10.0.0.9 40000 443"), bytes.Repeat([]byte(" "), 100)...),
+			wantErr: true,
+		},
+		{
+			name:     "v2 TCP over IPv4",
+			trusted:  "127.0.0.1",
+			data:     append(proxyProtoV2(1, 0x11, ipv4Block), payload...),
+			wantAddr: "10.244.1.5:40000",
+			wantBody: payload,
+		},
+		{
+			name:     "v2 LOCAL",
+			trusted:  "127.0.0.1",
+			data:     append(proxyProtoV2(0, 0x00, nil), payload...),
+			wantBody: payload,
+		},
+		{
+			name:    "v2 truncated header",
+			trusted: "127.0.0.1",
+			data:    proxyProtoV2(1, 0x11, ipv4Block)[:14],
+			wantErr: true,
+		},
+		{
+			name:    "v2 truncated address block",
+			trusted: "127.0.0.1",
+			data:    proxyProtoV2(1, 0x11, ipv4Block)[:20],
+			wantErr: true,
+		},
+		{
+			name:    "v2 address block too short for IPv4",
+			trusted: "127.0.0.1",
+			data:    append(proxyProtoV2(1, 0x11, ipv4Block[:4]), payload...),
+			wantErr: true,
+		},
+		{
+			name:    "v2 bad signature",
+			trusted: "127.0.0.1",
+			data:    append(append([]byte("\r\n\r\n\x00garbage!"), 0x21, 0x11, 0, 12), ipv4Block...),
+			wantErr: true,
+		},
+		{
+			name:     "trusted peer without a header",
+			trusted:  "127.0.0.1",
+			data:     payload,
+			wantBody: payload,
+		},
+		{
+			// Anyone else could claim any source: the header is passed on as
+			// data, and rejected by the HTTP server
+			name:     "header from an untrusted peer",
+			trusted:  "10.0.0.1",
+			data:     append([]byte("PROXY TCP4 10.244.1.5 10.0.0.9 40000 443\r\n"), payload...),
+			wantBody: append([]byte("PROXY TCP4 10.244.1.5 10.0.0.9 40000 443\r\n"), payload...),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := acceptFrom(t, tt.trusted, tt.data)
+
+			addr := conn.RemoteAddr().String()
+			if tt.wantAddr == "" {
+				if host, _, _ := net.SplitHostPort(addr); host != "127.0.0.1" {
+					t.Errorf("RemoteAddr = %s, want the peer address", addr)
+				}
+			} else if addr != tt.wantAddr {
+				t.Errorf("RemoteAddr = %s, want %s", addr, tt.wantAddr)
+			}
+
+			body, err := io.ReadAll(conn)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("read %q, want an error", body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(body, tt.wantBody) {
+				t.Errorf("read %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
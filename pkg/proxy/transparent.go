@@ -0,0 +1,412 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/semamesh/SemaMesh/pkg/metrics"
+	"github.com/semamesh/SemaMesh/pkg/sniffer"
+)
+
+// peekTimeout bounds how long a redirected client has to send its first bytes
+const peekTimeout = 10 * time.Second
+
+// TransparentHealthPath is answered on the transparent listener for the
+// agent's health probes, which connect from the node itself. They go through
+// the same HTTP server as redirected requests, so a wedged server fails them.
+const TransparentHealthPath = "/semamesh/healthz"
+
+// OriginalDstFunc returns where a redirected connection was headed, given its
+// remote address (see agent.OriginalDst)
+type OriginalDstFunc func(remote net.Addr) (*net.TCPAddr, bool)
+
+// TransparentConn describes a connection redirected by the eBPF interceptor
+type TransparentConn struct {
+	// Where the client was connecting to
+	OriginalDst *net.TCPAddr
+	// ServerName from the TLS ClientHello, if any
+	SNI string
+	// Intercepted is set when we terminated the client's TLS (MITM)
+	Intercepted bool
+}
+
+type transparentKey struct{}
+
+// TransparentFromContext returns the redirected connection a request came in
+// on, if any
+func TransparentFromContext(ctx context.Context) (*TransparentConn, bool) {
+	tc, ok := ctx.Value(transparentKey{}).(*TransparentConn)
+	return tc, ok
+}
+
+// TransparentProxy accepts connections redirected by the eBPF interceptor.
+// Plain HTTP is served by Handler, which forwards to the original destination
+// (see SemaHandler). TLS is passed through untouched to the original
+// destination, unless Intercept selects it for termination with TLSConfig
+// (see mitm.CA), in which case the decrypted requests go to Handler too.
+type TransparentProxy struct {
+	OriginalDst OriginalDstFunc
+	// Dialer for upstream connections; its Control must exempt them from
+	// redirection (see agent.DialControl)
+	Dialer *net.Dialer
+	// Handler serves plain HTTP requests
+	Handler http.Handler
+
+	// Intercept reports whether a TLS connection should be terminated
+	Intercept func(remote net.Addr, sni string) bool
+	TLSConfig *tls.Config
+
+	// MaxConns caps concurrent connections (0 = unlimited). Beyond it, Bypass
+	// decides whether a connection is spliced through uninspected or refused.
+	MaxConns int
+	Bypass   func(remote net.Addr, reason string) bool
+
+	active    int64
+	httpConns *connListener
+}
+
+// Serve accepts connections until the listener is closed
+func (t *TransparentProxy) Serve(l net.Listener) error {
+	t.httpConns = newConnListener(l.Addr())
+	defer t.httpConns.Close()
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := TransparentFromContext(r.Context()); !ok {
+				// A health probe: it must not be proxied anywhere
+				if r.URL.Path != TransparentHealthPath {
+					http.NotFound(w, r)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			t.Handler.ServeHTTP(w, r)
+		}),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if tc, ok := c.(interface{ transparentInfo() *TransparentConn }); ok {
+				return context.WithValue(ctx, transparentKey{}, tc.transparentInfo())
+			}
+			return ctx
+		},
+	}
+	go server.Serve(t.httpConns)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go t.handle(conn)
+	}
+}
+
+func (t *TransparentProxy) handle(conn net.Conn) {
+	dst, ok := t.OriginalDst(conn.RemoteAddr())
+	if !ok {
+		// Health probes connect from the node itself
+		if selfConnected(conn) {
+			if !t.httpConns.push(conn) {
+				conn.Close()
+			}
+			return
+		}
+		// Not redirected by us (or the entry was evicted): nowhere to send it
+		log.Printf("TRANSPARENT: No original destination for %s", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	// A TLS record can be up to 16KiB: make room to peek a whole ClientHello
+	pc := &peekedConn{Conn: conn, reader: bufio.NewReaderSize(conn, 16*1024+5), info: &TransparentConn{OriginalDst: dst}}
+
+	if t.MaxConns > 0 {
+		if atomic.AddInt64(&t.active, 1) > int64(t.MaxConns) {
+			atomic.AddInt64(&t.active, -1)
+			t.overloaded(conn, dst)
+			return
+		}
+		pc.onClose = func() { atomic.AddInt64(&t.active, -1) }
+	}
+
+	// The deadline covers the whole ClientHello, so a client that stalls
+	// halfway can't hold the connection (and its MaxConns slot)
+	conn.SetReadDeadline(time.Now().Add(peekTimeout))
+	first, err := pc.reader.Peek(1)
+	if err != nil {
+		pc.Close()
+		return
+	}
+
+	// 0x16 = TLS handshake record
+	if first[0] == 0x16 {
+		hello, err := peekClientHello(pc)
+		conn.SetReadDeadline(time.Time{})
+		if err == nil {
+			pc.info.SNI = hello.ServerName
+		} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			pc.Close()
+			return
+		} else {
+			log.Printf("TRANSPARENT: Unreadable ClientHello from %s: %v", conn.RemoteAddr(), err)
+		}
+		if t.Intercept != nil && t.TLSConfig != nil && pc.info.SNI != "" && t.Intercept(conn.RemoteAddr(), pc.info.SNI) {
+			t.intercept(pc)
+			return
+		}
+		t.passthrough(pc)
+		return
+	}
+
+	// Plain HTTP: the handler reads Host and routes to the original destination.
+	// The provider is only known per request.
+	conn.SetReadDeadline(time.Time{})
+	metrics.TransparentConnections.WithLabelValues("unknown", "http").Inc()
+	if !t.httpConns.push(pc) {
+		pc.Close()
+	}
+}
+
+// overloaded handles a connection we have no capacity to inspect: fail-open
+// pods get it spliced to the original destination, the others get it refused
+func (t *TransparentProxy) overloaded(conn net.Conn, dst *net.TCPAddr) {
+	defer conn.Close()
+
+	if t.Bypass == nil || !t.Bypass(conn.RemoteAddr(), "overloaded") {
+		metrics.TransparentConnections.WithLabelValues("unknown", "refused").Inc()
+		return
+	}
+	metrics.TransparentConnections.WithLabelValues("unknown", "bypass").Inc()
+
+	upstream, err := t.dialOriginal(dst)
+	if err != nil {
+		log.Printf("TRANSPARENT: Failed to reach %s for %s: %v", dst, conn.RemoteAddr(), err)
+		return
+	}
+	defer upstream.Close()
+	splice(conn, upstream)
+}
+
+func (t *TransparentProxy) dialOriginal(dst *net.TCPAddr) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), peekTimeout)
+	defer cancel()
+	return t.Dialer.DialContext(ctx, "tcp", dst.String())
+}
+
+// selfConnected reports whether conn comes from the node itself
+func selfConnected(conn net.Conn) bool {
+	remote, ok1 := conn.RemoteAddr().(*net.TCPAddr)
+	local, ok2 := conn.LocalAddr().(*net.TCPAddr)
+	return ok1 && ok2 && (remote.IP.IsLoopback() || remote.IP.Equal(local.IP))
+}
+
+// passthrough splices a TLS connection to its original destination
+func (t *TransparentProxy) passthrough(pc *peekedConn) {
+	defer pc.Close()
+
+	dst, sni := pc.info.OriginalDst, pc.info.SNI
+	provider := sniffer.ProviderForHost(sni)
+	metrics.TransparentConnections.WithLabelValues(provider, "tls_passthrough").Inc()
+
+	upstream, err := t.dialOriginal(dst)
+	if err != nil {
+		log.Printf("TRANSPARENT: Failed to reach %s (%s) for %s: %v", dst, sni, pc.RemoteAddr(), err)
+		return
+	}
+	defer upstream.Close()
+
+	log.Printf("TRANSPARENT: %s -> %s (sni=%s, provider=%s, passthrough)", pc.RemoteAddr(), dst, sni, provider)
+	splice(pc, upstream)
+}
+
+// intercept terminates the client's TLS with a minted certificate and hands
+// the decrypted connection to Handler
+func (t *TransparentProxy) intercept(pc *peekedConn) {
+	provider := sniffer.ProviderForHost(pc.info.SNI)
+	metrics.TransparentConnections.WithLabelValues(provider, "tls_intercept").Inc()
+
+	tlsConn := tls.Server(pc, t.TLSConfig)
+	ctx, cancel := context.WithTimeout(context.Background(), peekTimeout)
+	err := tlsConn.HandshakeContext(ctx)
+	cancel()
+	if err != nil {
+		// Usually a client that doesn't trust our CA (namespace bundle missing)
+		log.Printf("TRANSPARENT: TLS interception of %s (sni=%s) failed: %v", pc.RemoteAddr(), pc.info.SNI, err)
+		tlsConn.Close()
+		return
+	}
+
+	pc.info.Intercepted = true
+	if !t.httpConns.push(&interceptedConn{Conn: tlsConn, info: pc.info}) {
+		tlsConn.Close()
+	}
+}
+
+// UseDialer makes the handler's upstream calls go through d, e.g. to exempt
+// them from transparent redirection
+func (h *SemaHandler) UseDialer(d *net.Dialer) {
+	h.dialer = d
+}
+
+// dialContext connects to the original destination for transparent requests,
+// so we talk to the same provider endpoint the client picked
+func (h *SemaHandler) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if tc, ok := TransparentFromContext(ctx); ok && ctx.Value(reroutedKey{}) == nil {
+		addr = tc.OriginalDst.String()
+	}
+	return h.dialer.DialContext(ctx, network, addr)
+}
+
+type reroutedKey struct{}
+
+// rerouted marks requests a routing rule sent elsewhere than where the
+// client was going, so they are dialed by name
+func rerouted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reroutedKey{}, true)
+}
+
+// errHelloCaptured aborts the fake handshake once we have the ClientHello
+var errHelloCaptured = errors.New("client hello captured")
+
+// peekClientHello parses the ClientHello without consuming it, by running a
+// server handshake over a copy of the peeked bytes
+func peekClientHello(pc *peekedConn) (*tls.ClientHelloInfo, error) {
+	header, err := pc.reader.Peek(5)
+	if err != nil {
+		return nil, err
+	}
+	record, err := pc.reader.Peek(5 + (int(header[3])<<8 | int(header[4])))
+	if err != nil {
+		return nil, err
+	}
+
+	var hello *tls.ClientHelloInfo
+	fake := tls.Server(readOnlyConn{Conn: pc.Conn, r: bytes.NewReader(record)}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = h
+			return nil, errHelloCaptured
+		},
+	})
+	fake.Handshake()
+	if hello == nil {
+		return nil, errors.New("no ClientHello in the first TLS record")
+	}
+	return hello, nil
+}
+
+// readOnlyConn feeds recorded bytes to crypto/tls and swallows its replies
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c readOnlyConn) Write(b []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// peekedConn replays the bytes we peeked before reading from the socket
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+	info   *TransparentConn
+
+	// onClose runs once, on the first Close
+	onClose   func()
+	closeOnce sync.Once
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *peekedConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return c.Conn.Close()
+}
+
+func (c *peekedConn) transparentInfo() *TransparentConn { return c.info }
+
+func (c *peekedConn) CloseWrite() error {
+	if tcp, ok := c.Conn.(*net.TCPConn); ok {
+		return tcp.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// interceptedConn is a terminated TLS connection; it hides *tls.Conn from
+// http.Server so requests are served as plain HTTP/1.1
+type interceptedConn struct {
+	net.Conn
+	info *TransparentConn
+}
+
+func (c *interceptedConn) transparentInfo() *TransparentConn { return c.info }
+
+// connListener hands connections we already accepted to an http.Server
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *connListener) push(c net.Conn) bool {
+	select {
+	case l.conns <- c:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr { return l.addr }
+
+// splice copies both directions until either side is done
+func splice(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		// Propagate the half-close so the other direction can finish
+		if hc, ok := dst.(interface{ CloseWrite() error }); ok {
+			hc.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	go pipe(a, b)
+	go pipe(b, a)
+	wg.Wait()
+}
@@ -0,0 +1,307 @@
+// Package routing rewrites the model (and upstream) of LLM requests by
+// namespace, prompt size, time of day or budget consumption, e.g. to send
+// dev traffic to a cheaper model once its budget runs low
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/semamesh/SemaMesh/pkg/identity"
+)
+
+// bytesPerToken estimates prompt tokens from the request size
+const bytesPerToken = 4
+
+// File is the format of the --routing-config YAML file:
+//
+//	rules:
+//	  - name: ci-downgrade          # gpt-4 -> gpt-4o-mini for ci past 80% of its budget
+//	    models: [gpt-4, gpt-4-*]
+//	    namespaces: [ci]
+//	    budgetUsedAbove: 80
+//	    model: gpt-4o-mini
+//	  - name: long-prompts
+//	    models: [gpt-4o]
+//	    minPromptTokens: 50000
+//	    model: gpt-4o-mini
+//	  - name: dev-nights
+//	    namespaces: [dev-*]
+//	    hours: "20:00-07:00"
+//	    timeZone: Europe/Paris
+//	    model: llama-3.1-70b
+//	    target: http://vllm.ai.svc:8000
+//	    apiKey: ${VLLM_API_KEY}
+//
+// Rules are tried in order and the first match wins.
+type File struct {
+	Rules []RuleConfig `json:"rules"`
+}
+
+// RuleConfig matches requests and says where they go instead. Empty
+// matchers match everything; models and namespaces are globs.
+type RuleConfig struct {
+	Name       string   `json:"name"`
+	Models     []string `json:"models,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Prompt size bounds, estimated as 4 bytes of request per token
+	MinPromptTokens int `json:"minPromptTokens,omitempty"`
+	MaxPromptTokens int `json:"maxPromptTokens,omitempty"`
+
+	// Hours is a "HH:MM-HH:MM" window, which may span midnight
+	Hours string `json:"hours,omitempty"`
+	// Days limits the rule to some weekdays (Mon, Tue...)
+	Days []string `json:"days,omitempty"`
+	// TimeZone of Hours and Days (default UTC)
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// BudgetUsedAbove matches once the most consumed SemaCostBudget covering
+	// the caller has spent this percentage of its limit
+	BudgetUsedAbove *int `json:"budgetUsedAbove,omitempty"`
+
+	// Model the request is sent for
+	Model string `json:"model,omitempty"`
+	// Target is the base URL of another upstream (e.g. a self-hosted server)
+	Target string `json:"target,omitempty"`
+	// APIKey is sent to Target, whose host never gets the client's
+	// credentials; ${VAR} is read from the environment
+	APIKey string `json:"apiKey,omitempty"`
+}
+
+// Budgets report how much of their budgets callers have spent (see
+// budget.Tracker)
+type Budgets interface {
+	Used(meta identity.PodMetadata) float64
+}
+
+// Decision is where a request goes
+type Decision struct {
+	Rule  string
+	Model string
+	// Target is nil to keep the upstream
+	Target *url.URL
+	// APIKey is the credential for Target, if any
+	APIKey string
+}
+
+// Router applies the routing rules
+type Router struct {
+	// Budgets, if set, are checked by rules with BudgetUsedAbove. Without
+	// them those rules never match.
+	Budgets Budgets
+
+	rules []rule
+}
+
+type rule struct {
+	name       string
+	models     []string
+	namespaces []string
+	minTokens  int
+	maxTokens  int
+
+	window   bool
+	from, to int // minutes since midnight
+	days     map[time.Weekday]bool
+	location *time.Location
+
+	budgetUsed float64 // 0 to ignore budgets
+
+	model  string
+	target *url.URL
+	apiKey string
+}
+
+// Setup builds a router from the config file at path
+func Setup(path string) (*Router, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing config: %v", err)
+	}
+	var f File
+	if err := yaml.UnmarshalStrict(raw, &f); err != nil {
+		return nil, fmt.Errorf("invalid routing config: %v", err)
+	}
+	return New(f)
+}
+
+// New builds a router from a config file
+func New(f File) (*Router, error) {
+	r := &Router{}
+	for i, cfg := range f.Rules {
+		name := cfg.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i+1)
+		}
+		rl, err := newRule(name, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", name, err)
+		}
+		r.rules = append(r.rules, rl)
+	}
+	return r, nil
+}
+
+func newRule(name string, cfg RuleConfig) (rule, error) {
+	rl := rule{
+		name:       name,
+		models:     cfg.Models,
+		namespaces: cfg.Namespaces,
+		minTokens:  cfg.MinPromptTokens,
+		maxTokens:  cfg.MaxPromptTokens,
+		model:      cfg.Model,
+		apiKey:     os.ExpandEnv(cfg.APIKey),
+		location:   time.UTC,
+	}
+	if cfg.Model == "" && cfg.Target == "" {
+		return rl, fmt.Errorf("model or target is required")
+	}
+	if cfg.Target != "" {
+		u, err := url.Parse(cfg.Target)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return rl, fmt.Errorf("invalid target %q", cfg.Target)
+		}
+		// The request path is appended
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		rl.target = u
+	} else if cfg.APIKey != "" {
+		return rl, fmt.Errorf("apiKey requires a target")
+	}
+	for _, patterns := range [][]string{cfg.Models, cfg.Namespaces} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return rl, fmt.Errorf("invalid pattern %q", p)
+			}
+		}
+	}
+
+	if cfg.TimeZone != "" {
+		loc, err := time.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			return rl, fmt.Errorf("invalid timeZone: %v", err)
+		}
+		rl.location = loc
+	}
+	if cfg.Hours != "" {
+		from, to, ok := strings.Cut(cfg.Hours, "-")
+		var err1, err2 error
+		rl.from, err1 = parseClock(from)
+		rl.to, err2 = parseClock(to)
+		if !ok || err1 != nil || err2 != nil {
+			return rl, fmt.Errorf("invalid hours %q (HH:MM-HH:MM)", cfg.Hours)
+		}
+		rl.window = true
+	}
+	if len(cfg.Days) > 0 {
+		rl.days = make(map[time.Weekday]bool)
+		for _, d := range cfg.Days {
+			day, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return rl, fmt.Errorf("invalid day %q (Mon, Tue...)", d)
+			}
+			rl.days[day] = true
+		}
+	}
+
+	if cfg.BudgetUsedAbove != nil {
+		if *cfg.BudgetUsedAbove <= 0 {
+			return rl, fmt.Errorf("budgetUsedAbove must be positive")
+		}
+		rl.budgetUsed = float64(*cfg.BudgetUsedAbove) / 100
+	}
+	return rl, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Route returns where a request for model should go, and false to leave it
+// as it is
+func (r *Router) Route(meta identity.PodMetadata, model string, body []byte, now time.Time) (Decision, bool) {
+	tokens := len(body) / bytesPerToken
+	for i := range r.rules {
+		rl := &r.rules[i]
+		if !rl.matches(meta, model, tokens, now) {
+			continue
+		}
+		if rl.budgetUsed > 0 && (r.Budgets == nil || r.Budgets.Used(meta) < rl.budgetUsed) {
+			continue
+		}
+		d := Decision{Rule: rl.name, Model: rl.model, Target: rl.target, APIKey: rl.apiKey}
+		if d.Model == "" {
+			d.Model = model
+		}
+		return d, true
+	}
+	return Decision{}, false
+}
+
+func (rl *rule) matches(meta identity.PodMetadata, model string, tokens int, now time.Time) bool {
+	if !matchAny(rl.models, model) || !matchAny(rl.namespaces, meta.Namespace) {
+		return false
+	}
+	if tokens < rl.minTokens || (rl.maxTokens > 0 && tokens > rl.maxTokens) {
+		return false
+	}
+
+	local := now.In(rl.location)
+	if rl.days != nil && !rl.days[local.Weekday()] {
+		return false
+	}
+	if rl.window {
+		minute := local.Hour()*60 + local.Minute()
+		if rl.from <= rl.to {
+			return minute >= rl.from && minute < rl.to
+		}
+		// Spans midnight
+		return minute >= rl.from || minute < rl.to
+	}
+	return true
+}
+
+// matchAny matches v against glob patterns; no patterns match everything
+func matchAny(patterns []string, v string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Rewrite sets the model of a request body, leaving the other fields as
+// they are
+func Rewrite(body []byte, model string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	fields["model"] = raw
+	return json.Marshal(fields)
+}
@@ -2,15 +2,20 @@ package sniffer
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/semamesh/SemaMesh/pkg/audit"
+	"github.com/semamesh/SemaMesh/pkg/identity"
 	"github.com/semamesh/SemaMesh/pkg/metrics"
+	"github.com/semamesh/SemaMesh/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // --- Structures ---
@@ -36,88 +41,195 @@ type RequestPayload struct {
 	} `json:"messages"`
 }
 
+// Request is a request the proxy forwarded upstream
+type Request struct {
+	Meta identity.PodMetadata
+	Body []byte
+	// Provider selects the payload format (see ProviderForHost)
+	Provider string
+	// Translated is set when the provider's response was translated to the
+	// OpenAI format (see translate)
+	Translated bool
+	// Model and Stream as requested by the client (see ParseRequest), or as
+	// rewritten by routing
+	Model  string
+	Stream bool
+	// RequestedModel and Route are set when a routing rule changed the model
+	// or upstream
+	RequestedModel string
+	Route          string
+	// Sent is when the request went upstream
+	Sent time.Time
+	// Charge, if set, receives the estimated cost once the response is analyzed
+	Charge func(cost float64)
+}
+
+// Format is the API the request and response are read as
+func (r Request) Format() string {
+	if r.Translated {
+		return ProviderOpenAI
+	}
+	return r.Provider
+}
+
 // --- Logic ---
 
-func ProxyAndSniff(w http.ResponseWriter, upstreamResp *http.Response, namespace string, reqBody []byte) error {
-    // 1. Record the Request immediately 🚦
-    statusStr := strconv.Itoa(upstreamResp.StatusCode)
-    metrics.RequestsTotal.WithLabelValues(namespace, statusStr).Inc()
+// ProxyAndSniff streams the upstream response to the client and analyzes a
+// copy of it. The first response bytes are marked on the span in ctx, and
+// the analysis is traced as its child.
+func ProxyAndSniff(ctx context.Context, w http.ResponseWriter, upstreamResp *http.Response, req Request) error {
+	meta := req.Meta
+	labels := []string{meta.Namespace, req.Model, req.Provider}
 
-    // 2. Copy Headers
-    for k, v := range upstreamResp.Header {
-       w.Header()[k] = v
-    }
-    w.WriteHeader(upstreamResp.StatusCode)
+	// 1. Record the Request immediately 🚦
+	statusStr := strconv.Itoa(upstreamResp.StatusCode)
+	metrics.RequestsTotal.WithLabelValues(meta.Namespace, statusStr).Inc()
+	switch {
+	case upstreamResp.StatusCode == http.StatusTooManyRequests:
+		metrics.Errors.WithLabelValues(append(labels, metrics.ReasonProvider429)...).Inc()
+	case upstreamResp.StatusCode >= 500:
+		metrics.Errors.WithLabelValues(append(labels, metrics.ReasonProvider5xx)...).Inc()
+	}
+
+	// 2. Copy Headers
+	for k, v := range upstreamResp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(upstreamResp.StatusCode)
 
-    tapBuffer := bytes.NewBuffer(make([]byte, 0, 4096))
-    splitStream := io.TeeReader(upstreamResp.Body, tapBuffer)
+	tapBuffer := bytes.NewBuffer(make([]byte, 0, 4096))
+	body := &timedReader{r: upstreamResp.Body, onFirst: func() {
+		trace.SpanFromContext(ctx).AddEvent(tracing.FirstTokenEvent)
+	}}
+	splitStream := io.TeeReader(body, tapBuffer)
 
-    _, err := io.Copy(w, splitStream)
-    if err != nil {
-       return err
-    }
+	n, err := io.Copy(w, splitStream)
+	end := time.Now()
 
-    go analyze(tapBuffer.Bytes(), reqBody, namespace)
+	metrics.UpstreamDuration.WithLabelValues(labels...).Observe(end.Sub(req.Sent).Seconds())
+	metrics.ResponseSize.WithLabelValues(labels...).Observe(float64(n))
+	if req.Stream && !body.first.IsZero() {
+		metrics.TimeToFirstToken.WithLabelValues(labels...).Observe(body.first.Sub(req.Sent).Seconds())
+	}
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			metrics.Errors.WithLabelValues(append(labels, metrics.ReasonUpstreamTimeout)...).Inc()
+		}
+		return err
+	}
+
+	// Generation time: after the first token when streaming, the whole call otherwise
+	generation := end.Sub(req.Sent)
+	if req.Stream && !body.first.IsZero() {
+		generation = end.Sub(body.first)
+	}
+	go analyze(ctx, tapBuffer.Bytes(), req, generation)
+
+	return nil
+}
+
+// timedReader records when the first bytes were read
+type timedReader struct {
+	r       io.Reader
+	first   time.Time
+	onFirst func()
+}
 
-    return nil
+func (t *timedReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 && t.first.IsZero() {
+		t.first = time.Now()
+		t.onFirst()
+	}
+	return n, err
 }
 
 // ... imports ...
 
-func analyze(respData []byte, reqBody []byte, namespace string) {
-    if len(respData) == 0 {
-       return
-    }
-
-    // Parse Response (Partial)
-    var resp PartialResponse
-    json.Unmarshal(respData, &resp)
-
-    // Extract Prompt (Best Effort)
-    var req RequestPayload
-    promptText := "unknown"
-    if err := json.Unmarshal(reqBody, &req); err == nil && len(req.Messages) > 0 {
-       promptText = req.Messages[len(req.Messages)-1].Content
-    }
-
-    // Default Values
-    model := resp.Model
-    if model == "" {
-       model = "error-response"
-    }
-    completionText := ""
-    tokens := 0
-    cost := 0.0
-
-    // CASE 1: Success (Usage Data Exists)
-    if resp.Usage != nil {
-       tokens = resp.Usage.TotalTokens
-       cost = estimateCost(resp.Model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
-
-       if len(resp.Choices) > 0 {
-          completionText = resp.Choices[0].Message.Content
-       }
-
-       // Update Metrics
-       metrics.TokenCounter.WithLabelValues("prompt", resp.Model, namespace).Add(float64(resp.Usage.PromptTokens))
-       metrics.TokenCounter.WithLabelValues("completion", resp.Model, namespace).Add(float64(resp.Usage.CompletionTokens))
-       metrics.CostCounter.WithLabelValues(resp.Model, namespace).Add(cost)
-    } else {
-       // CASE 2: Error / No Usage Data 🚨
-       // We still want to log this!
-       completionText = "Request Failed / No Token Usage"
-    }
-
-    // Always Submit to Audit Log
-    audit.Submit(audit.LogEntry{
-       Timestamp:      time.Now(),
-       Namespace:      namespace,
-       Model:          model,
-       PromptText:     promptText,
-       CompletionText: completionText,
-       TotalTokens:    tokens,
-       CostEst:        cost,
-    })
+func analyze(ctx context.Context, respData []byte, req Request, generation time.Duration) {
+	if len(respData) == 0 {
+		return
+	}
+	_, span := tracing.Tracer().Start(ctx, "semamesh.analyze")
+	defer span.End()
+	meta, reqBody, provider := req.Meta, req.Body, req.Provider
+	namespace := meta.Namespace
+
+	// Parse Request/Response (Best Effort)
+	resp := parseExchange(req.Format(), respData, reqBody)
+	promptText := resp.Prompt
+
+	// Default Values
+	model := resp.Model
+	if model == "" {
+		model = "error-response"
+	}
+	completionText := ""
+	tokens := 0
+	cost := 0.0
+
+	// CASE 1: Success (Usage Data Exists)
+	if resp.Usage != nil {
+		tokens = resp.Usage.TotalTokens
+		cost = estimateCost(resp.Model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+		completionText = resp.Completion
+
+		// Update Metrics
+		metrics.TokenCounter.WithLabelValues("prompt", resp.Model, namespace).Add(float64(resp.Usage.PromptTokens))
+		metrics.TokenCounter.WithLabelValues("completion", resp.Model, namespace).Add(float64(resp.Usage.CompletionTokens))
+		metrics.CostCounter.WithLabelValues(resp.Model, namespace).Add(cost)
+		recordWorkloadUsage(meta, resp.Model, resp.Usage, cost)
+		if resp.Usage.CompletionTokens > 0 && generation > 0 {
+			metrics.OutputTokensPerSecond.WithLabelValues(namespace, req.Model, provider).Observe(float64(resp.Usage.CompletionTokens) / generation.Seconds())
+		}
+		span.SetAttributes(tracing.Usage(resp.Model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)...)
+		span.SetAttributes(tracing.CostKey.Float64(cost))
+		if req.Charge != nil {
+			req.Charge(cost)
+		}
+	} else {
+		// CASE 2: Error / No Usage Data 🚨
+		// We still want to log this!
+		completionText = "Request Failed / No Token Usage"
+	}
+
+	span.SetAttributes(tracing.DecisionKey.String("ALLOW"))
+
+	// Always Submit to Audit Log
+	audit.Submit(audit.LogEntry{
+		Timestamp:      time.Now(),
+		Namespace:      namespace,
+		PodName:        meta.PodName,
+		Workload:       meta.Workload(),
+		Node:           meta.NodeName,
+		Labels:         meta.Promoted,
+		IdentitySource: meta.Source,
+		Model:          model,
+		RequestedModel: req.RequestedModel,
+		Route:          req.Route,
+		PromptText:     promptText,
+		CompletionText: completionText,
+		TotalTokens:    tokens,
+		CostEst:        cost,
+		Decision:       "ALLOW",
+	})
+}
+
+// recordWorkloadUsage feeds the per-workload chargeback metrics
+func recordWorkloadUsage(meta identity.PodMetadata, model string, usage *OpenAIUsage, cost float64) {
+	if metrics.WorkloadTokenCounter == nil {
+		return
+	}
+
+	workload := []string{meta.Namespace, meta.WorkloadKind, meta.WorkloadName}
+	for _, name := range metrics.PromotedLabels {
+		workload = append(workload, meta.Promoted[name])
+	}
+
+	metrics.WorkloadTokenCounter.WithLabelValues(append([]string{"prompt", model}, workload...)...).Add(float64(usage.PromptTokens))
+	metrics.WorkloadTokenCounter.WithLabelValues(append([]string{"completion", model}, workload...)...).Add(float64(usage.CompletionTokens))
+	metrics.WorkloadCostCounter.WithLabelValues(append([]string{model}, workload...)...).Add(cost)
 }
 
 func estimateCost(model string, promptTokens, completionTokens int) float64 {
@@ -130,8 +242,26 @@ func estimateCost(model string, promptTokens, completionTokens int) float64 {
 	case strings.Contains(model, "gpt-3.5") || strings.Contains(model, "gpt-4o"):
 		promptPrice = 0.50 / 1000000.0
 		completionPrice = 1.50 / 1000000.0
+	case strings.Contains(model, "claude") && strings.Contains(model, "opus"):
+		promptPrice = 15.0 / 1000000.0
+		completionPrice = 75.0 / 1000000.0
+	case strings.Contains(model, "claude") && strings.Contains(model, "haiku"):
+		promptPrice = 0.80 / 1000000.0
+		completionPrice = 4.0 / 1000000.0
+	case strings.Contains(model, "claude"):
+		promptPrice = 3.0 / 1000000.0
+		completionPrice = 15.0 / 1000000.0
+	case strings.Contains(model, "gemini") && strings.Contains(model, "pro"):
+		promptPrice = 1.25 / 1000000.0
+		completionPrice = 10.0 / 1000000.0
+	case strings.Contains(model, "gemini") && strings.Contains(model, "flash-lite"):
+		promptPrice = 0.10 / 1000000.0
+		completionPrice = 0.40 / 1000000.0
+	case strings.Contains(model, "gemini") && strings.Contains(model, "flash"):
+		promptPrice = 0.30 / 1000000.0
+		completionPrice = 2.50 / 1000000.0
 	default:
 		return 0.0
 	}
 	return (float64(promptTokens) * promptPrice) + (float64(completionTokens) * completionPrice)
-}
\ No newline at end of file
+}
@@ -0,0 +1,169 @@
+package sniffer
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+)
+
+// Providers whose payloads we know how to read
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+)
+
+// ProviderForHost picks the payload format from the upstream host name.
+// Anything unknown is assumed to speak the OpenAI API, like most gateways
+// and self-hosted servers do.
+func ProviderForHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	switch {
+	case host == "api.anthropic.com" || strings.HasSuffix(host, ".anthropic.com"):
+		return ProviderAnthropic
+	default:
+		return ProviderOpenAI
+	}
+}
+
+// requestFields are the request fields shared by the OpenAI and Anthropic APIs
+type requestFields struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+}
+
+// ParseRequest reads the requested model ("unknown" if absent) and whether
+// the response is streamed
+func ParseRequest(reqBody []byte) (model string, stream bool) {
+	var req requestFields
+	if json.Unmarshal(reqBody, &req) != nil || req.Model == "" {
+		return "unknown", req.Stream
+	}
+	return req.Model, req.Stream
+}
+
+// exchange is what we extract from one request/response pair
+type exchange struct {
+	Model      string
+	Usage      *OpenAIUsage // nil if the response carried no usage
+	Prompt     string
+	Completion string
+}
+
+func parseExchange(provider string, respData, reqBody []byte) exchange {
+	if provider == ProviderAnthropic {
+		return parseAnthropic(respData, reqBody)
+	}
+	return parseOpenAI(respData, reqBody)
+}
+
+// Summary is what the audit log keeps of an exchange
+type Summary struct {
+	Model      string // "" if the response didn't say
+	Prompt     string
+	Completion string
+	Tokens     int
+	Cost       float64
+}
+
+// Summarize reads a complete (non-streamed) exchange, for callers that
+// answer without going upstream, like the response cache
+func Summarize(provider string, respData, reqBody []byte) Summary {
+	ex := parseExchange(provider, respData, reqBody)
+	s := Summary{Model: ex.Model, Prompt: ex.Prompt, Completion: ex.Completion}
+	if ex.Usage != nil {
+		s.Tokens = ex.Usage.TotalTokens
+		s.Cost = estimateCost(ex.Model, ex.Usage.PromptTokens, ex.Usage.CompletionTokens)
+	}
+	return s
+}
+
+func parseOpenAI(respData, reqBody []byte) exchange {
+	var resp PartialResponse
+	json.Unmarshal(respData, &resp)
+
+	ex := exchange{Model: resp.Model, Usage: resp.Usage, Prompt: "unknown"}
+	if len(resp.Choices) > 0 {
+		ex.Completion = resp.Choices[0].Message.Content
+	}
+
+	var req RequestPayload
+	if err := json.Unmarshal(reqBody, &req); err == nil && len(req.Messages) > 0 {
+		ex.Prompt = req.Messages[len(req.Messages)-1].Content
+	}
+	return ex
+}
+
+// --- Anthropic Messages API ---
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type AnthropicResponse struct {
+	Model   string          `json:"model"`
+	Usage   *AnthropicUsage `json:"usage"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+type AnthropicRequest struct {
+	Messages []struct {
+		// Either a plain string or a list of content blocks
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+}
+
+func parseAnthropic(respData, reqBody []byte) exchange {
+	var resp AnthropicResponse
+	json.Unmarshal(respData, &resp)
+
+	ex := exchange{Model: resp.Model, Prompt: "unknown"}
+	if resp.Usage != nil {
+		ex.Usage = &OpenAIUsage{
+			PromptTokens:     resp.Usage.InputTokens,
+			CompletionTokens: resp.Usage.OutputTokens,
+			TotalTokens:      resp.Usage.InputTokens + resp.Usage.OutputTokens,
+		}
+	}
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			ex.Completion += block.Text
+		}
+	}
+
+	var req AnthropicRequest
+	if err := json.Unmarshal(reqBody, &req); err == nil && len(req.Messages) > 0 {
+		ex.Prompt = anthropicText(req.Messages[len(req.Messages)-1].Content)
+	}
+	return ex
+}
+
+// anthropicText flattens message content (string or content blocks) to text
+func anthropicText(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "unknown"
+	}
+	var parts []string
+	for _, b := range blocks {
+		if b.Type == "text" {
+			parts = append(parts, b.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
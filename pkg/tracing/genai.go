@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"encoding/json"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// SemaMesh attributes, next to the GenAI semantic conventions
+const (
+	NamespaceKey = attribute.Key("k8s.namespace.name")
+	PodKey       = attribute.Key("k8s.pod.name")
+	DecisionKey  = attribute.Key("semamesh.decision")
+	CostKey      = attribute.Key("semamesh.cost_usd")
+	StreamKey    = attribute.Key("semamesh.request.stream")
+	CacheKey     = attribute.Key("semamesh.cache")
+
+	// Set when a routing rule rewrote the model or upstream
+	RouteKey          = attribute.Key("semamesh.route")
+	RequestedModelKey = attribute.Key("semamesh.request.original_model")
+)
+
+// FirstTokenEvent marks the first response bytes on the upstream span
+const FirstTokenEvent = "gen_ai.first_token"
+
+// genAIRequest holds the request fields shared by the OpenAI and Anthropic APIs
+type genAIRequest struct {
+	Model               string   `json:"model"`
+	MaxTokens           *int     `json:"max_tokens"`
+	MaxCompletionTokens *int     `json:"max_completion_tokens"`
+	Temperature         *float64 `json:"temperature"`
+	TopP                *float64 `json:"top_p"`
+	Stream              bool     `json:"stream"`
+}
+
+// Request returns the name of the upstream span (e.g. "chat gpt-4o") and its
+// gen_ai.* attributes, read from the request path and body
+func Request(provider, path string, body []byte) (string, []attribute.KeyValue) {
+	operation := semconv.GenAIOperationNameChat
+	switch {
+	case strings.HasSuffix(path, "/embeddings"):
+		operation = semconv.GenAIOperationNameEmbeddings
+	case strings.HasSuffix(path, "/completions") && !strings.HasSuffix(path, "/chat/completions"):
+		operation = semconv.GenAIOperationNameTextCompletion
+	}
+
+	attrs := []attribute.KeyValue{
+		operation,
+		semconv.GenAIProviderNameKey.String(provider),
+	}
+
+	var req genAIRequest
+	if json.Unmarshal(body, &req) != nil || req.Model == "" {
+		return operation.Value.AsString(), attrs
+	}
+
+	attrs = append(attrs, semconv.GenAIRequestModel(req.Model), StreamKey.Bool(req.Stream))
+	if req.MaxCompletionTokens != nil {
+		attrs = append(attrs, semconv.GenAIRequestMaxTokens(*req.MaxCompletionTokens))
+	} else if req.MaxTokens != nil {
+		attrs = append(attrs, semconv.GenAIRequestMaxTokens(*req.MaxTokens))
+	}
+	if req.Temperature != nil {
+		attrs = append(attrs, semconv.GenAIRequestTemperature(*req.Temperature))
+	}
+	if req.TopP != nil {
+		attrs = append(attrs, semconv.GenAIRequestTopP(*req.TopP))
+	}
+	return operation.Value.AsString() + " " + req.Model, attrs
+}
+
+// Usage returns the gen_ai.response.* and gen_ai.usage.* attributes
+func Usage(model string, inputTokens, outputTokens int) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.GenAIResponseModel(model),
+		semconv.GenAIUsageInputTokens(inputTokens),
+		semconv.GenAIUsageOutputTokens(outputTokens),
+	}
+}
@@ -0,0 +1,312 @@
+package translate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/semamesh/SemaMesh/pkg/sniffer"
+)
+
+// anthropicVersion is the Messages API version we speak
+const anthropicVersion = "2023-06-01"
+
+type anthropicAPI struct{}
+
+type anthropicRequest struct {
+	Model         string               `json:"model"`
+	System        string               `json:"system,omitempty"`
+	Messages      []anthropicMessage   `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Tools         []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *anthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type   string           `json:"type"`
+	Text   string           `json:"text,omitempty"`
+	Source *anthropicSource `json:"source,omitempty"`
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+}
+
+type anthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type anthropicResponse struct {
+	ID         string                  `json:"id"`
+	Model      string                  `json:"model"`
+	Content    []anthropicBlock        `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      *sniffer.AnthropicUsage `json:"usage"`
+}
+
+// anthropicEvent is an event of a streamed response
+type anthropicEvent struct {
+	Type         string             `json:"type"`
+	Message      *anthropicResponse `json:"message"`
+	Index        int                `json:"index"`
+	ContentBlock *anthropicBlock    `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *sniffer.AnthropicUsage `json:"usage"`
+}
+
+func (anthropicAPI) path(string, bool) string {
+	return "/v1/messages"
+}
+
+func (anthropicAPI) auth(h http.Header, apiKey string) {
+	h.Set("anthropic-version", anthropicVersion)
+	if apiKey != "" {
+		h.Set("x-api-key", apiKey)
+	}
+}
+
+func (anthropicAPI) request(t *Target, req *chatRequest) ([]byte, error) {
+	out := anthropicRequest{
+		Model:         t.model,
+		MaxTokens:     req.maxTokens(),
+		TopP:          req.TopP,
+		StopSequences: req.stop(),
+		Stream:        req.Stream,
+	}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = t.maxTokens
+	}
+	if req.Temperature != nil {
+		// OpenAI goes up to 2, Anthropic to 1
+		temperature := min(*req.Temperature, 1)
+		out.Temperature = &temperature
+	}
+
+	var system []string
+	for _, m := range req.Messages {
+		switch m.Role {
+		case "system", "developer":
+			text, err := m.text()
+			if err != nil {
+				return nil, err
+			}
+			system = append(system, text)
+		case "user":
+			blocks, err := anthropicContent(&m)
+			if err != nil {
+				return nil, err
+			}
+			out.Messages = appendAnthropic(out.Messages, "user", blocks)
+		case "assistant":
+			blocks, err := anthropicContent(&m)
+			if err != nil {
+				return nil, err
+			}
+			for _, call := range m.ToolCalls {
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: arguments(call.Function.Arguments)})
+			}
+			out.Messages = appendAnthropic(out.Messages, "assistant", blocks)
+		case "tool":
+			text, err := m.text()
+			if err != nil {
+				return nil, err
+			}
+			out.Messages = appendAnthropic(out.Messages, "user", []anthropicBlock{{Type: "tool_result", ToolUseID: m.ToolCallID, Content: text}})
+		default:
+			return nil, fmt.Errorf("%s messages are not supported by translated models", m.Role)
+		}
+	}
+	out.System = strings.Join(system, "\n\n")
+
+	for _, tool := range req.Tools {
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object"}`)
+		}
+		out.Tools = append(out.Tools, anthropicTool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: schema})
+	}
+	if len(out.Tools) > 0 {
+		switch mode, name := req.toolChoice(); {
+		case name != "":
+			out.ToolChoice = &anthropicToolChoice{Type: "tool", Name: name}
+		case mode == "required":
+			out.ToolChoice = &anthropicToolChoice{Type: "any"}
+		case mode == "none":
+			out.ToolChoice = &anthropicToolChoice{Type: "none"}
+		}
+	}
+	return json.Marshal(out)
+}
+
+// anthropicContent converts the text and images of a message
+func anthropicContent(m *chatMessage) ([]anthropicBlock, error) {
+	parts, err := m.parts()
+	if err != nil {
+		return nil, err
+	}
+	var blocks []anthropicBlock
+	for _, p := range parts {
+		switch {
+		case p.Type == "text" && p.Text != "":
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: p.Text})
+		case p.Type == "image_url" && p.ImageURL != nil:
+			source := &anthropicSource{Type: "url", URL: p.ImageURL.URL}
+			if mediaType, data, ok := dataURL(p.ImageURL.URL); ok {
+				source = &anthropicSource{Type: "base64", MediaType: mediaType, Data: data}
+			}
+			blocks = append(blocks, anthropicBlock{Type: "image", Source: source})
+		}
+	}
+	return blocks, nil
+}
+
+// appendAnthropic adds content to the conversation. Consecutive blocks of
+// the same role go in one message, as Anthropic wants roles to alternate
+// (e.g. the results of parallel tool calls).
+func appendAnthropic(messages []anthropicMessage, role string, blocks []anthropicBlock) []anthropicMessage {
+	if len(blocks) == 0 {
+		return messages
+	}
+	if n := len(messages); n > 0 && messages[n-1].Role == role {
+		messages[n-1].Content = append(messages[n-1].Content, blocks...)
+		return messages
+	}
+	return append(messages, anthropicMessage{Role: role, Content: blocks})
+}
+
+func (anthropicAPI) completion(body []byte) (*completion, error) {
+	var resp anthropicResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	msg := message{Role: "assistant"}
+	var text []string
+	for _, b := range resp.Content {
+		switch b.Type {
+		case "text":
+			text = append(text, b.Text)
+		case "tool_use":
+			msg.ToolCalls = append(msg.ToolCalls, toolCall{ID: b.ID, Type: "function", Function: functionCall{Name: b.Name, Arguments: string(arguments(string(b.Input)))}})
+		}
+	}
+	if len(text) > 0 || len(msg.ToolCalls) == 0 {
+		content := strings.Join(text, "")
+		msg.Content = &content
+	}
+
+	c := &completion{
+		ID:      resp.ID,
+		Model:   resp.Model,
+		Choices: []choice{{Message: &msg, FinishReason: finishReason(anthropicFinish(resp.StopReason))}},
+	}
+	if resp.Usage != nil {
+		c.Usage = openAIUsage(resp.Usage.InputTokens, resp.Usage.OutputTokens)
+	}
+	return c, nil
+}
+
+func (anthropicAPI) events(c *chunker) func(data []byte) []interface{} {
+	// Content block index -> tool call index
+	tools := make(map[int]int)
+	var input int
+	return func(data []byte) []interface{} {
+		var ev anthropicEvent
+		if json.Unmarshal(data, &ev) != nil {
+			return nil
+		}
+		switch ev.Type {
+		case "message_start":
+			if ev.Message == nil {
+				return nil
+			}
+			if ev.Message.ID != "" {
+				c.id = ev.Message.ID
+			}
+			if ev.Message.Model != "" {
+				c.model = ev.Message.Model
+			}
+			if ev.Message.Usage != nil {
+				input = ev.Message.Usage.InputTokens
+			}
+			empty := ""
+			return []interface{}{c.delta(message{Content: &empty})}
+		case "content_block_start":
+			if ev.ContentBlock == nil || ev.ContentBlock.Type != "tool_use" {
+				return nil
+			}
+			chunk, i := c.toolCall(ev.ContentBlock.ID, ev.ContentBlock.Name, "")
+			tools[ev.Index] = i
+			return []interface{}{chunk}
+		case "content_block_delta":
+			switch ev.Delta.Type {
+			case "text_delta":
+				return []interface{}{c.delta(message{Content: &ev.Delta.Text})}
+			case "input_json_delta":
+				i, ok := tools[ev.Index]
+				if !ok {
+					return nil
+				}
+				return []interface{}{c.delta(message{ToolCalls: []toolCall{{Index: &i, Function: functionCall{Arguments: ev.Delta.PartialJSON}}}})}
+			}
+		case "message_delta":
+			if ev.Delta.StopReason != "" {
+				c.finish = anthropicFinish(ev.Delta.StopReason)
+			}
+			if ev.Usage != nil {
+				c.usage = openAIUsage(input, ev.Usage.OutputTokens)
+			}
+		case "error":
+			return []interface{}{providerError(data)}
+		}
+		return nil
+	}
+}
+
+func anthropicFinish(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+func openAIUsage(prompt, completion int) *sniffer.OpenAIUsage {
+	return &sniffer.OpenAIUsage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
@@ -0,0 +1,363 @@
+package translate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type geminiAPI struct{}
+
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text string `json:"text,omitempty"`
+	// Thought marks thinking summaries, which aren't part of the answer
+	Thought          bool                    `json:"thought,omitempty"`
+	InlineData       *geminiBlob             `json:"inlineData,omitempty"`
+	FileData         *geminiFile             `json:"fileData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFile struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunction `json:"functionDeclarations"`
+}
+
+type geminiFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type geminiToolConfig struct {
+	FunctionCallingConfig struct {
+		Mode                 string   `json:"mode"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig"`
+}
+
+type geminiGenerationConfig struct {
+	MaxOutputTokens  int      `json:"maxOutputTokens,omitempty"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"topP,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"`
+}
+
+type geminiResponse struct {
+	ResponseID   string `json:"responseId"`
+	ModelVersion string `json:"modelVersion"`
+	Candidates   []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func (geminiAPI) path(model string, stream bool) string {
+	if stream {
+		return "/v1beta/models/" + url.PathEscape(model) + ":streamGenerateContent?alt=sse"
+	}
+	return "/v1beta/models/" + url.PathEscape(model) + ":generateContent"
+}
+
+func (geminiAPI) auth(h http.Header, apiKey string) {
+	if apiKey != "" {
+		h.Set("x-goog-api-key", apiKey)
+	}
+}
+
+func (geminiAPI) request(_ *Target, req *chatRequest) ([]byte, error) {
+	var out geminiRequest
+	gen := geminiGenerationConfig{
+		MaxOutputTokens: req.maxTokens(),
+		Temperature:     req.Temperature,
+		TopP:            req.TopP,
+		StopSequences:   req.stop(),
+	}
+	if req.ResponseFormat != nil && req.ResponseFormat.Type != "text" {
+		gen.ResponseMimeType = "application/json"
+	}
+	if gen.MaxOutputTokens != 0 || gen.Temperature != nil || gen.TopP != nil || gen.StopSequences != nil || gen.ResponseMimeType != "" {
+		out.GenerationConfig = &gen
+	}
+
+	// Gemini answers tool calls by function name, OpenAI by call ID
+	names := make(map[string]string)
+	var system []geminiPart
+	for _, m := range req.Messages {
+		switch m.Role {
+		case "system", "developer":
+			text, err := m.text()
+			if err != nil {
+				return nil, err
+			}
+			system = append(system, geminiPart{Text: text})
+		case "user", "assistant":
+			parts, err := geminiParts(&m)
+			if err != nil {
+				return nil, err
+			}
+			for _, call := range m.ToolCalls {
+				names[call.ID] = call.Function.Name
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: call.Function.Name, Args: arguments(call.Function.Arguments)}})
+			}
+			role := "user"
+			if m.Role == "assistant" {
+				role = "model"
+			}
+			out.Contents = appendGemini(out.Contents, role, parts)
+		case "tool":
+			text, err := m.text()
+			if err != nil {
+				return nil, err
+			}
+			name, ok := names[m.ToolCallID]
+			if !ok {
+				return nil, fmt.Errorf("tool message for unknown tool call %q", m.ToolCallID)
+			}
+			out.Contents = appendGemini(out.Contents, "user", []geminiPart{{FunctionResponse: &geminiFunctionResponse{Name: name, Response: functionResponse(text)}}})
+		default:
+			return nil, fmt.Errorf("%s messages are not supported by translated models", m.Role)
+		}
+	}
+	if len(system) > 0 {
+		out.SystemInstruction = &geminiContent{Parts: system}
+	}
+
+	if len(req.Tools) > 0 {
+		var functions []geminiFunction
+		for _, tool := range req.Tools {
+			functions = append(functions, geminiFunction{Name: tool.Function.Name, Description: tool.Function.Description, Parameters: geminiSchema(tool.Function.Parameters)})
+		}
+		out.Tools = []geminiTool{{FunctionDeclarations: functions}}
+
+		cfg := &geminiToolConfig{}
+		switch mode, name := req.toolChoice(); {
+		case name != "":
+			cfg.FunctionCallingConfig.Mode = "ANY"
+			cfg.FunctionCallingConfig.AllowedFunctionNames = []string{name}
+		case mode == "required":
+			cfg.FunctionCallingConfig.Mode = "ANY"
+		case mode == "none":
+			cfg.FunctionCallingConfig.Mode = "NONE"
+		default:
+			cfg = nil
+		}
+		out.ToolConfig = cfg
+	}
+	return json.Marshal(out)
+}
+
+// geminiParts converts the text and images of a message
+func geminiParts(m *chatMessage) ([]geminiPart, error) {
+	parts, err := m.parts()
+	if err != nil {
+		return nil, err
+	}
+	var out []geminiPart
+	for _, p := range parts {
+		switch {
+		case p.Type == "text" && p.Text != "":
+			out = append(out, geminiPart{Text: p.Text})
+		case p.Type == "image_url" && p.ImageURL != nil:
+			mediaType, data, ok := dataURL(p.ImageURL.URL)
+			if !ok {
+				// Fetched by Gemini, which may only accept the files it hosts
+				out = append(out, geminiPart{FileData: &geminiFile{FileURI: p.ImageURL.URL}})
+				continue
+			}
+			out = append(out, geminiPart{InlineData: &geminiBlob{MimeType: mediaType, Data: data}})
+		}
+	}
+	return out, nil
+}
+
+// appendGemini adds content to the conversation, merging consecutive parts
+// of the same role
+func appendGemini(contents []geminiContent, role string, parts []geminiPart) []geminiContent {
+	if len(parts) == 0 {
+		return contents
+	}
+	if n := len(contents); n > 0 && contents[n-1].Role == role {
+		contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+		return contents
+	}
+	return append(contents, geminiContent{Role: role, Parts: parts})
+}
+
+// functionResponse wraps a tool result in the object Gemini expects, unless
+// it is one already
+func functionResponse(text string) json.RawMessage {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal([]byte(text), &obj) == nil {
+		return json.RawMessage(text)
+	}
+	raw, _ := json.Marshal(map[string]string{"content": text})
+	return raw
+}
+
+// geminiSchema drops the JSON Schema keywords Gemini's OpenAPI subset
+// rejects
+func geminiSchema(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return nil
+	}
+	var schema interface{}
+	if json.Unmarshal(raw, &schema) != nil {
+		return raw
+	}
+	var clean func(v interface{})
+	clean = func(v interface{}) {
+		switch s := v.(type) {
+		case map[string]interface{}:
+			delete(s, "additionalProperties")
+			delete(s, "$schema")
+			delete(s, "strict")
+			for _, child := range s {
+				clean(child)
+			}
+		case []interface{}:
+			for _, child := range s {
+				clean(child)
+			}
+		}
+	}
+	clean(schema)
+	out, _ := json.Marshal(schema)
+	return out
+}
+
+func (geminiAPI) completion(body []byte) (*completion, error) {
+	var resp geminiResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	msg := message{Role: "assistant"}
+	finish := "stop"
+	if len(resp.Candidates) > 0 {
+		var text []string
+		for _, p := range resp.Candidates[0].Content.Parts {
+			switch {
+			case p.FunctionCall != nil:
+				msg.ToolCalls = append(msg.ToolCalls, toolCall{ID: newID("call_"), Type: "function", Function: functionCall{Name: p.FunctionCall.Name, Arguments: string(arguments(string(p.FunctionCall.Args)))}})
+			case p.Text != "" && !p.Thought:
+				text = append(text, p.Text)
+			}
+		}
+		if len(text) > 0 || len(msg.ToolCalls) == 0 {
+			content := strings.Join(text, "")
+			msg.Content = &content
+		}
+		finish = geminiFinish(resp.Candidates[0].FinishReason, len(msg.ToolCalls) > 0)
+	} else if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+		empty := ""
+		msg.Content, finish = &empty, "content_filter"
+	}
+
+	c := &completion{
+		ID:      resp.ResponseID,
+		Model:   resp.ModelVersion,
+		Choices: []choice{{Message: &msg, FinishReason: &finish}},
+	}
+	if c.ID == "" {
+		c.ID = newID("chatcmpl-")
+	}
+	if u := resp.UsageMetadata; u != nil {
+		c.Usage = openAIUsage(u.PromptTokenCount, u.CandidatesTokenCount+u.ThoughtsTokenCount)
+	}
+	return c, nil
+}
+
+func (geminiAPI) events(c *chunker) func(data []byte) []interface{} {
+	return func(data []byte) []interface{} {
+		var resp geminiResponse
+		if json.Unmarshal(data, &resp) != nil {
+			return nil
+		}
+		if resp.ModelVersion != "" {
+			c.model = resp.ModelVersion
+		}
+		if u := resp.UsageMetadata; u != nil {
+			c.usage = openAIUsage(u.PromptTokenCount, u.CandidatesTokenCount+u.ThoughtsTokenCount)
+		}
+		if len(resp.Candidates) == 0 {
+			if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+				c.finish = "content_filter"
+			}
+			return nil
+		}
+
+		var chunks []interface{}
+		cand := resp.Candidates[0]
+		for _, p := range cand.Content.Parts {
+			switch {
+			case p.FunctionCall != nil:
+				// Gemini sends whole calls: name and arguments come at once
+				chunk, _ := c.toolCall(newID("call_"), p.FunctionCall.Name, string(arguments(string(p.FunctionCall.Args))))
+				chunks = append(chunks, chunk)
+			case p.Text != "" && !p.Thought:
+				text := p.Text
+				chunks = append(chunks, c.delta(message{Content: &text}))
+			}
+		}
+		if cand.FinishReason != "" {
+			c.finish = geminiFinish(cand.FinishReason, c.tools > 0)
+		}
+		return chunks
+	}
+}
+
+func geminiFinish(reason string, toolCalls bool) string {
+	switch reason {
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "content_filter"
+	}
+	if toolCalls {
+		return "tool_calls"
+	}
+	return "stop"
+}
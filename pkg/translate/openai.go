@@ -0,0 +1,303 @@
+package translate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/semamesh/SemaMesh/pkg/sniffer"
+)
+
+// --- OpenAI chat completions, as sent by the client ---
+
+type chatRequest struct {
+	Model               string          `json:"model"`
+	Messages            []chatMessage   `json:"messages"`
+	Tools               []chatTool      `json:"tools"`
+	ToolChoice          json.RawMessage `json:"tool_choice"`
+	MaxTokens           *int            `json:"max_tokens"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens"`
+	Temperature         *float64        `json:"temperature"`
+	TopP                *float64        `json:"top_p"`
+	Stop                json.RawMessage `json:"stop"`
+	N                   *int            `json:"n"`
+	ResponseFormat      *struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+	Stream        bool `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+type chatMessage struct {
+	Role string `json:"role"`
+	// Content is a string, a list of parts or null
+	Content    json.RawMessage `json:"content"`
+	ToolCalls  []toolCall      `json:"tool_calls"`
+	ToolCallID string          `json:"tool_call_id"`
+}
+
+type contentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+type chatTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Parameters  json.RawMessage `json:"parameters"`
+	} `json:"function"`
+}
+
+type toolCall struct {
+	// Index is only set in streamed deltas
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function functionCall `json:"function"`
+}
+
+type functionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+func parseChatRequest(body []byte) (*chatRequest, error) {
+	var req chatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid chat completion request: %v", err)
+	}
+	if len(req.Messages) == 0 {
+		return nil, fmt.Errorf("invalid chat completion request: no messages")
+	}
+	if req.N != nil && *req.N > 1 {
+		return nil, fmt.Errorf("n > 1 is not supported by translated models")
+	}
+	return &req, nil
+}
+
+// maxTokens is the completion limit the client set, or 0
+func (r *chatRequest) maxTokens() int {
+	if r.MaxCompletionTokens != nil {
+		return *r.MaxCompletionTokens
+	}
+	if r.MaxTokens != nil {
+		return *r.MaxTokens
+	}
+	return 0
+}
+
+// stop reads the stop sequences (a string or a list)
+func (r *chatRequest) stop() []string {
+	var one string
+	if json.Unmarshal(r.Stop, &one) == nil {
+		if one == "" {
+			return nil
+		}
+		return []string{one}
+	}
+	var list []string
+	json.Unmarshal(r.Stop, &list)
+	return list
+}
+
+// toolChoice reads tool_choice: mode is auto, none or required, and name is
+// set when a given function is forced
+func (r *chatRequest) toolChoice() (mode, name string) {
+	if len(r.ToolChoice) == 0 {
+		return "", ""
+	}
+	if json.Unmarshal(r.ToolChoice, &mode) == nil {
+		return mode, ""
+	}
+	var forced struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	json.Unmarshal(r.ToolChoice, &forced)
+	return "required", forced.Function.Name
+}
+
+// parts reads message content as a list of parts
+func (m *chatMessage) parts() ([]contentPart, error) {
+	if len(m.Content) == 0 || string(m.Content) == "null" {
+		return nil, nil
+	}
+	var text string
+	if json.Unmarshal(m.Content, &text) == nil {
+		return []contentPart{{Type: "text", Text: text}}, nil
+	}
+	var parts []contentPart
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return nil, fmt.Errorf("invalid %s message content", m.Role)
+	}
+	for _, p := range parts {
+		if p.Type != "text" && p.Type != "image_url" {
+			return nil, fmt.Errorf("%s content parts are not supported by translated models", p.Type)
+		}
+	}
+	return parts, nil
+}
+
+// text joins the text parts of message content
+func (m *chatMessage) text() (string, error) {
+	parts, err := m.parts()
+	if err != nil {
+		return "", err
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// arguments returns tool call arguments as a JSON object
+func arguments(s string) json.RawMessage {
+	if s = strings.TrimSpace(s); s == "" || !json.Valid([]byte(s)) {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(s)
+}
+
+// dataURL splits a base64 data URL into its media type and data
+func dataURL(url string) (mediaType, data string, ok bool) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return "", "", false
+	}
+	meta, data, ok := strings.Cut(rest, ",")
+	mediaType, base64 := strings.CutSuffix(meta, ";base64")
+	if !ok || !base64 {
+		return "", "", false
+	}
+	return mediaType, data, true
+}
+
+// --- OpenAI chat completions, as returned to the client ---
+
+type completion struct {
+	ID      string               `json:"id"`
+	Object  string               `json:"object"`
+	Created int64                `json:"created"`
+	Model   string               `json:"model"`
+	Choices []choice             `json:"choices"`
+	Usage   *sniffer.OpenAIUsage `json:"usage,omitempty"`
+}
+
+type choice struct {
+	Index        int      `json:"index"`
+	Message      *message `json:"message,omitempty"`
+	Delta        *message `json:"delta,omitempty"`
+	FinishReason *string  `json:"finish_reason"`
+}
+
+type message struct {
+	Role      string     `json:"role,omitempty"`
+	Content   *string    `json:"content"`
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+}
+
+// errorBody is the OpenAI error format
+type errorBody struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// providerError reads an Anthropic ({"error": {"type", "message"}}) or Gemini
+// ({"error": {"status", "message"}}) error as an OpenAI one
+func providerError(body []byte) errorBody {
+	var in struct {
+		Error struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	var out errorBody
+	if json.Unmarshal(body, &in) != nil || in.Error.Message == "" {
+		out.Error.Message = strings.TrimSpace(string(bytes.ToValidUTF8(body, nil)))
+		out.Error.Type = "upstream_error"
+		return out
+	}
+	out.Error.Message = in.Error.Message
+	out.Error.Type = in.Error.Type
+	if out.Error.Type == "" {
+		out.Error.Type = strings.ToLower(in.Error.Status)
+	}
+	return out
+}
+
+// newID returns an ID for what the provider didn't name (e.g. "call_...")
+func newID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+func finishReason(s string) *string {
+	return &s
+}
+
+// chunker builds the chunks of a streamed completion
+type chunker struct {
+	id      string
+	model   string
+	created int64
+	// includeUsage adds a last chunk with the usage (stream_options.include_usage)
+	includeUsage bool
+
+	started bool
+	usage   *sniffer.OpenAIUsage
+	finish  string
+	tools   int
+}
+
+// delta is the next chunk; the first one carries the role
+func (c *chunker) delta(d message) completion {
+	if !c.started {
+		d.Role = "assistant"
+		c.started = true
+	}
+	return c.chunk([]choice{{Delta: &d}})
+}
+
+func (c *chunker) chunk(choices []choice) completion {
+	return completion{ID: c.id, Object: "chat.completion.chunk", Created: c.created, Model: c.model, Choices: choices}
+}
+
+// toolCall starts a streamed tool call and returns its index
+func (c *chunker) toolCall(id, name, args string) (completion, int) {
+	i := c.tools
+	c.tools++
+	return c.delta(message{ToolCalls: []toolCall{{Index: &i, ID: id, Type: "function", Function: functionCall{Name: name, Arguments: args}}}}), i
+}
+
+// end returns the last chunks: the finish reason, then the usage if asked
+func (c *chunker) end() []interface{} {
+	finish := c.finish
+	if finish == "" {
+		finish = "stop"
+	}
+	chunks := []interface{}{c.chunk([]choice{{Delta: &message{}, FinishReason: &finish}})}
+	if c.includeUsage && c.usage != nil {
+		last := c.chunk([]choice{})
+		last.Usage = c.usage
+		chunks = append(chunks, last)
+	}
+	return chunks
+}
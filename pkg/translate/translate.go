@@ -0,0 +1,326 @@
+// Package translate lets OpenAI clients use other providers: chat completion
+// requests for an aliased model are sent to the Anthropic Messages or Gemini
+// API, and their responses (streamed or not, with tool calls) come back in the
+// OpenAI format, so the rest of the proxy sees an OpenAI exchange
+package translate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/semamesh/SemaMesh/pkg/sniffer"
+)
+
+// Providers requests can be translated to
+const (
+	ProviderAnthropic = sniffer.ProviderAnthropic
+	ProviderGemini    = "gemini"
+)
+
+// Defaults of a translated model
+const (
+	DefaultAnthropicURL = "https://api.anthropic.com"
+	DefaultGeminiURL    = "https://generativelanguage.googleapis.com"
+	// DefaultMaxTokens is sent to Anthropic, which requires a limit
+	DefaultMaxTokens = 4096
+
+	maxResponseSize = 16 << 20
+)
+
+// File is the format of the --translation-config YAML file:
+//
+//	models:
+//	  claude:                   # the model clients ask for
+//	    provider: anthropic
+//	    model: claude-sonnet-4-5
+//	    apiKey: ${ANTHROPIC_API_KEY}
+//	  gemini-flash:
+//	    provider: gemini
+//	    model: gemini-2.5-flash
+//	    apiKey: ${GEMINI_API_KEY}
+type File struct {
+	Models map[string]ModelConfig `json:"models"`
+}
+
+// ModelConfig is where requests for an alias go
+type ModelConfig struct {
+	// Provider is anthropic or gemini
+	Provider string `json:"provider"`
+	// Model is the provider's name of the model
+	Model string `json:"model"`
+	// URL of the API, for proxies and compatible servers (default: the
+	// provider's)
+	URL string `json:"url,omitempty"`
+	// APIKey replaces the client's credentials; ${VAR} is read from the
+	// environment
+	APIKey string `json:"apiKey,omitempty"`
+	// MaxTokens is the completion limit when the client sets none (Anthropic
+	// only, default 4096)
+	MaxTokens int `json:"maxTokens,omitempty"`
+}
+
+// Translator knows the aliased models
+type Translator struct {
+	models map[string]*Target
+}
+
+// Target is a model served by another API
+type Target struct {
+	api       api
+	provider  string
+	model     string
+	url       *url.URL
+	apiKey    string
+	maxTokens int
+}
+
+// api converts between the OpenAI chat completions API and a provider's
+type api interface {
+	request(t *Target, req *chatRequest) ([]byte, error)
+	path(model string, stream bool) string
+	auth(h http.Header, apiKey string)
+	completion(body []byte) (*completion, error)
+	// events converts a streamed response event by event, to chunks and
+	// errors
+	events(c *chunker) func(data []byte) []interface{}
+}
+
+// Setup builds a translator from the config file at path
+func Setup(path string) (*Translator, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read translation config: %v", err)
+	}
+	var f File
+	if err := yaml.UnmarshalStrict(raw, &f); err != nil {
+		return nil, fmt.Errorf("invalid translation config: %v", err)
+	}
+	return New(f)
+}
+
+// New builds a translator from a config file
+func New(f File) (*Translator, error) {
+	t := &Translator{models: make(map[string]*Target)}
+	for alias, cfg := range f.Models {
+		target, err := newTarget(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("model %s: %v", alias, err)
+		}
+		t.models[alias] = target
+	}
+	return t, nil
+}
+
+func newTarget(cfg ModelConfig) (*Target, error) {
+	t := &Target{
+		provider:  cfg.Provider,
+		model:     cfg.Model,
+		apiKey:    os.ExpandEnv(cfg.APIKey),
+		maxTokens: DefaultMaxTokens,
+	}
+	base := cfg.URL
+	switch cfg.Provider {
+	case ProviderAnthropic:
+		t.api = anthropicAPI{}
+		if base == "" {
+			base = DefaultAnthropicURL
+		}
+	case ProviderGemini:
+		t.api = geminiAPI{}
+		if base == "" {
+			base = DefaultGeminiURL
+		}
+	default:
+		return nil, fmt.Errorf("unknown provider %q (anthropic or gemini)", cfg.Provider)
+	}
+	if cfg.Model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+	if cfg.MaxTokens > 0 {
+		t.maxTokens = cfg.MaxTokens
+	}
+	u, err := url.Parse(base)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid url %q", base)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	t.url = u
+	return t, nil
+}
+
+// Lookup returns the target of an aliased model
+func (t *Translator) Lookup(model string) (*Target, bool) {
+	target, ok := t.models[model]
+	return target, ok
+}
+
+// Provider is the API the target speaks
+func (t *Target) Provider() string {
+	return t.provider
+}
+
+// URL is the base URL of the target's API
+func (t *Target) URL() *url.URL {
+	return t.url
+}
+
+// Translation is one chat completion request sent to a target
+type Translation struct {
+	target       *Target
+	body         []byte
+	stream       bool
+	includeUsage bool
+}
+
+// Translate converts an OpenAI chat completion request for the target
+func (t *Target) Translate(body []byte) (*Translation, error) {
+	req, err := parseChatRequest(body)
+	if err != nil {
+		return nil, err
+	}
+	out, err := t.api.request(t, req)
+	if err != nil {
+		return nil, err
+	}
+	return &Translation{
+		target:       t,
+		body:         out,
+		stream:       req.Stream,
+		includeUsage: req.StreamOptions != nil && req.StreamOptions.IncludeUsage,
+	}, nil
+}
+
+// Request is the upstream request. It carries the target's credentials,
+// never the client's.
+func (tr *Translation) Request(ctx context.Context) (*http.Request, error) {
+	t := tr.target
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url.String()+t.api.path(t.model, tr.stream), bytes.NewReader(tr.body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	t.api.auth(req.Header, t.apiKey)
+	return req, nil
+}
+
+// Response converts the target's response to an OpenAI one. Complete
+// responses and errors are read here; streams are converted as they are read.
+func (tr *Translation) Response(resp *http.Response) (*http.Response, error) {
+	out := *resp
+	out.Header = resp.Header.Clone()
+	out.Header.Del("Content-Length")
+	out.Header.Del("Content-Encoding")
+	out.ContentLength = -1
+
+	if resp.StatusCode == http.StatusOK && tr.stream {
+		c := &chunker{
+			id:           newID("chatcmpl-"),
+			model:        tr.target.model,
+			created:      time.Now().Unix(),
+			includeUsage: tr.includeUsage,
+		}
+		out.Header.Set("Content-Type", "text/event-stream")
+		out.Body = &streamReader{events: bufio.NewReader(resp.Body), body: resp.Body, convert: tr.target.api.events(c), chunker: c}
+		return &out, nil
+	}
+
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	var converted interface{}
+	if resp.StatusCode == http.StatusOK {
+		c, err := tr.target.api.completion(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s response: %v", tr.target.provider, err)
+		}
+		c.Object, c.Created = "chat.completion", time.Now().Unix()
+		if c.Model == "" {
+			c.Model = tr.target.model
+		}
+		converted = c
+	} else {
+		converted = providerError(raw)
+	}
+	body, _ := json.Marshal(converted)
+	out.Header.Set("Content-Type", "application/json")
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	return &out, nil
+}
+
+// streamReader reads a provider's server-sent events as OpenAI chunks
+type streamReader struct {
+	events  *bufio.Reader
+	body    io.Closer
+	convert func(data []byte) []interface{}
+	chunker *chunker
+
+	buf  bytes.Buffer
+	done bool
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for s.buf.Len() == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		data, err := s.next()
+		switch {
+		case err == io.EOF:
+			s.write(s.chunker.end()...)
+			s.buf.WriteString("data: [DONE]\n\n")
+			s.done = true
+		case err != nil:
+			return 0, err
+		default:
+			s.write(s.convert(data)...)
+		}
+	}
+	return s.buf.Read(p)
+}
+
+// next returns the data of the next event
+func (s *streamReader) next() ([]byte, error) {
+	var data []byte
+	for {
+		line, err := s.events.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		if d, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			data = append(data, bytes.TrimPrefix(d, []byte(" "))...)
+		}
+		if len(line) == 0 && len(data) > 0 {
+			return data, nil
+		}
+		if err != nil {
+			if err == io.EOF && len(data) > 0 {
+				return data, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+func (s *streamReader) write(chunks ...interface{}) {
+	for _, c := range chunks {
+		raw, _ := json.Marshal(c)
+		s.buf.WriteString("data: ")
+		s.buf.Write(raw)
+		s.buf.WriteString("\n\n")
+	}
+}
+
+func (s *streamReader) Close() error {
+	return s.body.Close()
+}
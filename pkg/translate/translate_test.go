@@ -0,0 +1,248 @@
+package translate
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// streamRequest asks for a streamed completion with usage
+const streamRequest = `{
+	"model": "alias",
+	"messages": [{"role": "user", "content": "Weather in Paris?"}],
+	"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}],
+	"stream": true,
+	"stream_options": {"include_usage": true}
+}`
+
+// streamResponse translates streamRequest for provider and returns what the
+// client reads when the provider streams events
+func streamResponse(t *testing.T, provider, events string) string {
+	t.Helper()
+	tr, err := New(File{Models: map[string]ModelConfig{"alias": {Provider: provider, Model: "upstream-model"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := tr.Lookup("alias")
+	translation, err := target.Translate([]byte(streamRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := translation.Response(&http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(events)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+// convertStream parses the chunks of streamResponse
+func convertStream(t *testing.T, provider, events string) (chunks []completion, done bool) {
+	t.Helper()
+	raw := streamResponse(t, provider, events)
+	for _, event := range strings.Split(strings.TrimSuffix(raw, "\n\n"), "\n\n") {
+		data, ok := strings.CutPrefix(event, "data: ")
+		if !ok {
+			t.Fatalf("not an SSE data event: %q", event)
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		if done {
+			t.Errorf("chunk after [DONE]: %s", data)
+		}
+		var c completion
+		if err := json.Unmarshal([]byte(data), &c); err != nil {
+			t.Fatalf("invalid chunk %s: %v", data, err)
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, done
+}
+
+// streamed sums up chunks the way an OpenAI client does
+type streamed struct {
+	role, content, finish string
+	calls                 map[int]*toolCall
+	usage                 [3]int
+}
+
+func collect(t *testing.T, chunks []completion) streamed {
+	t.Helper()
+	s := streamed{calls: make(map[int]*toolCall)}
+	for _, c := range chunks {
+		if c.Object != "chat.completion.chunk" || c.ID == "" {
+			t.Errorf("chunk object %q, id %q", c.Object, c.ID)
+		}
+		if c.Usage != nil {
+			s.usage = [3]int{c.Usage.PromptTokens, c.Usage.CompletionTokens, c.Usage.TotalTokens}
+		}
+		for _, ch := range c.Choices {
+			if ch.FinishReason != nil {
+				s.finish = *ch.FinishReason
+			}
+			d := ch.Delta
+			if d == nil {
+				continue
+			}
+			s.role += d.Role
+			if d.Content != nil {
+				s.content += *d.Content
+			}
+			for _, call := range d.ToolCalls {
+				if call.Index == nil {
+					t.Fatalf("tool call delta without index")
+				}
+				acc, ok := s.calls[*call.Index]
+				if !ok {
+					acc = &toolCall{ID: call.ID, Type: call.Type, Function: functionCall{Name: call.Function.Name}}
+					s.calls[*call.Index] = acc
+				}
+				acc.Function.Arguments += call.Function.Arguments
+			}
+		}
+	}
+	return s
+}
+
+func TestAnthropicStream(t *testing.T) {
+	// CRLF line endings and event lines, as sent on the wire
+	events := strings.ReplaceAll(`event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4-5","usage":{"input_tokens":10,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me "}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"check."}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":" \"Paris\"}"}}
+
+event: ping
+data: {"type":"ping"}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":5}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`, "\n", "\r\n")
+
+	chunks, done := convertStream(t, ProviderAnthropic, events)
+	if !done {
+		t.Error("stream not terminated with [DONE]")
+	}
+	for _, c := range chunks {
+		if c.ID != "msg_1" || c.Model != "claude-sonnet-4-5" {
+			t.Errorf("chunk id %q, model %q, want the message's", c.ID, c.Model)
+		}
+	}
+
+	s := collect(t, chunks)
+	if s.role != "assistant" {
+		t.Errorf("role = %q, want assistant once", s.role)
+	}
+	if s.content != "Let me check." {
+		t.Errorf("content = %q", s.content)
+	}
+	call, ok := s.calls[0]
+	if len(s.calls) != 1 || !ok {
+		t.Fatalf("tool calls = %v, want one at index 0", s.calls)
+	}
+	if call.ID != "toolu_1" || call.Type != "function" || call.Function.Name != "get_weather" || call.Function.Arguments != `{"city": "Paris"}` {
+		t.Errorf("tool call = %+v", *call)
+	}
+	if s.finish != "tool_calls" {
+		t.Errorf("finish reason = %q, want tool_calls", s.finish)
+	}
+	if s.usage != [3]int{10, 5, 15} {
+		t.Errorf("usage = %v, want [10 5 15]", s.usage)
+	}
+	// The usage chunk comes last, with no choices
+	if last := chunks[len(chunks)-1]; last.Usage == nil || len(last.Choices) != 0 {
+		t.Errorf("last chunk = %+v, want the usage alone", last)
+	}
+}
+
+func TestAnthropicStreamError(t *testing.T) {
+	events := `data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4-5"}}
+
+data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}
+`
+	raw := streamResponse(t, ProviderAnthropic, events)
+	// The last event has no blank line after it: it is still converted
+	if !strings.Contains(raw, `data: {"error":{"message":"Overloaded","type":"overloaded_error"}}`) {
+		t.Errorf("error not converted:\n%s", raw)
+	}
+	if !strings.HasSuffix(raw, "data: [DONE]\n\n") {
+		t.Errorf("stream not terminated with [DONE]:\n%s", raw)
+	}
+}
+
+func TestGeminiStream(t *testing.T) {
+	events := `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Thinking...","thought":true}]}}],"modelVersion":"gemini-2.5-flash"}
+
+data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Let me check."}]}}],"modelVersion":"gemini-2.5-flash"}
+
+data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":4,"thoughtsTokenCount":2},"modelVersion":"gemini-2.5-flash"}
+
+`
+	chunks, done := convertStream(t, ProviderGemini, events)
+	if !done {
+		t.Error("stream not terminated with [DONE]")
+	}
+
+	s := collect(t, chunks)
+	if s.role != "assistant" {
+		t.Errorf("role = %q, want assistant once", s.role)
+	}
+	// Thoughts are not part of the answer
+	if s.content != "Let me check." {
+		t.Errorf("content = %q", s.content)
+	}
+	call, ok := s.calls[0]
+	if len(s.calls) != 1 || !ok {
+		t.Fatalf("tool calls = %v, want one at index 0", s.calls)
+	}
+	if !strings.HasPrefix(call.ID, "call_") || call.Function.Name != "get_weather" || call.Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("tool call = %+v", *call)
+	}
+	// Gemini says STOP after a function call
+	if s.finish != "tool_calls" {
+		t.Errorf("finish reason = %q, want tool_calls", s.finish)
+	}
+	// Thinking tokens are billed as completion tokens
+	if s.usage != [3]int{10, 6, 16} {
+		t.Errorf("usage = %v, want [10 6 16]", s.usage)
+	}
+	for _, c := range chunks {
+		if c.Model != "gemini-2.5-flash" {
+			t.Errorf("chunk model %q, want the model version", c.Model)
+		}
+	}
+}